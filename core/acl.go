@@ -0,0 +1,280 @@
+package huacache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Permission is a set of operations a user may perform on a group.
+type Permission uint8
+
+const (
+	PermRead  Permission = 1 << iota // 读取 key
+	PermWrite                        // 写入、删除 key
+	PermAdmin                        // 创建、删除分组，以及 save 等节点级命令
+)
+
+// ErrAuthFailed is returned for unknown users and wrong passwords or tokens.
+var ErrAuthFailed = errors.New("invalid username or password")
+
+// RequiredPermission returns the permission a command needs on its group.
+// Commands that are not bound to a group, like save or sync, need
+// PermAdmin on every group, see User.Can.
+func RequiredPermission(command string) Permission {
+	switch command {
+	case GET_KEY, MGET_KEYS, GET_KEYS, LIST_GROUP, STATS, LEASE_GET, GET_ITEM:
+		return PermRead
+	case SET_KEY, DEL_KEY, MSET_KEYS, MDEL_KEYS, INCR, DECR, ADD_KEY, REPLACE_KEY, CAS, LEASE_SET, PUT_ITEM:
+		return PermWrite
+	}
+	return PermAdmin
+}
+
+// ACLRule grants Perms on the groups whose name matches Pattern, a glob as
+// accepted by path.Match, e.g. "orders-*".
+type ACLRule struct {
+	Pattern string
+	Perms   Permission
+}
+
+// ParseACLRule parses "<perms>:<pattern>", perms being any of r (read),
+// w (write) and a (admin), e.g. "rw:orders-*".
+func ParseACLRule(s string) (ACLRule, error) {
+	perms, pattern, ok := strings.Cut(s, ":")
+	if !ok || perms == "" || pattern == "" {
+		return ACLRule{}, fmt.Errorf("invalid rule %q, expect <perms>:<pattern>", s)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return ACLRule{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	rule := ACLRule{Pattern: pattern}
+	for _, c := range perms {
+		switch c {
+		case 'r':
+			rule.Perms |= PermRead
+		case 'w':
+			rule.Perms |= PermWrite
+		case 'a':
+			rule.Perms |= PermAdmin
+		default:
+			return ACLRule{}, fmt.Errorf("invalid permission %q in rule %q", c, s)
+		}
+	}
+	return rule, nil
+}
+
+// User is an authenticated principal and the groups it may access.
+type User struct {
+	Name  string
+	Rules []ACLRule
+}
+
+// Can reports whether the user has perm on group. The empty group stands
+// for the whole node and is only granted by rules matching every group,
+// i.e. with the pattern "*".
+func (u *User) Can(group string, perm Permission) bool {
+	for _, rule := range u.Rules {
+		if rule.Perms&perm != perm {
+			continue
+		}
+		if group == "" {
+			if rule.Pattern == "*" {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(rule.Pattern, group); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL authenticates users by name and password, by token alone, or by the
+// common name of a verified client certificate. Secrets are kept as SHA-256
+// digests. It is safe for concurrent use.
+type ACL struct {
+	mu        sync.RWMutex
+	passwords map[string][]byte // 用户名 -> 密码摘要
+	tokens    map[string]*User  // 令牌摘要 -> 用户
+	certs     map[string]*User  // 证书的 common name -> 用户
+	users     map[string]*User
+}
+
+func NewACL() *ACL {
+	return &ACL{
+		passwords: make(map[string][]byte),
+		tokens:    make(map[string]*User),
+		certs:     make(map[string]*User),
+		users:     make(map[string]*User),
+	}
+}
+
+// digest 计算密钥的摘要，"sha256:<hex>" 形式的密钥本身就是摘要
+func digest(secret string) ([]byte, error) {
+	if hexDigest, ok := strings.CutPrefix(secret, "sha256:"); ok {
+		sum, err := hex.DecodeString(hexDigest)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 digest %q", hexDigest)
+		}
+		return sum, nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:], nil
+}
+
+// AddUser registers a user logging in with name and password. password
+// may be given as "sha256:<hex digest>".
+func (a *ACL) AddUser(name, password string, rules ...ACLRule) error {
+	sum, err := digest(password)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.users[name]; ok {
+		return fmt.Errorf("user %s already exists", name)
+	}
+	a.users[name] = &User{Name: name, Rules: rules}
+	a.passwords[name] = sum
+	return nil
+}
+
+// AddToken registers a user logging in with token alone. token may be
+// given as "sha256:<hex digest>".
+func (a *ACL) AddToken(name, token string, rules ...ACLRule) error {
+	sum, err := digest(token)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.users[name]; ok {
+		return fmt.Errorf("user %s already exists", name)
+	}
+	if _, ok := a.tokens[string(sum)]; ok {
+		return fmt.Errorf("token of user %s is already in use", name)
+	}
+	user := &User{Name: name, Rules: rules}
+	a.users[name] = user
+	a.tokens[string(sum)] = user
+	return nil
+}
+
+// AddCertUser registers a user identified by the common name of its client
+// certificate, e.g. a peer node connecting with mutual TLS.
+func (a *ACL) AddCertUser(name string, rules ...ACLRule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.users[name]; ok {
+		return fmt.Errorf("user %s already exists", name)
+	}
+	user := &User{Name: name, Rules: rules}
+	a.users[name] = user
+	a.certs[name] = user
+	return nil
+}
+
+// AuthenticateCert returns the user registered with AddCertUser for the
+// common name of a verified client certificate, see PeerIdentity.
+func (a *ACL) AuthenticateCert(name string) (*User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if user, ok := a.certs[name]; ok {
+		return user, nil
+	}
+	return nil, ErrAuthFailed
+}
+
+// Authenticate checks the password of name, or the token in secret when
+// name is empty.
+func (a *ACL) Authenticate(name, secret string) (*User, error) {
+	sum := sha256.Sum256([]byte(secret))
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if name == "" {
+		if user, ok := a.tokens[string(sum[:])]; ok {
+			return user, nil
+		}
+		return nil, ErrAuthFailed
+	}
+	want, ok := a.passwords[name]
+	if !ok || subtle.ConstantTimeCompare(want, sum[:]) != 1 {
+		return nil, ErrAuthFailed
+	}
+	return a.users[name], nil
+}
+
+// LoadACL reads an ACL file. Each line declares a user logging in with a
+// password, a token or a client certificate, followed by its rules:
+//
+//	user  <name> <password> <rule>...
+//	token <name> <token> <rule>...
+//	cert  <name> <rule>...
+//
+// Empty lines and lines starting with # are ignored.
+func LoadACL(r io.Reader) (*ACL, error) {
+	acl := NewACL()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		kind, name, secret, ruleFields := fields[0], "", "", []string(nil)
+		switch {
+		case kind == "cert" && len(fields) >= 2:
+			// 证书用户没有密钥
+			name, ruleFields = fields[1], fields[2:]
+		case kind != "cert" && len(fields) >= 3:
+			name, secret, ruleFields = fields[1], fields[2], fields[3:]
+		default:
+			return nil, fmt.Errorf("line %d: expect <user|token> <name> <secret> <rule>... or cert <name> <rule>...", n)
+		}
+		rules := make([]ACLRule, 0, len(ruleFields))
+		for _, s := range ruleFields {
+			rule, err := ParseACLRule(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			rules = append(rules, rule)
+		}
+		var err error
+		switch kind {
+		case "user":
+			err = acl.AddUser(name, secret, rules...)
+		case "token":
+			err = acl.AddToken(name, secret, rules...)
+		case "cert":
+			err = acl.AddCertUser(name, rules...)
+		default:
+			err = fmt.Errorf("unknown entry %q", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// LoadACLFile reads the ACL file at name.
+func LoadACLFile(name string) (*ACL, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadACL(f)
+}
@@ -0,0 +1,118 @@
+package huacache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestACLRules(t *testing.T) {
+	if _, err := ParseACLRule("rx:orders"); err == nil {
+		t.Fatalf("expect an error for an unknown permission")
+	}
+	if _, err := ParseACLRule("orders"); err == nil {
+		t.Fatalf("expect an error for a rule without permissions")
+	}
+	rw, _ := ParseACLRule("rw:orders-*")
+	r, _ := ParseACLRule("r:*")
+	user := &User{Name: "alice", Rules: []ACLRule{rw, r}}
+	cases := []struct {
+		group string
+		perm  Permission
+		want  bool
+	}{
+		{"orders-eu", PermRead | PermWrite, true},
+		{"users", PermRead, true},
+		{"users", PermWrite, false},
+		{"orders-eu", PermAdmin, false},
+		{"", PermRead, true},
+		{"", PermWrite, false},
+	}
+	for _, c := range cases {
+		if got := user.Can(c.group, c.perm); got != c.want {
+			t.Errorf("Can(%q, %d) = %t, expect %t", c.group, c.perm, got, c.want)
+		}
+	}
+}
+
+func TestLoadACL(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	acl, err := LoadACL(strings.NewReader(`
+# 管理员
+user  admin sha256:` + hex.EncodeToString(sum[:]) + ` rwa:*
+token app   t0ken  r:orders
+`))
+	if err != nil {
+		t.Fatalf("load acl failed: %v", err)
+	}
+	if user, err := acl.Authenticate("admin", "secret"); err != nil || user.Name != "admin" {
+		t.Fatalf("admin should log in: %v", err)
+	}
+	if _, err := acl.Authenticate("admin", "wrong"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expect ErrAuthFailed for a wrong password, got %v", err)
+	}
+	if user, err := acl.Authenticate("", "t0ken"); err != nil || user.Name != "app" || !user.Can("orders", PermRead) {
+		t.Fatalf("token should log in as app: %v", err)
+	}
+	if _, err := acl.Authenticate("app", "t0ken"); err == nil {
+		t.Fatalf("a token user has no password")
+	}
+	if _, err := LoadACL(strings.NewReader("user bob\n")); err == nil {
+		t.Fatalf("expect an error for an incomplete line")
+	}
+}
+
+func TestHTTPPoolACL(t *testing.T) {
+	acl := NewACL()
+	acl.AddUser("reader", "pw", ACLRule{Pattern: "http-acl", Perms: PermRead})
+	acl.AddToken("writer", "t0ken", ACLRule{Pattern: "http-acl", Perms: PermRead | PermWrite})
+	if _, err := NewGroup("http-acl", 1<<20); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	pool := NewHTTPPool("self")
+	pool.SetACL(acl)
+
+	do := func(action, form string, auth func(r *http.Request)) int {
+		// 处理函数按 multipart 表单解析请求
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		values, _ := url.ParseQuery(form)
+		for k := range values {
+			mw.WriteField(k, values.Get(k))
+		}
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, defaultBasePath+action, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		auth(r)
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w.Code
+	}
+	anonymous := func(r *http.Request) {}
+	reader := func(r *http.Request) { r.SetBasicAuth("reader", "pw") }
+	writer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer t0ken") }
+
+	set := "group=http-acl&key=k&value=v"
+	if code := do(SET_KEY, set, anonymous); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without credentials, got %d", code)
+	}
+	if code := do(SET_KEY, set, reader); code != http.StatusForbidden {
+		t.Fatalf("expect 403 for a reader, got %d", code)
+	}
+	if code := do(SET_KEY, set, writer); code != http.StatusOK {
+		t.Fatalf("expect the writer to set, got %d", code)
+	}
+	if code := do(GET_KEY, "group=http-acl&key=k", reader); code != http.StatusOK {
+		t.Fatalf("expect the reader to get, got %d", code)
+	}
+	if code := do(NEW_GROUP, "name=http-acl-2&capacity=1", writer); code != http.StatusForbidden {
+		t.Fatalf("expect 403 creating a group without admin, got %d", code)
+	}
+}
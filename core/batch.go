@@ -0,0 +1,78 @@
+package huacache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/huahuoao/huacache/core/lru"
+)
+
+// KeyValue is one entry of a batch write.
+type KeyValue struct {
+	Key   string
+	Value ByteView
+}
+
+// GetMany returns the values of keys, taking each shard lock once. errs[i]
+// is ErrKeyNotFound for a missing key, keys missing from the cache are
+// loaded with the getter one by one.
+func (g *Group) GetMany(keys []string) (values []ByteView, errs []error) {
+	values = make([]ByteView, len(keys))
+	errs = make([]error, len(keys))
+	items, ok := g.mainCache.lru.GetItems(keys)
+	for i, key := range keys {
+		switch {
+		case key == "":
+			errs[i] = ErrKeyRequired
+		case ok[i]:
+			values[i] = g.serve(key, toItem(items[i])).Value
+		default:
+			values[i], errs[i] = g.load(key)
+		}
+	}
+	return values, errs
+}
+
+// SetMany stores all entries with the same ttl, taking each shard lock once.
+func (g *Group) SetMany(kvs []KeyValue, ttl time.Duration) []error {
+	errs := make([]error, len(kvs))
+	if ttl < 0 {
+		for i := range errs {
+			errs[i] = fmt.Errorf("ttl must not be negative")
+		}
+		return errs
+	}
+	keys := make([]string, 0, len(kvs))
+	values := make([]lru.Value, 0, len(kvs))
+	index := make([]int, 0, len(kvs))
+	for i, kv := range kvs {
+		if kv.Key == "" {
+			errs[i] = ErrKeyRequired
+			continue
+		}
+		if err := g.checkSize(kv.Value); err != nil {
+			errs[i] = err
+			continue
+		}
+		keys = append(keys, kv.Key)
+		values = append(values, kv.Value)
+		index = append(index, i)
+	}
+	for j, err := range g.mainCache.lru.AddItems(keys, values, g.ttl(ttl)) {
+		errs[index[j]] = err
+	}
+	return errs
+}
+
+// DeleteMany removes keys, taking each shard lock once. errs[i] is
+// ErrKeyNotFound when keys[i] did not exist.
+func (g *Group) DeleteMany(keys []string) []error {
+	errs := make([]error, len(keys))
+	g.leases.invalidate(keys...)
+	for i, deleted := range g.mainCache.lru.DeleteKeys(keys) {
+		if !deleted {
+			errs[i] = ErrKeyNotFound
+		}
+	}
+	return errs
+}
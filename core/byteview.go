@@ -1,7 +1,9 @@
 package huacache
 
 type ByteView struct {
-	B []byte
+	B     []byte
+	Flags uint32 // 客户端自定义的标志位（如 memcached flags），与值一起存储
+	Stale bool   // 读取时设置，表示值已超过分组的 soft ttl，见 WithSoftTTL
 }
 
 func (v ByteView) Len() int {
@@ -2,6 +2,7 @@ package huacache
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/huahuoao/huacache/core/lru"
 )
@@ -11,8 +12,8 @@ type cache struct {
 	cacheBytes int64
 }
 
-func (c *cache) add(key string, value ByteView) error {
-	err := c.lru.GetLru(key).Add(key, value)
+func (c *cache) add(key string, value ByteView, ttl time.Duration) error {
+	err := c.lru.GetLru(key).AddWithTTL(key, value, ttl)
 	return err
 }
 
@@ -35,3 +36,14 @@ func (c *cache) delete(key string) error {
 	err := c.lru.GetLru(key).DeleteKey(key)
 	return err
 }
+
+func (c *cache) update(key string, fn func(old lru.Item, ok bool) (lru.Item, error)) (lru.Item, error) {
+	return c.lru.GetLru(key).Update(key, fn)
+}
+
+func (c *cache) getItem(key string) (lru.Item, bool) {
+	if c.lru == nil {
+		return lru.Item{}, false
+	}
+	return c.lru.GetLru(key).GetItem(key)
+}
@@ -0,0 +1,465 @@
+// Package config loads the configuration of a huacache node from a YAML
+// file, HUACACHE_* environment variables and command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/lru"
+	"github.com/huahuoao/huacache/core/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables, the variable of a
+// flag is the prefix followed by its name in upper case with - replaced by
+// _, e.g. HUACACHE_MAX_FRAME for -max-frame.
+const EnvPrefix = "HUACACHE_"
+
+// Config is the configuration of a node. Values are taken, from lowest to
+// highest precedence, from the defaults, the config file, the environment
+// and the command line. An empty listener address disables the listener.
+type Config struct {
+	Bluebell    Listener      `yaml:"bluebell"`
+	HTTP        Listener      `yaml:"http"`
+	Memcached   GroupListener `yaml:"memcached"`
+	Resp        GroupListener `yaml:"resp"`
+	Metrics     Listener      `yaml:"metrics"` // Prometheus 指标，路径为 /metrics
+	Engine      Engine        `yaml:"engine"`
+	Limits      Limits        `yaml:"limits"`
+	Cluster     Cluster       `yaml:"cluster"`
+	Persistence Persistence   `yaml:"persistence"`
+	Security    Security      `yaml:"security"`
+	Shutdown    Shutdown      `yaml:"shutdown"`
+	Groups      []Group       `yaml:"groups"`
+}
+
+type Listener struct {
+	Addr string `yaml:"addr"`
+}
+
+// GroupListener is a listener whose commands work on a single group, it is
+// created with Capacity when missing.
+type GroupListener struct {
+	Addr     string `yaml:"addr"`
+	Group    string `yaml:"group"`
+	Capacity Size   `yaml:"capacity"`
+}
+
+// Engine configures the gnet event loops.
+type Engine struct {
+	Multicore    bool          `yaml:"multicore"`
+	ReadBuffer   Size          `yaml:"read_buffer"`
+	WriteBuffer  Size          `yaml:"write_buffer"`
+	TCPKeepAlive time.Duration `yaml:"tcp_keepalive"`
+}
+
+type Limits struct {
+	MaxFrame     Size `yaml:"max_frame"`     // Bluebell 帧体以及 memcached、RESP 单个值的最大长度
+	Shards       int  `yaml:"shards"`        // 每个分组的分片数
+	VirtualNodes int  `yaml:"virtual_nodes"` // 一致性哈希环上每个节点的虚拟节点数
+}
+
+type Cluster struct {
+	Self      string   `yaml:"self"`
+	Peers     []string `yaml:"peers"`
+	ReplicaOf string   `yaml:"replicaof"`
+}
+
+type Persistence struct {
+	Snapshot         string        `yaml:"snapshot"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	AOF              string        `yaml:"aof"`
+	AppendFsync      string        `yaml:"appendfsync"`
+}
+
+type Security struct {
+	ACL          string `yaml:"acl"`
+	AuthUser     string `yaml:"auth_user"`
+	AuthPassword string `yaml:"auth_password"`
+	TLS          TLS    `yaml:"tls"`
+}
+
+// Shutdown configures what happens on SIGTERM or SIGINT: the listeners stop
+// accepting connections, in-flight requests are answered and a last
+// snapshot is saved, all within Timeout.
+type Shutdown struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type TLS struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	CA         string `yaml:"ca"`
+	ClientAuth string `yaml:"client_auth"` // none、optional 或 require
+}
+
+// Group is a group created on boot, before the listeners accept traffic.
+// A group restored from disk keeps its data but takes the default ttl, max
+// value size, soft and hard ttl, lock and lease ttl of its declaration.
+type Group struct {
+	Name     string `yaml:"name"`
+	Capacity Size   `yaml:"capacity"`
+	Policy   string `yaml:"policy"`
+	// DefaultTTL applies to values stored without a ttl, 0 means they never
+	// expire.
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	// SoftTTL and HardTTL replace DefaultTTL: values are served stale and
+	// reloaded in the background after SoftTTL and removed after HardTTL.
+	SoftTTL time.Duration `yaml:"soft_ttl"`
+	HardTTL time.Duration `yaml:"hard_ttl"`
+	// MaxValueSize rejects larger values, 0 means no limit.
+	MaxValueSize Size `yaml:"max_value_size"`
+	// Locked groups can't be deleted by clients.
+	Locked bool `yaml:"locked"`
+	// LeaseTTL is how long a lease handed out on a miss lasts, 0 means
+	// huacache.DefaultLeaseTTL.
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+}
+
+// Options returns the group options of the declaration.
+func (g Group) Options() []huacache.GroupOption {
+	opts := []huacache.GroupOption{
+		huacache.WithPolicy(g.Policy),
+		huacache.WithDefaultTTL(g.DefaultTTL),
+		huacache.WithMaxValueSize(int(g.MaxValueSize)),
+		huacache.WithLeaseTTL(g.LeaseTTL),
+	}
+	if g.SoftTTL > 0 {
+		opts = append(opts, huacache.WithSoftTTL(g.SoftTTL, g.HardTTL))
+	}
+	if g.Locked {
+		opts = append(opts, huacache.WithLocked())
+	}
+	return opts
+}
+
+// Default returns the configuration of a standalone node serving Bluebell
+// on port 9000.
+func Default() *Config {
+	return &Config{
+		Bluebell:  Listener{Addr: "0.0.0.0:9000"},
+		Memcached: GroupListener{Group: "memcached", Capacity: 64 * huacache.MB},
+		Resp:      GroupListener{Group: "0", Capacity: 64 * huacache.MB},
+		Engine: Engine{
+			Multicore:    true,
+			ReadBuffer:   2 * huacache.MB,
+			WriteBuffer:  2 * huacache.MB,
+			TCPKeepAlive: 5 * time.Minute,
+		},
+		Limits: Limits{
+			MaxFrame:     huacache.LIMIT_SIZE,
+			Shards:       huacache.SHARD_NUM,
+			VirtualNodes: huacache.CONSISTENTHASH_VIRTUAL_NODE_NUM,
+		},
+		Persistence: Persistence{AppendFsync: "everysec"},
+		Security:    Security{TLS: TLS{ClientAuth: "optional"}},
+		// 小于 Kubernetes 默认的 30 秒 terminationGracePeriodSeconds
+		Shutdown: Shutdown{Timeout: 25 * time.Second},
+	}
+}
+
+// Load reads the configuration of a node: the file named by -config or
+// HUACACHE_CONFIG, then the environment, then args. The result is
+// validated.
+func Load(args []string) (*Config, error) {
+	// 第一遍解析只为找到配置文件
+	var path string
+	probe := flagSet(Default(), &path)
+	probe.SetOutput(io.Discard)
+	probe.Parse(args)
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	fs := flagSet(cfg, &path)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && err == nil {
+			if e := f.Value.Set(value); e != nil {
+				err = fmt.Errorf("invalid %s %q: %v", name, value, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// flagSet 把命令行参数绑定到 cfg 的字段，参数的默认值为 cfg 当前的值
+func flagSet(cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("huacache", flag.ContinueOnError)
+	fs.StringVar(path, "config", *path, "YAML config file, flags and "+EnvPrefix+"* environment variables override it")
+
+	fs.StringVar(&cfg.Bluebell.Addr, "bluebell", cfg.Bluebell.Addr, "address to serve the Bluebell protocol on, empty to disable")
+	fs.StringVar(&cfg.HTTP.Addr, "http", cfg.HTTP.Addr, "address to serve the HTTP API on, e.g. 0.0.0.0:4160, empty to disable")
+	fs.StringVar(&cfg.Memcached.Addr, "memcached", cfg.Memcached.Addr, "address to serve the memcached text protocol on, e.g. 0.0.0.0:11211")
+	fs.StringVar(&cfg.Memcached.Group, "memcached-group", cfg.Memcached.Group, "group the memcached commands work on, created if missing")
+	fs.Var(&cfg.Memcached.Capacity, "memcached-bytes", "capacity of the memcached group when it is created, e.g. 64MB")
+	fs.StringVar(&cfg.Resp.Addr, "resp", cfg.Resp.Addr, "address to serve the Redis protocol on, e.g. 0.0.0.0:6379")
+	fs.StringVar(&cfg.Resp.Group, "resp-group", cfg.Resp.Group, "group Redis connections start on before SELECT, created if missing")
+	fs.Var(&cfg.Resp.Capacity, "resp-bytes", "capacity of the Redis group when it is created, e.g. 64MB")
+	fs.StringVar(&cfg.Metrics.Addr, "metrics", cfg.Metrics.Addr, "address to serve Prometheus metrics on at /metrics, e.g. 0.0.0.0:9100")
+
+	fs.BoolVar(&cfg.Engine.Multicore, "multicore", cfg.Engine.Multicore, "run an event loop per CPU core")
+	fs.Var(&cfg.Engine.ReadBuffer, "read-buffer", "read buffer of each connection, e.g. 2MB")
+	fs.Var(&cfg.Engine.WriteBuffer, "write-buffer", "write buffer of each connection, e.g. 2MB")
+	fs.DurationVar(&cfg.Engine.TCPKeepAlive, "tcp-keepalive", cfg.Engine.TCPKeepAlive, "TCP keep-alive period, 0 disables it")
+
+	fs.Var(&cfg.Limits.MaxFrame, "max-frame", "largest Bluebell frame, and memcached or Redis value, e.g. 15MB")
+	fs.IntVar(&cfg.Limits.Shards, "shards", cfg.Limits.Shards, "number of shards of each group")
+	fs.IntVar(&cfg.Limits.VirtualNodes, "virtual-nodes", cfg.Limits.VirtualNodes, "virtual nodes per node on the hash ring, the same on all nodes")
+
+	fs.StringVar(&cfg.Cluster.Self, "self", cfg.Cluster.Self, "address this node is reachable at by its peers, e.g. 10.0.0.1:9000")
+	fs.Var((*listValue)(&cfg.Cluster.Peers), "peers", "comma separated Bluebell addresses of all nodes in the cluster")
+	fs.StringVar(&cfg.Cluster.ReplicaOf, "replicaof", cfg.Cluster.ReplicaOf, "Bluebell address of the primary this node replicates")
+
+	fs.StringVar(&cfg.Persistence.Snapshot, "snapshot", cfg.Persistence.Snapshot, "file to save snapshots to and to restore from on boot")
+	fs.DurationVar(&cfg.Persistence.SnapshotInterval, "snapshot-interval", cfg.Persistence.SnapshotInterval, "how often to save a snapshot, 0 disables periodic snapshots")
+	fs.StringVar(&cfg.Persistence.AOF, "aof", cfg.Persistence.AOF, "append-only file logging every write, replayed on boot")
+	fs.StringVar(&cfg.Persistence.AppendFsync, "appendfsync", cfg.Persistence.AppendFsync, "when to fsync the append-only file: always, everysec or no")
+
+	fs.StringVar(&cfg.Security.ACL, "acl", cfg.Security.ACL, "ACL file, when set every Bluebell, HTTP and RESP client has to authenticate")
+	fs.StringVar(&cfg.Security.AuthUser, "auth-user", cfg.Security.AuthUser, "user this node authenticates as to its peers and primary, empty for a token")
+	fs.StringVar(&cfg.Security.AuthPassword, "auth-password", cfg.Security.AuthPassword, "password or token this node authenticates with to its peers and primary")
+	fs.StringVar(&cfg.Security.TLS.Cert, "tls-cert", cfg.Security.TLS.Cert, "PEM certificate of this node, enables TLS on the Bluebell and HTTP listeners and to peers")
+	fs.StringVar(&cfg.Security.TLS.Key, "tls-key", cfg.Security.TLS.Key, "PEM private key of -tls-cert")
+	fs.StringVar(&cfg.Security.TLS.CA, "tls-ca", cfg.Security.TLS.CA, "PEM CA signing the certificates of peers and clients, the system roots when empty")
+	fs.StringVar(&cfg.Security.TLS.ClientAuth, "tls-client-auth", cfg.Security.TLS.ClientAuth, "client certificates: none, optional (verified if given) or require")
+
+	fs.DurationVar(&cfg.Shutdown.Timeout, "shutdown-timeout", cfg.Shutdown.Timeout, "how long to drain connections and save the last snapshot on SIGTERM")
+	return fs
+}
+
+// Settings returns every setting that has a flag, by flag name, as reported
+// by the stats command. Passwords are masked.
+func (c *Config) Settings() map[string]string {
+	var path string
+	settings := make(map[string]string)
+	flagSet(c, &path).VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		value := f.Value.String()
+		if f.Name == "auth-password" && value != "" {
+			value = "******"
+		}
+		settings[f.Name] = value
+	})
+	return settings
+}
+
+// Validate checks the configuration and reports every invalid field.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+	checkAddr := func(field, addr string) {
+		if addr == "" {
+			return
+		}
+		_, port, err := net.SplitHostPort(addr)
+		check(err == nil && port != "", field, "invalid address %q, expect host:port", addr)
+	}
+	// 分组的容量平均分给各个分片，不能整除时创建分组会失败
+	checkCapacity := func(field string, capacity Size) {
+		check(capacity > 0, field, "must be positive")
+		if capacity > 0 && c.Limits.Shards > 0 {
+			check(int64(capacity)%int64(c.Limits.Shards) == 0, field, "must be a multiple of limits.shards (%d)", c.Limits.Shards)
+		}
+	}
+
+	check(c.Bluebell.Addr != "" || c.HTTP.Addr != "" || c.Memcached.Addr != "" || c.Resp.Addr != "",
+		"listeners", "at least one of bluebell, http, memcached and resp must have an address")
+	checkAddr("bluebell.addr", c.Bluebell.Addr)
+	checkAddr("http.addr", c.HTTP.Addr)
+	checkAddr("memcached.addr", c.Memcached.Addr)
+	checkAddr("resp.addr", c.Resp.Addr)
+	checkAddr("metrics.addr", c.Metrics.Addr)
+	if c.Memcached.Addr != "" {
+		check(c.Memcached.Group != "", "memcached.group", "is required")
+		checkCapacity("memcached.capacity", c.Memcached.Capacity)
+		// memcached 文本协议没有认证，不能在开启 ACL 时绕过它
+		check(c.Security.ACL == "", "memcached.addr", "can't be used with security.acl, the memcached protocol has no authentication")
+	}
+	if c.Resp.Addr != "" {
+		check(c.Resp.Group != "", "resp.group", "is required")
+		checkCapacity("resp.capacity", c.Resp.Capacity)
+	}
+
+	check(c.Engine.ReadBuffer > 0, "engine.read_buffer", "must be positive")
+	check(c.Engine.WriteBuffer > 0, "engine.write_buffer", "must be positive")
+	check(c.Engine.TCPKeepAlive >= 0, "engine.tcp_keepalive", "can't be negative")
+	check(c.Limits.MaxFrame > 0 && c.Limits.MaxFrame <= 1<<31-1, "limits.max_frame", "must be between 1 byte and 2GB")
+	check(c.Limits.Shards > 0, "limits.shards", "must be positive")
+	check(c.Limits.VirtualNodes > 0, "limits.virtual_nodes", "must be positive")
+
+	if len(c.Cluster.Peers) > 0 {
+		check(c.Cluster.Self != "", "cluster.self", "is required when peers are set")
+		check(c.Cluster.ReplicaOf == "", "cluster.replicaof", "can't be used together with peers")
+		check(c.Bluebell.Addr != "", "bluebell.addr", "is required in a cluster")
+	}
+	checkAddr("cluster.self", c.Cluster.Self)
+	for i, peer := range c.Cluster.Peers {
+		checkAddr(fmt.Sprintf("cluster.peers[%d]", i), peer)
+	}
+	checkAddr("cluster.replicaof", c.Cluster.ReplicaOf)
+	if c.Cluster.ReplicaOf != "" {
+		check(c.Bluebell.Addr != "", "bluebell.addr", "is required on a replica")
+	}
+
+	check(c.Persistence.SnapshotInterval >= 0, "persistence.snapshot_interval", "can't be negative")
+	check(c.Persistence.SnapshotInterval == 0 || c.Persistence.Snapshot != "", "persistence.snapshot", "is required with snapshot_interval")
+	// 只有 Bluebell 的写命令会写入 AOF
+	check(c.Persistence.AOF == "" || c.Bluebell.Addr != "", "bluebell.addr", "is required with an aof")
+	if _, err := protocol.ParseFsyncPolicy(c.Persistence.AppendFsync); err != nil {
+		check(false, "persistence.appendfsync", "%v", err)
+	}
+
+	tls := c.Security.TLS
+	check(tls.Cert == "" || tls.Key != "", "security.tls.key", "is required with a certificate")
+	check(tls.Key == "" || tls.Cert != "", "security.tls.cert", "is required with a key")
+	check(tls.CA == "" || tls.Cert != "", "security.tls.cert", "is required with a CA")
+	switch tls.ClientAuth {
+	case "none", "optional", "require":
+	default:
+		check(false, "security.tls.client_auth", "unknown mode %q, expect none, optional or require", tls.ClientAuth)
+	}
+
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout", "must be positive")
+
+	names := make(map[string]bool)
+	for i, g := range c.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		if g.Name != "" {
+			field = fmt.Sprintf("groups[%s]", g.Name)
+		}
+		check(g.Name != "", field+".name", "is required")
+		check(!names[g.Name], field+".name", "is declared twice")
+		names[g.Name] = true
+		checkCapacity(field+".capacity", g.Capacity)
+		check(g.DefaultTTL >= 0, field+".default_ttl", "must not be negative")
+		check(g.LeaseTTL >= 0, field+".lease_ttl", "must not be negative")
+		if g.SoftTTL != 0 || g.HardTTL != 0 {
+			check(g.SoftTTL > 0, field+".soft_ttl", "must be positive when hard_ttl is set")
+			check(g.HardTTL > g.SoftTTL, field+".hard_ttl", "must exceed soft_ttl")
+			check(g.DefaultTTL == 0, field+".default_ttl", "must not be set with soft_ttl")
+		}
+		check(g.MaxValueSize <= g.Capacity, field+".max_value_size", "must not exceed the capacity")
+		if g.Policy != "" {
+			if _, err := lru.NewPolicy(g.Policy, int64(g.Capacity)); err != nil {
+				check(false, field+".policy", "%v", err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Size is a number of bytes, written as an integer or with a unit of KB,
+// MB or GB (powers of 1024), e.g. 64MB.
+type Size int64
+
+var units = []struct {
+	suffix string
+	size   Size
+}{
+	{"GB", huacache.GB}, {"MB", huacache.MB}, {"KB", 1 << 10}, {"B", 1},
+}
+
+// ParseSize parses a size such as 512, 16KB or 64MB.
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	n, unit := s, Size(1)
+	upper := strings.ToUpper(s)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			n, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q, expect e.g. 512, 16KB or 64MB", s)
+	}
+	return Size(v) * unit, nil
+}
+
+func (s Size) String() string {
+	for _, u := range units {
+		if s != 0 && s%u.size == 0 {
+			return strconv.FormatInt(int64(s/u.size), 10) + u.suffix
+		}
+	}
+	return "0"
+}
+
+// Set implements flag.Value.
+func (s *Size) Set(value string) error {
+	size, err := ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// UnmarshalYAML accepts both integers and strings with a unit.
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	return s.Set(node.Value)
+}
+
+// listValue 为逗号分隔的命令行参数
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
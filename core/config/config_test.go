@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "huacache.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+bluebell:
+  addr: 127.0.0.1:9100
+http:
+  addr: 127.0.0.1:4160
+engine:
+  read_buffer: 4MB
+limits:
+  shards: 16
+  max_frame: 1048576
+cluster:
+  self: 10.0.0.1:9000
+  peers: [10.0.0.1:9000, 10.0.0.2:9000]
+persistence:
+  snapshot: dump.hcs
+  snapshot_interval: 30s
+groups:
+  - name: orders
+    capacity: 64MB
+    policy: lfu
+    default_ttl: 10m
+    max_value_size: 1MB
+    locked: true
+    lease_ttl: 3s
+`)
+	t.Setenv("HUACACHE_SHARDS", "32")
+	t.Setenv("HUACACHE_HTTP", "127.0.0.1:4161")
+	cfg, err := Load([]string{"-config", path, "-shards", "64"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Bluebell.Addr != "127.0.0.1:9100" || cfg.Engine.ReadBuffer != 4*huacache.MB || cfg.Limits.MaxFrame != huacache.MB {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if cfg.HTTP.Addr != "127.0.0.1:4161" {
+		t.Fatalf("expect the environment to override the file, got %s", cfg.HTTP.Addr)
+	}
+	if cfg.Limits.Shards != 64 {
+		t.Fatalf("expect flags to override the environment, got %d", cfg.Limits.Shards)
+	}
+	if cfg.Engine.WriteBuffer != 2*huacache.MB || !cfg.Engine.Multicore {
+		t.Fatalf("expect defaults for missing values: %+v", cfg.Engine)
+	}
+	if len(cfg.Cluster.Peers) != 2 || cfg.Persistence.SnapshotInterval != 30*time.Second {
+		t.Fatalf("unexpected cluster or persistence %+v %+v", cfg.Cluster, cfg.Persistence)
+	}
+	if len(cfg.Groups) != 1 || cfg.Groups[0].Capacity != 64*huacache.MB || cfg.Groups[0].Policy != "lfu" {
+		t.Fatalf("unexpected groups %+v", cfg.Groups)
+	}
+	if g := cfg.Groups[0]; g.DefaultTTL != 10*time.Minute || g.MaxValueSize != huacache.MB || !g.Locked || g.LeaseTTL != 3*time.Second {
+		t.Fatalf("unexpected group settings %+v", g)
+	}
+
+	cfg.Security.AuthPassword = "secret"
+	if settings := cfg.Settings(); settings["shards"] != "64" || settings["http"] != "127.0.0.1:4161" || settings["auth-password"] == "secret" {
+		t.Fatalf("unexpected settings %v", settings)
+	}
+
+	t.Setenv("HUACACHE_CONFIG", path)
+	cfg, err = Load([]string{"-peers", "10.0.0.1:9000, 10.0.0.3:9000"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Bluebell.Addr != "127.0.0.1:9100" || strings.Join(cfg.Cluster.Peers, ",") != "10.0.0.1:9000,10.0.0.3:9000" {
+		t.Fatalf("unexpected config from HUACACHE_CONFIG: %+v", cfg)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := writeConfig(t, `
+bluebell:
+  addr: "9000"
+memcached:
+  addr: 127.0.0.1:11211
+limits:
+  shards: 0
+cluster:
+  peers: [10.0.0.2:9000]
+  replicaof: 10.0.0.3:9000
+persistence:
+  appendfsync: sometimes
+shutdown:
+  timeout: 0s
+security:
+  acl: users.acl
+  tls:
+    cert: node.pem
+groups:
+  - name: orders
+    capacity: 0
+  - name: orders
+    capacity: 1MB
+    policy: fifo
+  - name: sessions
+    capacity: 1MB
+    default_ttl: -1s
+    max_value_size: 2MB
+  - name: profiles
+    capacity: 1MB
+    soft_ttl: 1m
+    hard_ttl: 30s
+`)
+	_, err := Load([]string{"-config", path})
+	if err == nil {
+		t.Fatalf("expect an invalid config")
+	}
+	for _, want := range []string{
+		"bluebell.addr", "limits.shards", "cluster.self", "cluster.replicaof",
+		"persistence.appendfsync", "security.tls.key", "shutdown.timeout", "memcached.addr: can't be used with security.acl",
+		"groups[orders].capacity", "groups[orders].name: is declared twice", "groups[orders].policy",
+		"groups[sessions].default_ttl", "groups[sessions].max_value_size", "groups[profiles].hard_ttl",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expect an error about %s in:\n%v", want, err)
+		}
+	}
+
+	// 容量要能平均分给各个分片
+	path = writeConfig(t, `
+bluebell:
+  addr: :9000
+memcached:
+  addr: :11211
+  capacity: 64MB
+resp:
+  addr: :6379
+  capacity: 1000
+limits:
+  shards: 3
+groups:
+  - name: orders
+    capacity: 3MB
+  - name: sessions
+    capacity: 1MB
+`)
+	_, err = Load([]string{"-config", path})
+	for _, want := range []string{
+		"memcached.capacity: must be a multiple of limits.shards (3)", "resp.capacity", "groups[sessions].capacity",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expect an error about %s in:\n%v", want, err)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "groups[orders]") {
+		t.Errorf("expect 3MB to fit 3 shards, got:\n%v", err)
+	}
+
+	if _, err := Load([]string{"-config", writeConfig(t, "bluebel:\n  addr: :9000\n")}); err == nil {
+		t.Fatalf("expect an error for an unknown field")
+	}
+	t.Setenv("HUACACHE_MAX_FRAME", "lots")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "HUACACHE_MAX_FRAME") {
+		t.Fatalf("expect an error about HUACACHE_MAX_FRAME, got %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]Size{"512": 512, "16KB": 16 << 10, "64mb": 64 << 20, "2 GB": 2 << 30, "10B": 10}
+	for s, want := range cases {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, expect %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1", "1TB"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("expect an error for %q", s)
+		}
+	}
+	if s := Size(64 << 20).String(); s != "64MB" {
+		t.Errorf("expect 64MB, got %s", s)
+	}
+}
@@ -31,11 +31,16 @@ type Map struct {
 
 // New creates a new hash ring.
 func New() *Map {
-	m := &Map{
-		replicas: huacache.CONSISTENTHASH_VIRTUAL_NODE_NUM, // Number of virtual nodes
+	return NewWithReplicas(huacache.CONSISTENTHASH_VIRTUAL_NODE_NUM)
+}
+
+// NewWithReplicas creates a hash ring placing replicas virtual nodes per
+// physical node, each virtual node adds 4 points to the ring.
+func NewWithReplicas(replicas int) *Map {
+	return &Map{
+		replicas: replicas,
 		hashMap:  make(map[int64]string),
 	}
-	return m
 }
 
 // Add adds new physical nodes to the hash ring.
@@ -1,5 +1,8 @@
 package huacache
 
+// VERSION is the version of huacache reported to clients.
+const VERSION = "1.0.0"
+
 const (
 	MB                         = 1 << 20
 	GB                         = 1 << 30
@@ -9,13 +12,32 @@ const (
 
 // command
 const (
-	GET_KEY    = "get"
-	SET_KEY    = "set"
-	DEL_KEY    = "del"
-	NEW_GROUP  = "new_group"
-	LIST_GROUP = "list_group"
-	DEL_GROUP  = "del_group"
-	GET_KEYS   = "keys"
+	GET_KEY     = "get"
+	SET_KEY     = "set"
+	DEL_KEY     = "del"
+	NEW_GROUP   = "new_group"
+	LIST_GROUP  = "list_group"
+	DEL_GROUP   = "del_group"
+	GET_KEYS    = "keys"
+	MGET_KEYS   = "mget"
+	MSET_KEYS   = "mset"
+	MDEL_KEYS   = "mdel"
+	HELLO       = "hello"
+	AUTH        = "auth"
+	SYNC        = "sync"
+	SAVE        = "save"
+	REWRITE_AOF = "rewrite_aof"
+	STATS       = "stats"
+	INCR        = "incr"
+	DECR        = "decr"
+	ADD_KEY     = "add"
+	REPLACE_KEY = "replace"
+	CAS         = "cas"
+	LEASE_GET   = "lease_get"
+	LEASE_SET   = "lease_set"
+	GET_ITEM    = "get_item"
+	PUT_ITEM    = "put_item"
+	FLUSH_GROUP = "flush_group"
 )
 
 const (
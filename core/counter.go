@@ -0,0 +1,56 @@
+package huacache
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// Incr atomically adds delta to the integer stored under key and returns
+// the new value. A missing key starts at initial and expires after ttl, 0
+// meaning the default ttl of the group; an existing key keeps its ttl and
+// flags. The value is a signed 64-bit integer in decimal, the key is left
+// unchanged when the result would overflow.
+func (g *Group) Incr(key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return g.addInt(key, delta, false, initial, ttl)
+}
+
+// Decr atomically subtracts delta from the integer stored under key, see
+// Incr.
+func (g *Group) Decr(key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return g.addInt(key, delta, true, initial, ttl)
+}
+
+// addInt 在分片锁内读取、计算并写回计数器
+func (g *Group) addInt(key string, delta int64, negate bool, initial int64, ttl time.Duration) (int64, error) {
+	var n int64
+	_, err := g.Update(key, func(old Item, ok bool) (Item, error) {
+		n = initial
+		if ok {
+			v, err := strconv.ParseInt(old.Value.String(), 10, 64)
+			if err != nil {
+				return Item{}, ErrNotInteger
+			}
+			n = v
+		} else {
+			old = g.NewItem(ByteView{}, ttl)
+		}
+		var overflow bool
+		if negate {
+			overflow = (delta > 0 && n < math.MinInt64+delta) || (delta < 0 && n > math.MaxInt64+delta)
+			n -= delta
+		} else {
+			overflow = (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta)
+			n += delta
+		}
+		if overflow {
+			return Item{}, ErrOverflow
+		}
+		old.Value = ByteView{B: strconv.AppendInt(nil, n, 10), Flags: old.Value.Flags}
+		return old, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package huacache
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrDecr(t *testing.T) {
+	DelGroup("counter")
+	g, _ := NewGroup("counter", 8*MB)
+	defer DelGroup("counter")
+
+	if n, err := g.Incr("hits", 5, 100, time.Minute); err != nil || n != 105 {
+		t.Fatalf("expect 105, got %d %v", n, err)
+	}
+	item, _ := g.Peek("hits")
+	if item.ExpireAt.IsZero() || string(item.Value.B) != "105" {
+		t.Fatalf("expect a counter with a ttl, got %+v", item)
+	}
+	// 已存在的 key 保留原来的 ttl
+	if n, err := g.Decr("hits", 10, 0, 0); err != nil || n != 95 {
+		t.Fatalf("expect 95, got %d %v", n, err)
+	}
+	if after, _ := g.Peek("hits"); !after.ExpireAt.Equal(item.ExpireAt) {
+		t.Fatalf("ttl changed from %v to %v", item.ExpireAt, after.ExpireAt)
+	}
+	if n, err := g.Decr("neg", 3, 0, 0); err != nil || n != -3 {
+		t.Fatalf("expect -3, got %d %v", n, err)
+	}
+
+	g.AddOrUpdate("max", ByteView{B: []byte("9223372036854775807")})
+	if _, err := g.Incr("max", 1, 0, 0); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect ErrOverflow, got %v", err)
+	}
+	if _, err := g.Decr("min", math.MinInt64, 0, 0); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect ErrOverflow, got %v", err)
+	}
+	if _, ok := g.Peek("min"); ok {
+		t.Fatalf("overflowing counter should not be created")
+	}
+	g.AddOrUpdate("text", ByteView{B: []byte("abc")})
+	if _, err := g.Incr("text", 1, 0, 0); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expect ErrNotInteger, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.Incr("concurrent", 1, 0, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := g.Get("concurrent"); v.String() != "5000" {
+		t.Fatalf("expect 5000, got %s", v)
+	}
+}
@@ -0,0 +1,56 @@
+package huacache
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrKeyNotFound is returned when the key is neither cached nor loadable.
+	ErrKeyNotFound = errors.New("key not found in cache")
+	// ErrKeyExists is returned when adding a key that is already cached.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrVersionMismatch is returned when a compare-and-swap finds the entry
+	// was modified since it was read.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrKeyRequired is returned when the key is empty.
+	ErrKeyRequired = errors.New("key is required")
+	// ErrGroupNotFound is returned when the named group does not exist.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupExists is returned when creating a group whose name is taken.
+	ErrGroupExists = errors.New("group already exists")
+	// ErrGroupLocked is returned when deleting a group declared as locked.
+	ErrGroupLocked = errors.New("group is locked")
+	// ErrValueTooLarge is returned when a value exceeds the max value size
+	// of its group.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrInvalidCursor is returned when a scan cursor points past the shards
+	// of the group.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrNotInteger is returned when incrementing a value that is not a
+	// 64-bit integer.
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned when an increment or decrement would overflow
+	// a 64-bit integer.
+	ErrOverflow = errors.New("increment or decrement would overflow")
+	// ErrLeaseWait is returned by LeaseGet when another client holds the
+	// lease of the missing key, the caller should retry shortly.
+	ErrLeaseWait = errors.New("key is being loaded by another client")
+	// ErrLeaseInvalid is returned by LeaseSet when the lease expired, was
+	// used or the key was deleted since it was handed out.
+	ErrLeaseInvalid = errors.New("lease is invalid or expired")
+)
+
+// LoadError 表示通过 Getter 回源加载数据失败
+type LoadError struct {
+	Key string
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("failed to load key %s: %v", e.Key, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
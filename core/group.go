@@ -3,29 +3,167 @@ package huacache
 import (
 	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/huahuoao/huacache/core/lru"
+	"golang.org/x/sync/singleflight"
 )
 
+// A Getter loads data for a key when it misses the cache.
+type Getter interface {
+	Get(key string) ([]byte, error)
+}
+
+// A GetterFunc implements Getter with a function.
+type GetterFunc func(key string) ([]byte, error)
+
+// Get implements Getter interface function
+func (f GetterFunc) Get(key string) ([]byte, error) {
+	return f(key)
+}
+
+// GroupOption configures a Group created by NewGroup.
+type GroupOption func(*Group)
+
+// WithGetter sets the loader called on a cache miss.
+func WithGetter(getter Getter) GroupOption {
+	return func(g *Group) {
+		g.getter = getter
+	}
+}
+
+// WithShards sets the number of shards of the group, SetDefaultShards
+// when it is not given.
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.shards = n
+	}
+}
+
+// defaultShards 为未指定分片数的分组使用的分片数
+var defaultShards = SHARD_NUM
+
+// SetDefaultShards sets the number of shards of groups created without
+// WithShards, including those created by clients. It must be called before
+// any group is created.
+func SetDefaultShards(n int) {
+	defaultShards = n
+}
+
+// WithDefaultTTL sets the ttl of values stored without one.
+func WithDefaultTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.defaultTTL = ttl
+	}
+}
+
+// WithMaxValueSize rejects values larger than n bytes with
+// ErrValueTooLarge, 0 means no limit besides the capacity.
+func WithMaxValueSize(n int) GroupOption {
+	return func(g *Group) {
+		g.maxValueSize = n
+	}
+}
+
+// WithLocked makes DelGroup fail with ErrGroupLocked.
+func WithLocked() GroupOption {
+	return func(g *Group) {
+		g.locked = true
+	}
+}
+
+// WithPolicy selects the eviction policy of the group: lru (the default),
+// lfu, arc or wtinylfu.
+func WithPolicy(policy string) GroupOption {
+	return func(g *Group) {
+		g.policy = policy
+	}
+}
+
+// GroupStatus is the memory usage and the counters of a group, see
+// lru.Stats.
 type GroupStatus struct {
-	name     string
-	size     int64
-	used     int64
-	keyCount int
+	Name      string        `json:"name,omitempty"`
+	Policy    string        `json:"policy,omitempty"`
+	Size      int64         `json:"capacity_bytes"` // 容量，字节
+	Used      int64         `json:"used_bytes"`
+	KeyCount  int           `json:"keys"`
+	Hits      uint64        `json:"hits"`
+	Misses    uint64        `json:"misses"`
+	HitRatio  float64       `json:"hit_ratio"` // 没有查询时为 0
+	Sets      uint64        `json:"sets"`
+	Deletes   uint64        `json:"deletes"`
+	Evictions uint64        `json:"evictions"`
+	Expired   uint64        `json:"expired"`
+	Shards    []ShardStatus `json:"shards,omitempty"`
+	// ShardSkew 为 key 最多的分片与平均值之比，1 表示完全均衡
+	ShardSkew float64 `json:"shard_skew,omitempty"`
+}
+
+// ShardStatus is the balance of a shard of a group.
+type ShardStatus struct {
+	Keys      int    `json:"keys"`
+	Used      int64  `json:"used_bytes"`
+	Evictions uint64 `json:"evictions"`
 }
+
+// add 累加另一个分组的计数，用于统计所有分组的总量
+func (gs *GroupStatus) add(o *GroupStatus) {
+	gs.Size += o.Size
+	gs.Used += o.Used
+	gs.KeyCount += o.KeyCount
+	gs.Hits += o.Hits
+	gs.Misses += o.Misses
+	gs.Sets += o.Sets
+	gs.Deletes += o.Deletes
+	gs.Evictions += o.Evictions
+	gs.Expired += o.Expired
+	gs.HitRatio = hitRatio(gs.Hits, gs.Misses)
+}
+
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
 type Group struct {
 	name      string
+	getter    Getter
+	policy    string // 淘汰策略，见 lru.NewPolicy
+	shards    int
 	mainCache cache
+	loader    singleflight.Group // 合并同一个 key 的并发回源请求
+
+	// 以下由 WithDefaultTTL、WithSoftTTL、WithMaxValueSize、WithLocked、WithLeaseTTL 设置
+	defaultTTL   time.Duration
+	staleFor     time.Duration // hard ttl 与 soft ttl 之差
+	maxValueSize int
+	locked       bool
+	leaseTTL     time.Duration
+
+	leases     leaseTable // 未命中时发放的租约，见 LeaseGet
+	refreshing sync.Map   // 正在后台刷新的 stale key
 }
 
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
+	// 配置中声明的分组，由 mu 保护
+	declared = make(map[string]declaration)
 )
 
+type declaration struct {
+	cacheBytes int64
+	opts       []GroupOption
+}
+
 // NewGroup creates a new instance of Group
-func NewGroup(name string, cacheBytes int64) (*Group, error) {
+func NewGroup(name string, cacheBytes int64, opts ...GroupOption) (*Group, error) {
 	mu.Lock()
 	defer mu.Unlock()
 	if name == "" {
@@ -33,61 +171,226 @@ func NewGroup(name string, cacheBytes int64) (*Group, error) {
 	}
 	_, ok := groups[name]
 	if ok {
-		return nil, fmt.Errorf("group %s already exists", name)
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
 	}
-	lruCache, err := lru.NewShardingLRU(SHARD_NUM, cacheBytes)
+	return newGroupLocked(name, cacheBytes, opts...)
+}
+
+func newGroupLocked(name string, cacheBytes int64, opts ...GroupOption) (*Group, error) {
+	g := &Group{name: name, shards: defaultShards, leaseTTL: DefaultLeaseTTL}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if d, ok := declared[name]; ok {
+		// 声明的设置优先，例如从快照恢复的分组
+		for _, opt := range d.opts {
+			opt(g)
+		}
+	}
+	if g.shards <= 0 {
+		return nil, fmt.Errorf("group %s: shard count must be positive", name)
+	}
+	lruCache, err := lru.NewShardingLRUWithPolicy(g.shards, cacheBytes, g.policy)
 	if err != nil {
 		return nil, err
 	}
-	g := &Group{
-		name: name,
-		mainCache: cache{
-			cacheBytes: cacheBytes,
-			lru:        lruCache, // Initialize lru here
-		},
+	g.policy = lruCache.Policy
+	g.mainCache = cache{
+		cacheBytes: cacheBytes,
+		lru:        lruCache, // Initialize lru here
 	}
 	groups[name] = g
 	return g, nil
 }
 
+// DeclareGroup creates a group from the server configuration. The options
+// of a declared group win over those of NewGroup, so a group restored from
+// a snapshot or recreated by a replica keeps its declared settings, and
+// LoadSnapshot recreates declared groups missing from the snapshot.
+// Declaring a group that already exists only updates its default ttl, max
+// value size and lock.
+func DeclareGroup(name string, cacheBytes int64, opts ...GroupOption) (*Group, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" {
+		return nil, fmt.Errorf("group name can't be empty")
+	}
+	declared[name] = declaration{cacheBytes: cacheBytes, opts: opts}
+	if g, ok := groups[name]; ok {
+		settings := &Group{leaseTTL: DefaultLeaseTTL}
+		for _, opt := range opts {
+			opt(settings)
+		}
+		g.defaultTTL, g.maxValueSize, g.locked = settings.defaultTTL, settings.maxValueSize, settings.locked
+		g.staleFor, g.leaseTTL = settings.staleFor, settings.leaseTTL
+		return g, nil
+	}
+	return newGroupLocked(name, cacheBytes)
+}
+
+// createDeclared 创建缺少的声明分组
+func createDeclared() error {
+	mu.Lock()
+	defer mu.Unlock()
+	for name, d := range declared {
+		if _, ok := groups[name]; ok {
+			continue
+		}
+		if _, err := newGroupLocked(name, d.cacheBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func DelGroup(name string) error {
 	mu.Lock()
 	defer mu.Unlock()
 	if _, exists := groups[name]; !exists {
-		return fmt.Errorf("group %s does not exist", name)
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	if groups[name].locked {
+		return fmt.Errorf("%w: %s", ErrGroupLocked, name)
 	}
+	groups[name].mainCache.lru.Close()
 	delete(groups, name)
 	return nil
 }
 
+// resetGroups deletes all groups.
+func resetGroups() {
+	mu.Lock()
+	defer mu.Unlock()
+	for name, g := range groups {
+		g.mainCache.lru.Close()
+		delete(groups, name)
+	}
+}
+
 func (g *Group) Get(key string) (ByteView, error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, ErrKeyRequired
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
-		return v, nil
+	if it, ok := g.mainCache.getItem(key); ok {
+		return g.serve(key, toItem(it)).Value, nil
 	}
-	return ByteView{}, fmt.Errorf("key not found in cache")
+	return g.load(key)
+}
 
+// load calls the getter on a cache miss, concurrent misses of the same key
+// share a single call.
+func (g *Group) load(key string) (ByteView, error) {
+	if g.getter == nil {
+		return ByteView{}, ErrKeyNotFound
+	}
+	v, err, _ := g.loader.Do(key, func() (interface{}, error) {
+		// 排队期间可能已经有其他请求加载完成
+		if v, ok := g.mainCache.get(key); ok {
+			return v, nil
+		}
+		bytes, err := g.getter.Get(key)
+		if err != nil {
+			return nil, &LoadError{Key: key, Err: err}
+		}
+		value := ByteView{B: cloneBytes(bytes)}
+		if err := g.AddOrUpdate(key, value); err != nil {
+			log.Printf("failed to cache loaded key %s: %v", key, err)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return v.(ByteView), nil
 }
 
 func (g *Group) AddOrUpdate(key string, value ByteView) error {
-	return g.mainCache.add(key, value)
+	return g.AddOrUpdateWithTTL(key, value, 0)
+}
+
+// AddOrUpdateWithTTL stores the value which expires after ttl, a zero ttl
+// means the default ttl of the group, if any, or that it never expires.
+func (g *Group) AddOrUpdateWithTTL(key string, value ByteView, ttl time.Duration) error {
+	if err := g.checkSize(value); err != nil {
+		return err
+	}
+	return g.mainCache.add(key, value, g.ttl(ttl))
+}
+
+// ttl 为未指定 ttl 的值使用分组的默认 ttl，并加上 stale 的时长，返回的是 hard ttl
+func (g *Group) ttl(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = g.defaultTTL
+	}
+	if ttl > 0 {
+		ttl += g.staleFor
+	}
+	return ttl
+}
+
+func (g *Group) checkSize(value ByteView) error {
+	if g.maxValueSize > 0 && value.Len() > g.maxValueSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes of group %s", ErrValueTooLarge, value.Len(), g.maxValueSize, g.name)
+	}
+	return nil
 }
 
 func (g *Group) Delete(key string) error {
 	if key == "" {
-		return fmt.Errorf("key is required")
+		return ErrKeyRequired
 	}
+	// 先使租约失效再删除，删除之后不会再写入旧数据
+	g.leases.invalidate(key)
 	err := g.mainCache.delete(key)
+	if errors.Is(err, lru.ErrNotFound) {
+		return ErrKeyNotFound
+	}
 	return err
 }
 
-//func (g *Group) Keys() ([]string, error) {
-//
-//	return g.mainCache.lru.Keys()
-//}
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// DefaultTTL returns the ttl of values stored without one, 0 means they
+// never expire.
+func (g *Group) DefaultTTL() time.Duration {
+	return g.defaultTTL
+}
+
+// MaxValueSize returns the largest value the group accepts, 0 means no
+// limit besides the capacity.
+func (g *Group) MaxValueSize() int {
+	return g.maxValueSize
+}
+
+// Locked reports whether the group can't be deleted.
+func (g *Group) Locked() bool {
+	return g.locked
+}
+
+// Policy returns the name of the eviction policy of the group.
+func (g *Group) Policy() string {
+	return g.policy
+}
+
+// HasGetter reports whether misses are loaded by a Getter, in which case a
+// Get may wait for it.
+func (g *Group) HasGetter() bool {
+	return g.getter != nil
+}
+
+// MemoryUsage returns the capacity, used bytes and key count of the group.
+func (g *Group) MemoryUsage() (maxBytes int64, nbytes int64, keyCount int) {
+	return g.mainCache.lru.GetMemoryUsedSituation()
+}
+
+// Shards returns how many shards the keys of the group are spread over.
+func (g *Group) Shards() int {
+	return g.mainCache.lru.SliceNum
+}
 
 //Group Methods
 
@@ -98,7 +401,7 @@ func GetGroup(name string) (*Group, error) {
 	defer mu.RUnlock()
 	g, ok := groups[name]
 	if !ok {
-		return nil, errors.New("group not found")
+		return nil, ErrGroupNotFound
 	}
 	return g, nil
 }
@@ -119,26 +422,55 @@ func ListGroups() ([]string, error) {
 	return names, nil
 }
 
-//func (g *Group) GetStatus() *GroupStatus {
-//	mu.RLock()
-//	defer mu.RUnlock()
-//	maxbytes, nbytes, keyCount := g.mainCache.lru.GetMemoryUsedSituation()
-//	gs := &GroupStatus{
-//		name:     g.name,
-//		size:     maxbytes,
-//		used:     nbytes,
-//		keyCount: keyCount,
-//	}
-//	return gs
-//}
+// GetStatus returns the memory usage, the counters and the shard balance
+// of the group.
+func (g *Group) GetStatus() *GroupStatus {
+	gs := &GroupStatus{Name: g.name, Policy: g.policy}
+	maxKeys := 0
+	for _, s := range g.mainCache.lru.ShardStats() {
+		gs.add(&GroupStatus{
+			Size:      s.MaxBytes,
+			Used:      s.Bytes,
+			KeyCount:  s.Keys,
+			Hits:      s.Hits,
+			Misses:    s.Misses,
+			Sets:      s.Sets,
+			Deletes:   s.Deletes,
+			Evictions: s.Evictions,
+			Expired:   s.Expired,
+		})
+		gs.Shards = append(gs.Shards, ShardStatus{Keys: s.Keys, Used: s.Bytes, Evictions: s.Evictions})
+		maxKeys = max(maxKeys, s.Keys)
+	}
+	if gs.KeyCount > 0 {
+		gs.ShardSkew = float64(maxKeys) * float64(len(gs.Shards)) / float64(gs.KeyCount)
+	}
+	return gs
+}
+
+// GetStatuses returns the status of every group, sorted by name.
+func GetStatuses() []*GroupStatus {
+	mu.RLock()
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	statuses := make([]*GroupStatus, len(list))
+	for i, g := range list {
+		statuses[i] = g.GetStatus()
+	}
+	return statuses
+}
 
 func (gs *GroupStatus) ToString() string {
 	// 将字节转换为MB
-	sizeMB := float64(gs.size) / (1024 * 1024)
-	usedMB := float64(gs.used) / (1024 * 1024)
+	sizeMB := float64(gs.Size) / (1024 * 1024)
+	usedMB := float64(gs.Used) / (1024 * 1024)
 
 	// 计算使用率
-	usageRate := (float64(gs.used) / float64(gs.size)) * 100
+	usageRate := (float64(gs.Used) / float64(gs.Size)) * 100
 	return fmt.Sprintf("Group Status:\nName: %s\nSize: %.2f MB\nUsed: %.2f MB\nUsage Rate: %.2f%%\nKey Count: %d\n",
-		gs.name, sizeMB, usedMB, usageRate, gs.keyCount)
+		gs.Name, sizeMB, usedMB, usageRate, gs.KeyCount)
 }
@@ -1,11 +1,16 @@
 package huacache
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/huahuoao/huacache/core/lru"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -81,3 +86,162 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("cache remove failed")
 	}
 }
+
+func TestAddOrUpdateWithTTL(t *testing.T) {
+	cache, _ := NewGroup(generateRandomString(5), MB*100)
+	cache.AddOrUpdateWithTTL("key1", ByteView{B: []byte("value1")}, 20*time.Millisecond)
+	if _, err := cache.Get("key1"); err != nil {
+		t.Fatalf("cache get failed: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get("key1"); err == nil {
+		t.Fatalf("expired key should not be returned")
+	}
+}
+
+func TestGetter(t *testing.T) {
+	var calls int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		if key == "bad" {
+			return nil, errors.New("db down")
+		}
+		return []byte("loaded:" + key), nil
+	})
+	cache, _ := NewGroup(generateRandomString(5), MB*100, WithGetter(getter))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.Get("key1")
+			if err != nil || v.String() != "loaded:key1" {
+				t.Errorf("cache load failed: %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("concurrent misses should be loaded once, got %d", calls)
+	}
+	if _, err := cache.Get("key1"); err != nil || calls != 1 {
+		t.Fatalf("loaded key should be cached")
+	}
+
+	var loadErr *LoadError
+	if _, err := cache.Get("bad"); !errors.As(err, &loadErr) {
+		t.Fatalf("expect LoadError, got %v", err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	g, _ := NewGroup("conditional", MB*8)
+	defer DelGroup("conditional")
+
+	if _, err := g.Replace("k", ByteView{B: []byte("v")}, 0); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("replace of missing key should fail, got %v", err)
+	}
+	first, err := g.Add("k", ByteView{B: []byte("v1"), Flags: 7}, time.Minute)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := g.Add("k", ByteView{B: []byte("v2")}, 0); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("add of existing key should fail, got %v", err)
+	}
+	appended, err := g.Append("k", []byte("!"))
+	if err != nil || appended.Value.String() != "v1!" || appended.Value.Flags != 7 || appended.ExpireAt.IsZero() {
+		t.Fatalf("append should keep flags and ttl, got %+v %v", appended, err)
+	}
+	if _, err := g.CompareAndSwap("k", ByteView{B: []byte("x")}, 0, first.Version); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("cas with a stale version should fail, got %v", err)
+	}
+	if _, err := g.CompareAndSwap("k", ByteView{B: []byte("x")}, 0, appended.Version); err != nil {
+		t.Fatalf("cas failed: %v", err)
+	}
+	item, _ := g.GetItem("k")
+	if item.Value.String() != "x" || !item.ExpireAt.IsZero() || item.Version <= appended.Version {
+		t.Fatalf("unexpected item after cas: %+v", item)
+	}
+}
+
+func TestGroupPolicy(t *testing.T) {
+	if _, err := NewGroup("bad_policy", MB*8, WithPolicy("fifo")); err == nil {
+		t.Fatalf("expect an error for an unknown policy")
+	}
+	if _, err := GetGroup("bad_policy"); err == nil {
+		t.Fatalf("group with an unknown policy should not be created")
+	}
+	g, err := NewGroup("tinylfu", MB*8, WithPolicy(lru.PolicyTinyLFU))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer DelGroup("tinylfu")
+	if g.Policy() != lru.PolicyTinyLFU {
+		t.Fatalf("expect policy %s, got %s", lru.PolicyTinyLFU, g.Policy())
+	}
+
+	var buf bytes.Buffer
+	if err := SaveSnapshot(&buf); err != nil {
+		t.Fatalf("save snapshot failed: %v", err)
+	}
+	if err := LoadSnapshot(&buf); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	restored, _ := GetGroup("tinylfu")
+	if restored.Policy() != lru.PolicyTinyLFU {
+		t.Fatalf("policy not restored, got %s", restored.Policy())
+	}
+}
+
+func TestDeclareGroup(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(declared, "declared")
+		delete(groups, "declared")
+		mu.Unlock()
+	})
+	g, err := DeclareGroup("declared", MB*8, WithDefaultTTL(50*time.Millisecond), WithMaxValueSize(4), WithLocked())
+	if err != nil {
+		t.Fatalf("declare group failed: %v", err)
+	}
+	if err := g.AddOrUpdateWithTTL("short", ByteView{B: []byte("v")}, 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := g.AddOrUpdateWithTTL("long", ByteView{B: []byte("v")}, time.Hour); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := g.AddOrUpdateWithTTL("big", ByteView{B: []byte("too big")}, 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect ErrValueTooLarge, got %v", err)
+	}
+	if _, err := g.Append("long", []byte("more")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect append to respect the max value size, got %v", err)
+	}
+	if err := DelGroup("declared"); !errors.Is(err, ErrGroupLocked) {
+		t.Fatalf("expect ErrGroupLocked, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := g.Peek("short"); ok {
+		t.Fatalf("expect the default ttl to apply")
+	}
+	if _, ok := g.Peek("long"); !ok {
+		t.Fatalf("expect an explicit ttl to win over the default")
+	}
+
+	// 快照中没有的声明分组在加载后重建，并保留声明的设置
+	mu.Lock()
+	delete(groups, "declared")
+	mu.Unlock()
+	var buf bytes.Buffer
+	if err := SaveSnapshot(&buf); err != nil {
+		t.Fatalf("save snapshot failed: %v", err)
+	}
+	if err := LoadSnapshot(&buf); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	restored, err := GetGroup("declared")
+	if err != nil || !restored.Locked() || restored.MaxValueSize() != 4 || restored.DefaultTTL() != 50*time.Millisecond {
+		t.Fatalf("declared group not restored: %v %+v", err, restored)
+	}
+}
@@ -1,12 +1,15 @@
 package huacache
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const defaultBasePath = "/huacache/"
@@ -16,8 +19,14 @@ type HTTPPool struct {
 	// this peer's base URL, e.g. "https://example.net:8000"
 	self     string
 	basePath string
+	peers    PeerPicker // 为 nil 时所有 key 都在本地处理
+	acl      *ACL       // 为 nil 时不需要认证
+	writer   Writer     // 为 nil 时写入本地分组或 key 所属的节点
 }
 
+// userKey 是请求 context 中已认证用户的 key
+type userKey struct{}
+
 // NewHTTPPool initializes an HTTP pool of peers.
 func NewHTTPPool(self string) *HTTPPool {
 	return &HTTPPool{
@@ -26,6 +35,102 @@ func NewHTTPPool(self string) *HTTPPool {
 	}
 }
 
+// SetPeers makes the pool route keys owned by other nodes to them.
+func (p *HTTPPool) SetPeers(peers PeerPicker) {
+	p.peers = peers
+}
+
+// SetACL makes every request authenticate, with HTTP basic auth for
+// users, an "Authorization: Bearer <token>" header for tokens or a client
+// certificate when served over mutual TLS, and checks its permission on
+// the group.
+func (p *HTTPPool) SetACL(acl *ACL) {
+	p.acl = acl
+}
+
+// SetWriter makes every write go through w, usually the Bluebell server of
+// the node, so that it is logged to the AOF, replicated and routed like a
+// write received by Bluebell. The peers then only serve reads.
+func (p *HTTPPool) SetWriter(w Writer) {
+	p.writer = w
+}
+
+// keyWriter 写入单个 key 的操作，Writer 和 PeerClient 都实现了它
+type keyWriter interface {
+	Set(group string, key string, value []byte, ttl time.Duration) error
+	Delete(group string, key string) error
+	Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+	Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+}
+
+// writerFor 返回写入 key 的对象，为 nil 时直接写入本地分组
+func (p *HTTPPool) writerFor(key string) keyWriter {
+	if p.writer != nil {
+		return p.writer
+	}
+	if peer, ok := p.pickPeer(key); ok {
+		return peer
+	}
+	return nil
+}
+
+// httpStatus 返回请求失败时的 HTTP 状态码，对端节点返回的错误带有自己的状态码
+func httpStatus(err error) int {
+	var coded interface{ HTTPStatus() int }
+	var loadErr *LoadError
+	switch {
+	case errors.As(err, &coded):
+		return coded.HTTPStatus()
+	case errors.As(err, &loadErr):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrNotInteger), errors.Is(err, ErrOverflow), errors.Is(err, ErrKeyRequired):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// authenticate 返回发起请求的用户
+func (p *HTTPPool) authenticate(r *http.Request) (*User, error) {
+	if name, ok := PeerIdentity(r.TLS); ok {
+		// 客户端证书可以代替密码，没有对应的证书用户时再检查请求头
+		if user, err := p.acl.AuthenticateCert(name); err == nil {
+			return user, nil
+		}
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		return p.acl.Authenticate(name, password)
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return p.acl.Authenticate("", token)
+	}
+	return nil, ErrAuthFailed
+}
+
+// allowed 检查用户对分组的权限，无权限时写出 403
+func (p *HTTPPool) allowed(w http.ResponseWriter, r *http.Request, group string, perm Permission) bool {
+	if p.acl == nil {
+		return true
+	}
+	user := r.Context().Value(userKey{}).(*User)
+	if user.Can(group, perm) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("user %s has no access to group %s", user.Name, group), http.StatusForbidden)
+	return false
+}
+
+// pickPeer returns the peer owning key, if it is not this node.
+func (p *HTTPPool) pickPeer(key string) (PeerClient, bool) {
+	if p.peers == nil {
+		return nil, false
+	}
+	return p.peers.PickPeer(key)
+}
+
 func (p *HTTPPool) Log(format string, v ...interface{}) {
 	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
 }
@@ -34,11 +139,20 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 添加 CORS 头
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	if p.acl != nil {
+		user, err := p.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="huacache"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+	}
 	// /<basepath>/<groupname>/<action> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 1)
 	if len(parts) < 1 {
@@ -58,9 +172,15 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case DEL_KEY:
 		p.handleDelAction(w, r)
 	case LIST_GROUP:
-		p.handleListGroupsAction(w)
+		p.handleListGroupsAction(w, r)
 	case NEW_GROUP:
 		p.handleNewGroupAction(w, r)
+	case STATS:
+		p.handleStatsAction(w, r)
+	case GET_KEYS:
+		p.handleKeysAction(w, r)
+	case INCR, DECR:
+		p.handleCounterAction(w, r, action)
 	default:
 		http.Error(w, "not supported action: "+action, http.StatusBadRequest)
 	}
@@ -74,17 +194,40 @@ func (p *HTTPPool) handleGetAction(w http.ResponseWriter, r *http.Request) {
 	// 从表单中获取 "key" 的值
 	key := r.FormValue("key")
 	groupName := r.FormValue("group")
+	if !p.allowed(w, r, groupName, PermRead) {
+		return
+	}
+	if peer, ok := p.pickPeer(key); ok {
+		view, err := peer.GetView(groupName, key)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+		writeView(w, view)
+		return
+	}
 	group, err := GetGroup(groupName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 	view, err := group.Get(key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
+	writeView(w, view)
+}
+
+// staleHeader 标记超过 soft ttl 的值，见 WithSoftTTL
+const staleHeader = "X-Cache-Stale"
+
+// writeView 返回 get 的值，stale 的值带有 staleHeader
+func writeView(w http.ResponseWriter, view ByteView) {
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if view.Stale {
+		w.Header().Set(staleHeader, "1")
+	}
 	w.Write(view.ByteSlice())
 }
 
@@ -97,13 +240,30 @@ func (p *HTTPPool) handleSetAction(w http.ResponseWriter, r *http.Request) {
 	key := r.FormValue("key")
 	value := r.FormValue("value")
 	groupName := r.FormValue("group")
-	group, err := GetGroup(groupName)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !p.allowed(w, r, groupName, PermWrite) {
 		return
 	}
-	if err := group.AddOrUpdate(key, ByteView{B: []byte(value)}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// ttl 可选，格式如 "30s"、"1h"
+	var ttl time.Duration
+	if s := r.FormValue("ttl"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "ttl must be a duration such as 30s", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	var err error
+	if writer := p.writerFor(key); writer != nil {
+		err = writer.Set(groupName, key, []byte(value), ttl)
+	} else {
+		var group *Group
+		if group, err = GetGroup(groupName); err == nil {
+			err = group.AddOrUpdateWithTTL(key, ByteView{B: []byte(value)}, ttl)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
@@ -118,22 +278,40 @@ func (p *HTTPPool) handleDelAction(w http.ResponseWriter, r *http.Request) {
 	// 从表单中获取 "key" 的值
 	key := r.FormValue("key")
 	groupName := r.FormValue("group")
-	group, err := GetGroup(groupName)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !p.allowed(w, r, groupName, PermWrite) {
 		return
 	}
-	if err := group.Delete(key); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var err error
+	if writer := p.writerFor(key); writer != nil {
+		err = writer.Delete(groupName, key)
+	} else {
+		var group *Group
+		if group, err = GetGroup(groupName); err == nil {
+			err = group.Delete(key)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Write([]byte("del key success"))
 }
 
-func (p *HTTPPool) handleListGroupsAction(w http.ResponseWriter) {
+func (p *HTTPPool) handleListGroupsAction(w http.ResponseWriter, r *http.Request) {
 	// 获取所有组的名称
 	groups, _ := ListGroups()
+	if p.acl != nil {
+		// 只列出用户可以读取的分组
+		user := r.Context().Value(userKey{}).(*User)
+		visible := groups[:0]
+		for _, name := range groups {
+			if user.Can(name, PermRead) {
+				visible = append(visible, name)
+			}
+		}
+		groups = visible
+	}
 	// 以 JSON 格式返回组的名称列表
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(groups); err != nil {
@@ -146,6 +324,9 @@ func (p *HTTPPool) handleNewGroupAction(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusOK)
 	}
 	name := r.FormValue("name")
+	if !p.allowed(w, r, name, PermAdmin) {
+		return
+	}
 	capcity := r.FormValue("capacity")
 	//capacity转int64
 	capacity, err := strconv.ParseInt(capcity, 10, 64)
@@ -154,7 +335,12 @@ func (p *HTTPPool) handleNewGroupAction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	capacity *= MB
-	_, err = NewGroup(name, capacity)
+	// policy 可选：lru（默认）、lfu、arc、wtinylfu
+	if p.writer != nil {
+		err = p.writer.NewGroup(name, capacity, r.FormValue("policy"))
+	} else {
+		_, err = NewGroup(name, capacity, WithPolicy(r.FormValue("policy")))
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusOK)
 		return
@@ -162,3 +348,124 @@ func (p *HTTPPool) handleNewGroupAction(w http.ResponseWriter, r *http.Request)
 	response, _ := json.Marshal("success create group:" + name)
 	w.Write(response)
 }
+
+// handleStatsAction 返回节点的统计信息，format=json 时为 JSON，否则为文本；
+// 指定 group 时只包含该分组
+func (p *HTTPPool) handleStatsAction(w http.ResponseWriter, r *http.Request) {
+	groupName := r.FormValue("group")
+	if !p.allowed(w, r, groupName, PermRead) {
+		return
+	}
+	stats, err := GetNodeStats(groupName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(stats.Text()))
+}
+
+// handleKeysAction 返回分组中的一页 key，参数 cursor 为上一页返回的游标，
+// match 为通配符，count 为每页的 key 数，都是可选的；只包含本节点的 key
+func (p *HTTPPool) handleKeysAction(w http.ResponseWriter, r *http.Request) {
+	groupName := r.FormValue("group")
+	if !p.allowed(w, r, groupName, PermRead) {
+		return
+	}
+	var cursor uint64
+	if s := r.FormValue("cursor"); s != "" {
+		c, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "cursor must be a number", http.StatusBadRequest)
+			return
+		}
+		cursor = c
+	}
+	var count int
+	if s := r.FormValue("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "count must be a positive number", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	group, err := GetGroup(groupName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	keys, next, err := group.Scan(cursor, r.FormValue("match"), count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Cursor uint64   `json:"cursor"`
+		Keys   []string `json:"keys"`
+	}{next, keys})
+}
+
+// handleCounterAction 处理 incr、decr：delta 默认为 1，key 不存在时从 initial
+// （默认为 0）开始并在 ttl 后过期，返回新值
+func (p *HTTPPool) handleCounterAction(w http.ResponseWriter, r *http.Request, command string) {
+	key := r.FormValue("key")
+	groupName := r.FormValue("group")
+	if !p.allowed(w, r, groupName, PermWrite) {
+		return
+	}
+	delta, initial := int64(1), int64(0)
+	var ttl time.Duration
+	var err error
+	if s := r.FormValue("delta"); s != "" {
+		if delta, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "delta must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.FormValue("initial"); s != "" {
+		if initial, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "initial must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.FormValue("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil {
+			http.Error(w, "ttl must be a duration such as 30s", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var n int64
+	if writer := p.writerFor(key); writer != nil {
+		if command == INCR {
+			n, err = writer.Incr(groupName, key, delta, initial, ttl)
+		} else {
+			n, err = writer.Decr(groupName, key, delta, initial, ttl)
+		}
+	} else {
+		var group *Group
+		if group, err = GetGroup(groupName); err == nil {
+			if command == INCR {
+				n, err = group.Incr(key, delta, initial, ttl)
+			} else {
+				n, err = group.Decr(key, delta, initial, ttl)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(strconv.AppendInt(nil, n, 10))
+}
@@ -0,0 +1,109 @@
+package huacache
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// recordingWriter 记录经过它的写入，err 不为 nil 时拒绝写入
+type recordingWriter struct {
+	writes []string
+	err    error
+}
+
+func (w *recordingWriter) Set(group string, key string, value []byte, ttl time.Duration) error {
+	w.writes = append(w.writes, "set "+group+" "+key+" "+string(value))
+	return w.err
+}
+
+func (w *recordingWriter) Delete(group string, key string) error {
+	w.writes = append(w.writes, "del "+group+" "+key)
+	return w.err
+}
+
+func (w *recordingWriter) Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	w.writes = append(w.writes, "incr "+group+" "+key)
+	return delta, w.err
+}
+
+func (w *recordingWriter) Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	w.writes = append(w.writes, "decr "+group+" "+key)
+	return -delta, w.err
+}
+
+func (w *recordingWriter) NewGroup(name string, cacheBytes int64, policy string) error {
+	w.writes = append(w.writes, "new_group "+name+" "+policy)
+	return w.err
+}
+
+// misdirected 模拟只读副本返回的错误
+type misdirected struct{}
+
+func (misdirected) Error() string   { return "read-only replica" }
+func (misdirected) HTTPStatus() int { return http.StatusMisdirectedRequest }
+
+func TestHTTPPoolWriter(t *testing.T) {
+	DelGroup("http-writer")
+	if _, err := NewGroup("http-writer", 1<<20); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer DelGroup("http-writer")
+	writer := &recordingWriter{}
+	pool := NewHTTPPool("self")
+	pool.SetWriter(writer)
+
+	do := func(action, form string) int {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		values, _ := url.ParseQuery(form)
+		for k := range values {
+			mw.WriteField(k, values.Get(k))
+		}
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, defaultBasePath+action, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, req := range []struct{ action, form string }{
+		{SET_KEY, "group=http-writer&key=k&value=v"},
+		{DEL_KEY, "group=http-writer&key=k"},
+		{INCR, "group=http-writer&key=n"},
+		{DECR, "group=http-writer&key=n"},
+		{NEW_GROUP, "name=http-writer-2&capacity=1&policy=lfu"},
+	} {
+		if code := do(req.action, req.form); code != http.StatusOK {
+			t.Fatalf("expect %s to succeed, got %d", req.action, code)
+		}
+	}
+	want := []string{"set http-writer k v", "del http-writer k", "incr http-writer n", "decr http-writer n", "new_group http-writer-2 lfu"}
+	if len(writer.writes) != len(want) {
+		t.Fatalf("expect writes %q, got %q", want, writer.writes)
+	}
+	for i := range want {
+		if writer.writes[i] != want[i] {
+			t.Fatalf("expect writes %q, got %q", want, writer.writes)
+		}
+	}
+	// 写入没有绕过 writer 落到本地分组
+	group, _ := GetGroup("http-writer")
+	if _, ok := group.Peek("k"); ok {
+		t.Fatalf("set applied to the local group")
+	}
+
+	writer.err = misdirected{}
+	if code := do(SET_KEY, "group=http-writer&key=k&value=v"); code != http.StatusMisdirectedRequest {
+		t.Fatalf("expect 421 from a replica, got %d", code)
+	}
+	writer.err = ErrNotInteger
+	if code := do(INCR, "group=http-writer&key=k"); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for a non integer, got %d", code)
+	}
+}
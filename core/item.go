@@ -0,0 +1,255 @@
+package huacache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/huahuoao/huacache/core/lru"
+)
+
+// Item is a cached value together with its metadata.
+type Item struct {
+	Value    ByteView
+	Version  uint64    // changes on every write, used as the CAS token
+	ExpireAt time.Time // zero means the value never expires
+
+	ttl time.Duration // 设置 ExpireAt 时的 ttl，后台刷新时沿用
+}
+
+// TTL returns how long the item lives, 0 means it never expires.
+func (it Item) TTL() time.Duration {
+	if it.ExpireAt.IsZero() {
+		return 0
+	}
+	return time.Until(it.ExpireAt)
+}
+
+// itemHeaderSize 为编码后 item 的头部长度：flags uint32 | version uint64 |
+// expireAt int64（UnixNano，0 表示永不过期）| ttl int64，随后是值
+const itemHeaderSize = 4 + 8 + 8 + 8
+
+// MarshalBinary encodes the item with its metadata, so that another node can
+// store it as is.
+func (it Item) MarshalBinary() ([]byte, error) {
+	b := make([]byte, itemHeaderSize, itemHeaderSize+it.Value.Len())
+	binary.BigEndian.PutUint32(b, it.Value.Flags)
+	binary.BigEndian.PutUint64(b[4:], it.Version)
+	if !it.ExpireAt.IsZero() {
+		binary.BigEndian.PutUint64(b[12:], uint64(it.ExpireAt.UnixNano()))
+		binary.BigEndian.PutUint64(b[20:], uint64(it.ttl))
+	}
+	return append(b, it.Value.B...), nil
+}
+
+// UnmarshalBinary decodes an item encoded by MarshalBinary.
+func (it *Item) UnmarshalBinary(data []byte) error {
+	if len(data) < itemHeaderSize {
+		return fmt.Errorf("item of %d bytes is too short", len(data))
+	}
+	*it = Item{
+		Value:   ByteView{B: bytes.Clone(data[itemHeaderSize:]), Flags: binary.BigEndian.Uint32(data)},
+		Version: binary.BigEndian.Uint64(data[4:]),
+	}
+	if expireAt := int64(binary.BigEndian.Uint64(data[12:])); expireAt != 0 {
+		it.ExpireAt = time.Unix(0, expireAt)
+		it.ttl = time.Duration(binary.BigEndian.Uint64(data[20:]))
+	}
+	return nil
+}
+
+func toItem(it lru.Item) Item {
+	item := Item{Version: it.Version, ttl: time.Duration(it.TTL)}
+	if it.Value != nil {
+		item.Value = it.Value.(ByteView)
+	}
+	if it.ExpireAt != 0 {
+		item.ExpireAt = time.Unix(0, it.ExpireAt)
+	}
+	return item
+}
+
+func fromItem(it Item) lru.Item {
+	item := lru.Item{Value: it.Value}
+	if !it.ExpireAt.IsZero() {
+		item.ExpireAt, item.TTL = it.ExpireAt.UnixNano(), int64(it.ttl)
+	}
+	return item
+}
+
+// expireAt converts a ttl to the expiry time, 0 means never.
+func expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// ExpireAt returns when a value stored now with ttl expires, applying the
+// default ttl and the stale window of the group like Set. Zero means never.
+func (g *Group) ExpireAt(ttl time.Duration) time.Time {
+	return expireAt(g.ttl(ttl))
+}
+
+// Restore stores the value with its expiry as is, without applying the
+// default ttl or the stale window of the group. It is meant for values
+// whose expiry was already computed by a group, as in snapshots.
+func (g *Group) Restore(key string, value ByteView, expireAt time.Time) (Item, error) {
+	return g.Update(key, func(Item, bool) (Item, error) {
+		return Item{Value: value, ExpireAt: expireAt}, nil
+	})
+}
+
+// NewItem returns value as Set would store it with ttl, applying the default
+// ttl and the stale window of the group. Unlike a literal Item, the item
+// keeps its ttl for the background refresh of stale values.
+func (g *Group) NewItem(value ByteView, ttl time.Duration) Item {
+	ttl = g.ttl(ttl)
+	return Item{Value: value, ExpireAt: expireAt(ttl), ttl: max(ttl, 0)}
+}
+
+// GetItem returns the value of key along with its version and expiry,
+// loading it with the getter on a miss. A loaded value that could not be
+// cached has version 0.
+func (g *Group) GetItem(key string) (Item, error) {
+	if key == "" {
+		return Item{}, ErrKeyRequired
+	}
+	if it, ok := g.mainCache.getItem(key); ok {
+		return g.serve(key, toItem(it)), nil
+	}
+	v, err := g.load(key)
+	if err != nil {
+		return Item{}, err
+	}
+	if it, ok := g.mainCache.getItem(key); ok {
+		return toItem(it), nil
+	}
+	return Item{Value: v}, nil
+}
+
+// Peek returns the cached item of key, unlike GetItem it never calls the
+// getter.
+func (g *Group) Peek(key string) (Item, bool) {
+	it, ok := g.mainCache.getItem(key)
+	if !ok {
+		return Item{}, false
+	}
+	return g.markStale(toItem(it)), true
+}
+
+// Update atomically replaces the item under key with the one returned by
+// fn, fn is called with the current item under the shard lock and ok is
+// false when the key is missing. Returning an error leaves the key
+// unchanged. The stored item is returned with its new version.
+func (g *Group) Update(key string, fn func(old Item, ok bool) (Item, error)) (Item, error) {
+	if key == "" {
+		return Item{}, ErrKeyRequired
+	}
+	it, err := g.mainCache.update(key, func(old lru.Item, ok bool) (lru.Item, error) {
+		item, err := fn(toItem(old), ok)
+		if err != nil {
+			return lru.Item{}, err
+		}
+		if err := g.checkSize(item.Value); err != nil {
+			return lru.Item{}, err
+		}
+		return fromItem(item), nil
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return toItem(it), nil
+}
+
+// Set stores the value like AddOrUpdateWithTTL and returns the stored item
+// with its new version.
+func (g *Group) Set(key string, value ByteView, ttl time.Duration) (Item, error) {
+	return g.Update(key, func(Item, bool) (Item, error) {
+		return g.NewItem(value, ttl), nil
+	})
+}
+
+// Add stores the value only if key is not cached yet.
+func (g *Group) Add(key string, value ByteView, ttl time.Duration) (Item, error) {
+	return g.Update(key, func(old Item, ok bool) (Item, error) {
+		if ok {
+			return Item{}, ErrKeyExists
+		}
+		return g.NewItem(value, ttl), nil
+	})
+}
+
+// Replace stores the value only if key is already cached.
+func (g *Group) Replace(key string, value ByteView, ttl time.Duration) (Item, error) {
+	return g.Update(key, func(old Item, ok bool) (Item, error) {
+		if !ok {
+			return Item{}, ErrKeyNotFound
+		}
+		return g.NewItem(value, ttl), nil
+	})
+}
+
+// CompareAndSwap stores the value only if the version of key is still
+// version, i.e. nobody wrote it since it was read.
+func (g *Group) CompareAndSwap(key string, value ByteView, ttl time.Duration, version uint64) (Item, error) {
+	return g.Update(key, func(old Item, ok bool) (Item, error) {
+		if !ok {
+			return Item{}, ErrKeyNotFound
+		}
+		if old.Version != version {
+			return Item{}, ErrVersionMismatch
+		}
+		return g.NewItem(value, ttl), nil
+	})
+}
+
+// Append adds data after the cached value of key, keeping its flags and ttl.
+func (g *Group) Append(key string, data []byte) (Item, error) {
+	return g.concat(key, data, false)
+}
+
+// Prepend adds data before the cached value of key, keeping its flags and ttl.
+func (g *Group) Prepend(key string, data []byte) (Item, error) {
+	return g.concat(key, data, true)
+}
+
+func (g *Group) concat(key string, data []byte, prepend bool) (Item, error) {
+	return g.Update(key, func(old Item, ok bool) (Item, error) {
+		if !ok {
+			return Item{}, ErrKeyNotFound
+		}
+		b := make([]byte, 0, old.Value.Len()+len(data))
+		if prepend {
+			b = append(append(b, data...), old.Value.B...)
+		} else {
+			b = append(append(b, old.Value.B...), data...)
+		}
+		old.Value = ByteView{B: b, Flags: old.Value.Flags}
+		return old, nil
+	})
+}
+
+// Touch changes the ttl of key, a zero ttl makes it never expire.
+func (g *Group) Touch(key string, ttl time.Duration) error {
+	if key == "" {
+		return ErrKeyRequired
+	}
+	if ttl < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if ttl > 0 {
+		ttl += g.staleFor
+	}
+	if !g.mainCache.lru.GetLru(key).Touch(key, ttl) {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Flush removes every key of the group.
+func (g *Group) Flush() {
+	g.leases.clear()
+	g.mainCache.lru.Purge()
+}
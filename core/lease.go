@@ -0,0 +1,117 @@
+package huacache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 租约与 Facebook memcache 的 lease 相同：key 未命中时只有第一个客户端拿到租约去
+// 回源，其余客户端稍后重试，不会同时打到数据库；回源的结果只有带着有效的租约才能
+// 写入，删除 key 会使租约失效，从而拒绝删除之前读到的旧数据。
+
+// DefaultLeaseTTL is how long a lease lasts unless WithLeaseTTL is given.
+const DefaultLeaseTTL = 10 * time.Second
+
+// leaseTokens 为所有分组共享的租约令牌序列
+var leaseTokens atomic.Uint64
+
+// WithLeaseTTL sets how long a lease handed out by LeaseGet lasts, the
+// holder must set the key within it. Non-positive values are ignored.
+func WithLeaseTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if ttl > 0 {
+			g.leaseTTL = ttl
+		}
+	}
+}
+
+type lease struct {
+	token   uint64
+	expires time.Time
+}
+
+// leaseTable 记录分组中未过期的租约
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]lease
+	swept  int // 上次清理后剩余的租约数，数量翻倍时再清理一次
+}
+
+// LeaseGet returns the cached item of key, it never calls the getter. On a
+// miss the first caller gets ErrKeyNotFound with a lease token and should
+// load the value and store it with LeaseSet; until the lease is used or
+// expires, other callers get ErrLeaseWait and should retry shortly.
+func (g *Group) LeaseGet(key string) (item Item, token uint64, err error) {
+	if key == "" {
+		return Item{}, 0, ErrKeyRequired
+	}
+	if it, ok := g.mainCache.getItem(key); ok {
+		return g.markStale(toItem(it)), 0, nil
+	}
+
+	t := &g.leases
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// 等待锁期间可能已经有租约的持有者写入了
+	if it, ok := g.mainCache.getItem(key); ok {
+		return g.markStale(toItem(it)), 0, nil
+	}
+	now := time.Now()
+	if l, ok := t.leases[key]; ok && now.Before(l.expires) {
+		return Item{}, 0, ErrLeaseWait
+	}
+	if t.leases == nil {
+		t.leases = make(map[string]lease)
+	}
+	if len(t.leases) >= max(2*t.swept, 64) {
+		for k, l := range t.leases {
+			if !now.Before(l.expires) {
+				delete(t.leases, k)
+			}
+		}
+		t.swept = len(t.leases)
+	}
+	token = leaseTokens.Add(1)
+	t.leases[key] = lease{token: token, expires: now.Add(g.leaseTTL)}
+	return Item{}, token, ErrKeyNotFound
+}
+
+// LeaseSet stores the value of key if token is its outstanding lease, the
+// lease is used up. It fails with ErrLeaseInvalid when the lease expired,
+// was used or the key was deleted since it was handed out.
+func (g *Group) LeaseSet(key string, value ByteView, ttl time.Duration, token uint64) (Item, error) {
+	if key == "" {
+		return Item{}, ErrKeyRequired
+	}
+	t := &g.leases
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.leases[key]
+	if !ok || l.token != token || !time.Now().Before(l.expires) {
+		return Item{}, ErrLeaseInvalid
+	}
+	// 持有租约表的锁写入，保证与删除的先后顺序
+	item, err := g.Set(key, value, ttl)
+	if err != nil {
+		return Item{}, err
+	}
+	delete(t.leases, key)
+	return item, nil
+}
+
+// invalidate 使 keys 的租约失效
+func (t *leaseTable) invalidate(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.leases, key)
+	}
+}
+
+// clear 使所有租约失效
+func (t *leaseTable) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.leases, t.swept = nil, 0
+}
@@ -0,0 +1,95 @@
+package huacache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	DelGroup("lease")
+	g, _ := NewGroup("lease", 8*MB, WithLeaseTTL(50*time.Millisecond))
+	defer DelGroup("lease")
+
+	// 并发未命中时只有一个客户端拿到租约
+	var granted, waiting atomic.Int32
+	var token atomic.Uint64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, tok, err := g.LeaseGet("hot")
+			switch {
+			case errors.Is(err, ErrKeyNotFound) && tok != 0:
+				granted.Add(1)
+				token.Store(tok)
+			case errors.Is(err, ErrLeaseWait):
+				waiting.Add(1)
+			default:
+				t.Errorf("unexpected lease get result %d %v", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if granted.Load() != 1 || waiting.Load() != 99 {
+		t.Fatalf("expect 1 lease and 99 waits, got %d and %d", granted.Load(), waiting.Load())
+	}
+	if _, err := g.LeaseSet("hot", ByteView{B: []byte("v")}, 0, token.Load()+1); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expect ErrLeaseInvalid for a wrong token, got %v", err)
+	}
+	if _, err := g.LeaseSet("hot", ByteView{B: []byte("v")}, 0, token.Load()); err != nil {
+		t.Fatalf("lease set failed: %v", err)
+	}
+	if _, err := g.LeaseSet("hot", ByteView{B: []byte("v2")}, 0, token.Load()); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expect a used lease to be invalid, got %v", err)
+	}
+	if item, tok, err := g.LeaseGet("hot"); err != nil || tok != 0 || item.Value.String() != "v" {
+		t.Fatalf("expect a hit, got %v %d %v", item, tok, err)
+	}
+
+	// 删除使租约失效，之前读到的旧数据写不进去
+	g.Delete("hot")
+	_, tok, _ := g.LeaseGet("hot")
+	g.Delete("hot")
+	if _, err := g.LeaseSet("hot", ByteView{B: []byte("old")}, 0, tok); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expect a deleted key to invalidate its lease, got %v", err)
+	}
+
+	// 租约过期后重新发放
+	_, first, _ := g.LeaseGet("slow")
+	time.Sleep(60 * time.Millisecond)
+	_, second, err := g.LeaseGet("slow")
+	if !errors.Is(err, ErrKeyNotFound) || second == 0 || second == first {
+		t.Fatalf("expect a new lease after expiry, got %d %v", second, err)
+	}
+	if _, err := g.LeaseSet("slow", ByteView{B: []byte("v")}, 0, first); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expect an expired lease to be invalid, got %v", err)
+	}
+}
+
+func TestLeaseGetStaleAfterWait(t *testing.T) {
+	DelGroup("lease-stale")
+	g, _ := NewGroup("lease-stale", 8*MB, WithSoftTTL(time.Millisecond, time.Hour))
+	defer DelGroup("lease-stale")
+
+	// 持有租约表的锁，让 LeaseGet 在锁内的第二次查找中读到 key
+	g.leases.mu.Lock()
+	got := make(chan Item)
+	go func() {
+		item, _, err := g.LeaseGet("k")
+		if err != nil {
+			t.Errorf("lease get failed: %v", err)
+		}
+		got <- item
+	}()
+	time.Sleep(20 * time.Millisecond)
+	g.Set("k", ByteView{B: []byte("v")}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	g.leases.mu.Unlock()
+	if item := <-got; !item.Value.Stale {
+		t.Fatalf("expect a stale item, got %+v", item)
+	}
+}
@@ -0,0 +1,138 @@
+package lru
+
+import "container/list"
+
+// arcList 为 ARC 中的一个链表，同时记录其中 key 的总字节数
+type arcList struct {
+	ll    *list.List
+	bytes int64
+}
+
+type arcEntry struct {
+	key   string
+	size  int64
+	where *arcList
+}
+
+// arcPolicy 实现 Adaptive Replacement Cache（Megiddo & Modha），按字节而非条目计量：
+// t1 保存只访问过一次的 key，t2 保存访问过多次的 key，b1、b2 分别记录最近从
+// t1、t2 淘汰的 key（只保留 key）。命中 b1 说明 t1 太小，增大目标值 p；命中 b2 则减小 p。
+type arcPolicy struct {
+	c, p           int64 // 容量和 t1 的目标大小
+	t1, t2, b1, b2 arcList
+	items          map[string]*list.Element
+}
+
+// NewARCPolicy returns an Adaptive Replacement Cache policy for a cache of
+// maxBytes, it balances recency and frequency from the keys it evicted.
+func NewARCPolicy(maxBytes int64) Policy {
+	return &arcPolicy{
+		c:     maxBytes,
+		t1:    arcList{ll: list.New()},
+		t2:    arcList{ll: list.New()},
+		b1:    arcList{ll: list.New()},
+		b2:    arcList{ll: list.New()},
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (p *arcPolicy) unlink(ele *list.Element) *arcEntry {
+	e := ele.Value.(*arcEntry)
+	e.where.ll.Remove(ele)
+	e.where.bytes -= e.size
+	delete(p.items, e.key)
+	return e
+}
+
+func (p *arcPolicy) pushFront(to *arcList, e *arcEntry) {
+	e.where = to
+	to.bytes += e.size
+	p.items[e.key] = to.ll.PushFront(e)
+}
+
+func (p *arcPolicy) Add(key string, size int64) {
+	ele, ok := p.items[key]
+	if !ok {
+		p.pushFront(&p.t1, &arcEntry{key: key, size: size})
+		p.trimGhosts()
+		return
+	}
+	e := p.unlink(ele)
+	switch e.where {
+	case &p.b1:
+		delta := size
+		if p.b1.bytes > 0 && p.b2.bytes > p.b1.bytes {
+			delta = size * p.b2.bytes / p.b1.bytes
+		}
+		p.p = min(p.c, p.p+delta)
+	case &p.b2:
+		delta := size
+		if p.b2.bytes > 0 && p.b1.bytes > p.b2.bytes {
+			delta = size * p.b1.bytes / p.b2.bytes
+		}
+		p.p = max(0, p.p-delta)
+	}
+	e.size = size
+	p.pushFront(&p.t2, e)
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) Access(key string, size int64) {
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	e := ele.Value.(*arcEntry)
+	if e.where != &p.t1 && e.where != &p.t2 {
+		return
+	}
+	p.unlink(ele)
+	e.size = size
+	p.pushFront(&p.t2, e)
+}
+
+func (p *arcPolicy) Remove(key string) {
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	if e := ele.Value.(*arcEntry); e.where == &p.t1 || e.where == &p.t2 {
+		p.unlink(ele)
+	}
+}
+
+func (p *arcPolicy) Evict() (string, bool) {
+	var from, to *arcList
+	switch {
+	case p.t1.ll.Len() > 0 && (p.t1.bytes > p.p || p.t2.ll.Len() == 0):
+		from, to = &p.t1, &p.b1
+	case p.t2.ll.Len() > 0:
+		from, to = &p.t2, &p.b2
+	default:
+		return "", false
+	}
+	e := p.unlink(from.ll.Back())
+	p.pushFront(to, e)
+	p.trimGhosts()
+	return e.key, true
+}
+
+// trimGhosts 限制 t1+b1 不超过 c，全部链表不超过 2c
+func (p *arcPolicy) trimGhosts() {
+	for p.b1.ll.Len() > 0 && p.t1.bytes+p.b1.bytes > p.c {
+		p.unlink(p.b1.ll.Back())
+	}
+	for p.b2.ll.Len() > 0 && p.t1.bytes+p.t2.bytes+p.b1.bytes+p.b2.bytes > 2*p.c {
+		p.unlink(p.b2.ll.Back())
+	}
+}
+
+func (p *arcPolicy) Walk(fn func(key string) bool) {
+	for _, l := range []*arcList{&p.t1, &p.t2} {
+		for ele := l.ll.Back(); ele != nil; ele = ele.Prev() {
+			if !fn(ele.Value.(*arcEntry).key) {
+				return
+			}
+		}
+	}
+}
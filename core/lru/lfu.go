@@ -0,0 +1,106 @@
+package lru
+
+import (
+	"container/list"
+	"sort"
+)
+
+type lfuEntry struct {
+	key  string
+	freq int
+}
+
+// lfuPolicy 淘汰访问次数最少的 key，次数相同时淘汰最久未使用的。
+// 每个访问次数对应一个链表，增删改均为 O(1)。
+type lfuPolicy struct {
+	items   map[string]*list.Element
+	freqs   map[int]*list.List
+	minFreq int
+}
+
+// NewLFUPolicy evicts the least frequently used key, ties are broken by
+// recency.
+func NewLFUPolicy() Policy {
+	return &lfuPolicy{
+		items: make(map[string]*list.Element),
+		freqs: make(map[int]*list.List),
+	}
+}
+
+func (p *lfuPolicy) push(e *lfuEntry) {
+	l, ok := p.freqs[e.freq]
+	if !ok {
+		l = list.New()
+		p.freqs[e.freq] = l
+	}
+	p.items[e.key] = l.PushFront(e)
+}
+
+// unlink 将元素从所在的链表移除，链表为空时一并删除
+func (p *lfuPolicy) unlink(ele *list.Element) *lfuEntry {
+	e := ele.Value.(*lfuEntry)
+	l := p.freqs[e.freq]
+	l.Remove(ele)
+	if l.Len() == 0 {
+		delete(p.freqs, e.freq)
+	}
+	delete(p.items, e.key)
+	return e
+}
+
+func (p *lfuPolicy) Add(key string, size int64) {
+	p.push(&lfuEntry{key: key, freq: 1})
+	p.minFreq = 1
+}
+
+func (p *lfuPolicy) Access(key string, size int64) {
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	e := p.unlink(ele)
+	if e.freq == p.minFreq && p.freqs[e.freq] == nil {
+		p.minFreq++
+	}
+	e.freq++
+	p.push(e)
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	// minFreq 可能因此失效，在 Evict 时修正
+	if ele, ok := p.items[key]; ok {
+		p.unlink(ele)
+	}
+}
+
+func (p *lfuPolicy) Evict() (string, bool) {
+	if len(p.items) == 0 {
+		return "", false
+	}
+	l, ok := p.freqs[p.minFreq]
+	if !ok {
+		p.minFreq = 0
+		for freq := range p.freqs {
+			if p.minFreq == 0 || freq < p.minFreq {
+				p.minFreq = freq
+			}
+		}
+		l = p.freqs[p.minFreq]
+	}
+	return p.unlink(l.Back()).key, true
+}
+
+func (p *lfuPolicy) Walk(fn func(key string) bool) {
+	freqs := make([]int, 0, len(p.freqs))
+	for freq := range p.freqs {
+		freqs = append(freqs, freq)
+	}
+	sort.Ints(freqs)
+	for _, freq := range freqs {
+		for ele := p.freqs[freq].Back(); ele != nil; ele = ele.Prev() {
+			if !fn(ele.Value.(*lfuEntry).key) {
+				return
+			}
+		}
+	}
+}
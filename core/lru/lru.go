@@ -1,24 +1,91 @@
 package lru
 
 import (
-	"container/list"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Cache is a LRU cache. It is safe for concurrent access.
+// ErrTooLarge is returned when a single entry is larger than the cache.
+var ErrTooLarge = errors.New("new item exceeds cache maximum limit")
+
+// ErrNotFound is returned when deleting a key that is not cached.
+var ErrNotFound = errors.New("key does not exist")
+
+// versions 为所有缓存共享的版本号序列，保证同一个 key 的版本号单调递增
+var versions atomic.Uint64
+
+// Cache is a size bounded cache, the Policy picks the entries evicted when
+// it is full (LRU by default). It is safe for concurrent access.
 type Cache struct {
 	maxBytes  int64 // cache max byte limit
 	nbytes    int64 // used bytes
-	ll        *list.List
-	cache     map[string]*list.Element
+	policy    Policy
+	cache     map[string]*entry
+	expires   map[string]*entry             // 设置了过期时间的 key，供后台抽样清理
+	index     scanIndex                     // 按散列值排列的 key，供 Scan 分页
 	mu        sync.RWMutex                  // 用于保护缓存并发访问
 	OnEvicted func(key string, value Value) // optional and executed when an entry is purged.
+	stats     Stats                         // 计数器，由 mu 保护
+}
+
+// Stats are the counters of a Cache since it was created, along with its
+// current size.
+type Stats struct {
+	Hits      uint64 // lookups that found a live entry
+	Misses    uint64 // lookups of missing or expired keys
+	Sets      uint64 // entries written
+	Deletes   uint64 // entries deleted by key
+	Evictions uint64 // entries evicted by the policy to make room
+	Expired   uint64 // entries removed because their ttl passed
+	Keys      int
+	Bytes     int64
+	MaxBytes  int64
+}
+
+// Add sums the stats of two caches, e.g. the shards of a ShardingLRU.
+func (s Stats) Add(o Stats) Stats {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Deletes += o.Deletes
+	s.Evictions += o.Evictions
+	s.Expired += o.Expired
+	s.Keys += o.Keys
+	s.Bytes += o.Bytes
+	s.MaxBytes += o.MaxBytes
+	return s
 }
 
 type entry struct {
-	key   string
-	value Value
+	key      string
+	hash     uint32 // scanHash(key)，决定 key 在 scanIndex 中的位置
+	value    Value
+	expireAt int64  // 过期时间（UnixNano），0 表示永不过期
+	ttl      int64  // 写入或 Touch 时的 ttl（纳秒）
+	version  uint64 // 每次写入都会更新，可用作 CAS 令牌
+}
+
+// Item is a copy of a cache entry and its metadata.
+type Item struct {
+	Value    Value
+	ExpireAt int64  // UnixNano, 0 means the entry never expires
+	TTL      int64  // nanoseconds, the ttl ExpireAt was set with, 0 when unknown
+	Version  uint64 // changes on every write of the entry
+}
+
+func (e *entry) item() Item {
+	return Item{Value: e.value, ExpireAt: e.expireAt, TTL: e.ttl, Version: e.version}
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expireAt != 0 && e.expireAt <= now
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
 }
 
 // Value use Len to count how many bytes it takes
@@ -28,82 +95,302 @@ type Value interface {
 
 // New is the Constructor of Cache
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return NewWithPolicy(maxBytes, NewLRUPolicy(), onEvicted)
+}
+
+// NewWithPolicy creates a Cache evicting the entries chosen by policy.
+func NewWithPolicy(maxBytes int64, policy Policy, onEvicted func(string, Value)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
-		ll:        list.New(),
-		cache:     make(map[string]*list.Element),
+		policy:    policy,
+		cache:     make(map[string]*entry),
+		expires:   make(map[string]*entry),
 		OnEvicted: onEvicted,
 	}
 }
 
 func (c *Cache) Get(key string) (value Value, ok bool) {
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+// GetItem returns the entry under key together with its metadata.
+func (c *Cache) GetItem(key string) (item Item, ok bool) {
+	var removed []*entry
+	defer c.notify(&removed)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		return kv.value, true
+	if kv := c.lookup(key, &removed); kv != nil {
+		c.policy.Access(key, kv.size())
+		c.stats.Hits++
+		return kv.item(), true
 	}
+	c.stats.Misses++
 	return
 }
 
+// lookup returns the live entry of key, an expired one is removed.
+// The caller must hold the write lock.
+func (c *Cache) lookup(key string, removed *[]*entry) *entry {
+	kv, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+	if kv.expired(time.Now().UnixNano()) {
+		*removed = append(*removed, c.removeEntry(kv))
+		c.stats.Expired++
+		return nil
+	}
+	return kv
+}
+
 func (c *Cache) DeleteKey(key string) error {
+	var removed []*entry
+	defer c.notify(&removed)
 	c.mu.Lock() // 写锁
 	defer c.mu.Unlock()
 
-	if ele, ok := c.cache[key]; ok {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-
-		// 使用 goroutine 异步处理回调
-		if c.OnEvicted != nil {
-			go c.OnEvicted(kv.key, kv.value)
-		}
+	if kv := c.lookup(key, &removed); kv != nil {
+		removed = append(removed, c.removeEntry(kv))
+		c.stats.Deletes++
 		return nil
 	}
-	return fmt.Errorf("key does not exist")
+	return ErrNotFound
 }
 
 // Add adds a value to the cache.
 func (c *Cache) Add(key string, value Value) error {
+	return c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL adds a value to the cache which expires after ttl.
+// A zero ttl means the entry never expires.
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+	_, err := c.Update(key, func(Item, bool) (Item, error) {
+		return Item{Value: value, ExpireAt: expireAt, TTL: int64(ttl)}, nil
+	})
+	return err
+}
+
+// Update atomically replaces the entry under key with the one returned by
+// fn, which is called with the current entry while the lock is held. ok is
+// false when the key is missing or expired. The version of the returned
+// item is ignored and a new one is assigned. When fn returns an error the
+// cache is left unchanged.
+func (c *Cache) Update(key string, fn func(old Item, ok bool) (Item, error)) (Item, error) {
+	var removed []*entry
+	defer c.notify(&removed)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.maxBytes != 0 && int64(len(key))+int64(value.Len()) > c.maxBytes {
-		return fmt.Errorf("new item exceeds cache maximum limit")
+	return c.update(key, fn, &removed)
+}
+
+// update implements Update, the caller must hold the write lock.
+func (c *Cache) update(key string, fn func(old Item, ok bool) (Item, error), removed *[]*entry) (Item, error) {
+	var old Item
+	kv := c.lookup(key, removed)
+	if kv != nil {
+		old = kv.item()
+	}
+	item, err := fn(old, kv != nil)
+	if err != nil {
+		return Item{}, err
+	}
+	if c.maxBytes != 0 && int64(len(key))+int64(item.Value.Len()) > c.maxBytes {
+		return Item{}, ErrTooLarge
 	}
+	item.Version = versions.Add(1)
+	c.stats.Sets++
 
-	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
-		kv.value = value
+	if kv != nil {
+		c.nbytes += int64(item.Value.Len()) - int64(kv.value.Len())
+		kv.value = item.Value
+		kv.expireAt, kv.ttl = item.ExpireAt, item.TTL
+		kv.version = item.Version
+		c.policy.Access(key, kv.size())
 	} else {
-		ele := c.ll.PushFront(&entry{key, value})
-		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+		kv = &entry{key: key, hash: scanHash(key), value: item.Value, expireAt: item.ExpireAt, ttl: item.TTL, version: item.Version}
+		c.cache[key] = kv
+		c.index.add(kv)
+		c.nbytes += kv.size()
+		c.policy.Add(key, kv.size())
 	}
+	c.trackExpire(kv)
 
 	// 只有在这里移除元素，减少锁的持有时间
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		ele := c.ll.Back()
-		if ele != nil {
-			c.ll.Remove(ele)
-			kv := ele.Value.(*entry)
-			delete(c.cache, kv.key)
-			c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-
-			// 使用 goroutine 异步处理回调，避免长时间持锁
-			if c.OnEvicted != nil {
-				go c.OnEvicted(kv.key, kv.value)
-			}
+		victim, ok := c.policy.Evict()
+		if !ok {
+			break
+		}
+		*removed = append(*removed, c.forget(c.cache[victim]))
+		c.stats.Evictions++
+	}
+
+	return item, nil
+}
+
+// GetItems looks up all keys under a single lock, ok[i] reports whether
+// keys[i] was found.
+func (c *Cache) GetItems(keys []string) (items []Item, ok []bool) {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items = make([]Item, len(keys))
+	ok = make([]bool, len(keys))
+	for i, key := range keys {
+		if kv := c.lookup(key, &removed); kv != nil {
+			c.policy.Access(key, kv.size())
+			items[i], ok[i] = kv.item(), true
+			c.stats.Hits++
+		} else {
+			c.stats.Misses++
+		}
+	}
+	return items, ok
+}
+
+// AddItems stores values[i] under keys[i] for every i under a single lock,
+// the entries expire after ttl. errs[i] is the error of keys[i].
+func (c *Cache) AddItems(keys []string, values []Value, ttl time.Duration) (errs []error) {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+	errs = make([]error, len(keys))
+	for i, key := range keys {
+		_, errs[i] = c.update(key, func(Item, bool) (Item, error) {
+			return Item{Value: values[i], ExpireAt: expireAt, TTL: int64(ttl)}, nil
+		}, &removed)
+	}
+	return errs
+}
+
+// DeleteKeys removes all keys under a single lock, deleted[i] reports
+// whether keys[i] existed.
+func (c *Cache) DeleteKeys(keys []string) (deleted []bool) {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted = make([]bool, len(keys))
+	for i, key := range keys {
+		if kv := c.lookup(key, &removed); kv != nil {
+			removed = append(removed, c.removeEntry(kv))
+			deleted[i] = true
+			c.stats.Deletes++
 		}
 	}
+	return deleted
+}
+
+// Touch changes the ttl of key without modifying its value, a zero ttl
+// removes the expiry. It reports whether the key exists.
+func (c *Cache) Touch(key string, ttl time.Duration) bool {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return nil
+	kv := c.lookup(key, &removed)
+	if kv == nil {
+		return false
+	}
+	kv.expireAt, kv.ttl = 0, 0
+	if ttl > 0 {
+		kv.expireAt, kv.ttl = time.Now().Add(ttl).UnixNano(), int64(ttl)
+	}
+	c.trackExpire(kv)
+	return true
+}
+
+// Purge removes all entries.
+func (c *Cache) Purge() {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, kv := range c.cache {
+		removed = append(removed, c.removeEntry(kv))
+	}
+}
+
+// RemoveExpired samples at most sample keys that carry a ttl and removes the
+// expired ones. It returns how many keys were sampled and removed.
+func (c *Cache) RemoveExpired(sample int) (sampled, expired int) {
+	var removed []*entry
+	defer c.notify(&removed)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	// map 的遍历顺序是随机的，相当于随机抽样
+	for _, kv := range c.expires {
+		if sampled >= sample {
+			break
+		}
+		sampled++
+		if kv.expired(now) {
+			removed = append(removed, c.removeEntry(kv))
+			expired++
+		}
+	}
+	c.stats.Expired += uint64(expired)
+	return sampled, expired
+}
+
+// trackExpire keeps the expires index in sync with the entry's ttl.
+func (c *Cache) trackExpire(kv *entry) {
+	if kv.expireAt != 0 {
+		c.expires[kv.key] = kv
+	} else {
+		delete(c.expires, kv.key)
+	}
+}
+
+// removeEntry removes kv from the cache and the policy, the caller must
+// hold the write lock.
+func (c *Cache) removeEntry(kv *entry) *entry {
+	c.policy.Remove(kv.key)
+	return c.forget(kv)
+}
+
+// forget removes kv from the cache only, used for entries the policy
+// already dropped.
+func (c *Cache) forget(kv *entry) *entry {
+	delete(c.cache, kv.key)
+	delete(c.expires, kv.key)
+	c.index.remove(kv)
+	c.nbytes -= kv.size()
+	return kv
+}
+
+// notify 在释放锁之后执行回调，避免长时间持锁
+func (c *Cache) notify(removed *[]*entry) {
+	if c.OnEvicted == nil {
+		return
+	}
+	for _, kv := range *removed {
+		c.OnEvicted(kv.key, kv.value)
+	}
 }
 
 // Len the number of cache entries
@@ -111,21 +398,51 @@ func (c *Cache) Len() int {
 	c.mu.RLock() // 读锁
 	defer c.mu.RUnlock()
 
-	return c.ll.Len()
+	return len(c.cache)
 }
 
-func (c *Cache) Keys() ([]string, error) {
-	c.mu.RLock() // 读锁
+// Stats returns the counters and the current size of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	keys := make([]string, 0, c.ll.Len())
-	for e := c.ll.Front(); e != nil; e = e.Next() {
-		kv, ok := e.Value.(*entry)
-		if !ok {
-			return nil, fmt.Errorf("invalid value type")
+	s := c.stats
+	s.Keys, s.Bytes, s.MaxBytes = len(c.cache), c.nbytes, c.maxBytes
+	return s
+}
+
+// Bytes returns how many bytes the cache entries take.
+func (c *Cache) Bytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.nbytes
+}
+
+// Range calls fn for every live entry in eviction order, i.e. from the
+// least to the most recently used one with the LRU policy, it stops early
+// when fn returns false. expireAt is the UnixNano expiry of the entry, 0
+// means it never expires. fn must not call back into the cache.
+func (c *Cache) Range(fn func(key string, value Value, expireAt int64) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	c.policy.Walk(func(key string) bool {
+		kv := c.cache[key]
+		if kv.expired(now) {
+			return true
 		}
-		keys = append(keys, kv.key)
-	}
+		return fn(kv.key, kv.value, kv.expireAt)
+	})
+}
 
+// Keys returns the live keys in eviction order.
+func (c *Cache) Keys() ([]string, error) {
+	keys := make([]string, 0, c.Len())
+	c.Range(func(key string, _ Value, _ int64) bool {
+		keys = append(keys, key)
+		return true
+	})
 	return keys, nil
 }
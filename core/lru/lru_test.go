@@ -80,3 +80,163 @@ func TestSet(t *testing.T) {
 	wg.Wait()
 	fmt.Printf("%v", time.Since(start))
 }
+
+func TestExpire(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithTTL("key1", String("value1"), 20*time.Millisecond)
+	lru.Add("key2", String("value2"))
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("cache get before expire failed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("expired key should not be returned")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key without ttl should not expire")
+	}
+	if lru.Bytes() != int64(len("key2")+len("value2")) {
+		t.Fatalf("nbytes not reclaimed, got %d", lru.Bytes())
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	lru := New(int64(0), nil)
+	for i := 0; i < 10; i++ {
+		lru.AddWithTTL(fmt.Sprintf("key%d", i), String("value"), time.Millisecond)
+	}
+	lru.Add("keep", String("value"))
+	time.Sleep(5 * time.Millisecond)
+	_, expired := lru.RemoveExpired(100)
+	if expired != 10 || lru.Len() != 1 {
+		t.Fatalf("remove expired failed, expired %d, len %d", expired, lru.Len())
+	}
+	if lru.Bytes() != int64(len("keep")+len("value")) {
+		t.Fatalf("nbytes not reclaimed, got %d", lru.Bytes())
+	}
+}
+
+func TestStats(t *testing.T) {
+	cap := len("key1" + "value1")
+	lru := New(int64(cap*2), nil)
+	lru.Add("key1", String("value1"))
+	lru.Add("key2", String("value2"))
+	lru.Add("key3", String("value3")) // key1 被淘汰
+	lru.Get("key2")
+	lru.Get("key1")
+	lru.DeleteKey("key2")
+	lru.AddWithTTL("key4", String("value4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	lru.Get("key4")
+
+	want := Stats{Hits: 1, Misses: 2, Sets: 4, Deletes: 1, Evictions: 1, Expired: 1, Keys: 1, Bytes: int64(cap), MaxBytes: int64(cap * 2)}
+	if got := lru.Stats(); got != want {
+		t.Fatalf("expect stats %+v, got %+v", want, got)
+	}
+}
+
+func TestScan(t *testing.T) {
+	lru := New(int64(0), nil)
+	for i := 0; i < 100; i++ {
+		lru.Add(fmt.Sprintf("key%d", i), String("value"))
+	}
+	seen := make(map[string]int)
+	from, deleted := uint32(0), 0
+	for {
+		keys, next, done := lru.Scan(from, 7, nil)
+		if len(keys) > 7 {
+			t.Fatalf("expect at most 7 keys in a page, got %d", len(keys))
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		// 扫描期间的写入不影响其余 key
+		lru.Add(fmt.Sprintf("new%d", from), String("value"))
+		if deleted < 10 {
+			lru.DeleteKey(fmt.Sprintf("key%d", 90+deleted))
+			deleted++
+		}
+		if done {
+			break
+		}
+		from = next
+	}
+	for i := 0; i < 90; i++ {
+		if n := seen[fmt.Sprintf("key%d", i)]; n != 1 {
+			t.Fatalf("expect key%d once, got %d", i, n)
+		}
+	}
+
+	// costarring 与 liquid 的散列值相同，总在同一页
+	lru = New(int64(0), nil)
+	for _, key := range []string{"costarring", "liquid", "declinate", "macallums", "other"} {
+		lru.Add(key, String("value"))
+	}
+	pages := 0
+	from = 0
+	for {
+		keys, next, done := lru.Scan(from, 1, func(key string) bool { return key != "other" })
+		if len(keys) == 0 {
+			// 只检查了不匹配的 key 的页为空
+			if done {
+				break
+			}
+			from = next
+			continue
+		}
+		if len(keys) != 2 || scanHash(keys[0]) != scanHash(keys[1]) {
+			t.Fatalf("expect colliding keys in one page, got %v", keys)
+		}
+		pages++
+		if done {
+			break
+		}
+		from = next
+	}
+	if pages != 2 {
+		t.Fatalf("expect 2 pages, got %d", pages)
+	}
+}
+
+func TestScanCost(t *testing.T) {
+	lru := New(int64(0), nil)
+	for i := 0; i < 100000; i++ {
+		lru.Add(fmt.Sprintf("key%d", i), String("value"))
+	}
+	// 删除大部分 key 后桶的数量减半，剩下的 key 仍然恰好返回一次
+	for i := 1000; i < 100000; i++ {
+		lru.DeleteKey(fmt.Sprintf("key%d", i))
+	}
+	seen := make(map[string]int)
+	from, pages := uint32(0), 0
+	for {
+		// 每页只检查游标之后大约 count 个 key，而不是整个分片
+		examined := 0
+		keys, next, done := lru.Scan(from, 10, func(key string) bool {
+			examined++
+			return key[len(key)-1] == '7'
+		})
+		if examined > 10+scanBucketLen*4 {
+			t.Fatalf("expect about 10 keys examined for a page, got %d", examined)
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		pages++
+		if done {
+			break
+		}
+		from = next
+	}
+	if len(seen) != 100 {
+		t.Fatalf("expect 100 keys ending with 7, got %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Fatalf("expect %s once, got %d", key, n)
+		}
+	}
+	if pages < 90 {
+		t.Fatalf("expect about 100 pages of 10 keys, got %d", pages)
+	}
+}
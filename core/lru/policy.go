@@ -0,0 +1,96 @@
+package lru
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// 可选的淘汰策略名称
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyARC     = "arc"
+	PolicyTinyLFU = "wtinylfu"
+	DefaultPolicy = PolicyLRU
+)
+
+// Policy decides which entry a Cache evicts once it is over capacity.
+// The Cache serializes all calls, so a Policy needs no locking of its own.
+// size is the number of bytes the entry takes, key included.
+type Policy interface {
+	// Add records a key inserted into the cache.
+	Add(key string, size int64)
+	// Access records a hit or an overwrite of a cached key.
+	Access(key string, size int64)
+	// Remove forgets a key deleted or expired from the cache.
+	Remove(key string)
+	// Evict picks the next key to evict and forgets it, ok is false when
+	// the policy tracks no key.
+	Evict() (key string, ok bool)
+	// Walk calls fn for every tracked key, starting with the one that would
+	// be evicted first, until fn returns false.
+	Walk(fn func(key string) bool)
+}
+
+// NewPolicy creates the policy called name for a cache of maxBytes, an
+// empty name selects DefaultPolicy.
+func NewPolicy(name string, maxBytes int64) (Policy, error) {
+	switch name {
+	case "", PolicyLRU:
+		return NewLRUPolicy(), nil
+	case PolicyLFU:
+		return NewLFUPolicy(), nil
+	case PolicyARC:
+		return NewARCPolicy(maxBytes), nil
+	case PolicyTinyLFU:
+		return NewTinyLFUPolicy(maxBytes), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q, expect lru, lfu, arc or wtinylfu", name)
+}
+
+// lruPolicy 淘汰最久未使用的 key
+type lruPolicy struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRUPolicy evicts the least recently used key.
+func NewLRUPolicy() Policy {
+	return &lruPolicy{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Add(key string, size int64) {
+	p.items[key] = p.ll.PushFront(key)
+}
+
+func (p *lruPolicy) Access(key string, size int64) {
+	if ele, ok := p.items[key]; ok {
+		p.ll.MoveToFront(ele)
+	}
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if ele, ok := p.items[key]; ok {
+		p.ll.Remove(ele)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy) Evict() (string, bool) {
+	ele := p.ll.Back()
+	if ele == nil {
+		return "", false
+	}
+	key := ele.Value.(string)
+	p.ll.Remove(ele)
+	delete(p.items, key)
+	return key, true
+}
+
+func (p *lruPolicy) Walk(fn func(key string) bool) {
+	for ele := p.ll.Back(); ele != nil; ele = ele.Prev() {
+		if !fn(ele.Value.(string)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+var policies = []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU}
+
+func newPolicyCache(t testing.TB, name string, maxBytes int64) *Cache {
+	p, err := NewPolicy(name, maxBytes)
+	if err != nil {
+		t.Fatalf("new policy %s failed: %v", name, err)
+	}
+	return NewWithPolicy(maxBytes, p, nil)
+}
+
+func TestPolicyBookkeeping(t *testing.T) {
+	for _, name := range policies {
+		t.Run(name, func(t *testing.T) {
+			var evicted int
+			c := newPolicyCache(t, name, 1000)
+			c.OnEvicted = func(string, Value) { evicted++ }
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				key := "key" + strconv.Itoa(rnd.Intn(300))
+				switch rnd.Intn(4) {
+				case 0:
+					c.DeleteKey(key)
+				case 1:
+					c.Get(key)
+				default:
+					c.Add(key, String(strconv.Itoa(i)))
+				}
+				if c.Bytes() > 1000 {
+					t.Fatalf("cache holds %d bytes, more than its capacity", c.Bytes())
+				}
+			}
+			keys, _ := c.Keys()
+			if len(keys) != c.Len() {
+				t.Fatalf("policy tracks %d keys, cache holds %d", len(keys), c.Len())
+			}
+			var size int64
+			for _, key := range keys {
+				v, ok := c.Get(key)
+				if !ok {
+					t.Fatalf("key %s walked by the policy is not cached", key)
+				}
+				size += int64(len(key) + v.Len())
+			}
+			if size != c.Bytes() {
+				t.Fatalf("cached entries take %d bytes, cache reports %d", size, c.Bytes())
+			}
+			if evicted == 0 {
+				t.Fatalf("expect evictions once the cache is full")
+			}
+		})
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := NewPolicy("fifo", 100); err == nil {
+		t.Fatalf("expect an error for an unknown policy")
+	}
+	if _, err := NewShardingLRUWithPolicy(8, 8*1024, "fifo"); err == nil {
+		t.Fatalf("expect an error for an unknown policy")
+	}
+}
+
+func TestLFUEvictsLeastFrequent(t *testing.T) {
+	c := newPolicyCache(t, PolicyLFU, 16)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	c.Get("k1")
+	c.Get("k3")
+	// k2 只被写入过一次，最先被淘汰
+	c.Add("k4", String("v4"))
+	c.Add("k5", String("v5"))
+	if _, ok := c.Get("k2"); ok {
+		t.Fatalf("least frequently used key k2 should be evicted")
+	}
+	if _, ok := c.Get("k1"); !ok {
+		t.Fatalf("most frequently used key k1 should stay")
+	}
+}
+
+// 热点数据反复访问后被一次性扫描，扫描过的 key 不应挤掉热点数据
+func TestScanResistance(t *testing.T) {
+	hot := func(name string) int {
+		c := newPolicyCache(t, name, 10*1000)
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("hot%05d", i)
+				if _, ok := c.Get(key); !ok {
+					c.Add(key, String("v"))
+				}
+			}
+		}
+		for i := 0; i < 5000; i++ {
+			c.Add(fmt.Sprintf("scan%04d", i), String("v"))
+		}
+		kept := 0
+		for i := 0; i < 500; i++ {
+			if _, ok := c.Get(fmt.Sprintf("hot%05d", i)); ok {
+				kept++
+			}
+		}
+		return kept
+	}
+	if kept := hot(PolicyLRU); kept != 0 {
+		t.Fatalf("lru should lose the hot set to the scan, kept %d", kept)
+	}
+	for _, name := range []string{PolicyLFU, PolicyARC, PolicyTinyLFU} {
+		if kept := hot(name); kept < 400 {
+			t.Errorf("%s kept only %d of 500 hot keys after a scan", name, kept)
+		}
+	}
+}
+
+// trace 为按顺序访问的 key 序列
+type trace struct {
+	name string
+	keys []string
+}
+
+// syntheticTraces 生成几类典型的访问模式
+func syntheticTraces() []trace {
+	rnd := rand.New(rand.NewSource(42))
+	zipf := rand.NewZipf(rnd, 1.1, 1, 100000)
+	var zipfKeys, scanKeys, loopKeys []string
+	for i := 0; i < 200000; i++ {
+		zipfKeys = append(zipfKeys, "z"+strconv.FormatUint(zipf.Uint64(), 10))
+	}
+	// zipf 流量中周期性地插入一次性的扫描
+	for i, scan := 0, 0; i < 200000; i++ {
+		if i%20000 < 5000 {
+			scan++
+			scanKeys = append(scanKeys, "s"+strconv.Itoa(scan))
+			continue
+		}
+		scanKeys = append(scanKeys, "z"+strconv.FormatUint(zipf.Uint64(), 10))
+	}
+	// 循环访问略大于缓存容量的 key 集合，LRU 的最坏情况
+	for i := 0; i < 200000; i++ {
+		loopKeys = append(loopKeys, "l"+strconv.Itoa(i%1200))
+	}
+	return []trace{{"zipf", zipfKeys}, {"zipf+scan", scanKeys}, {"loop", loopKeys}}
+}
+
+// BenchmarkHitRatio replays the synthetic traces through each policy and
+// reports the hit ratio. The traces only model typical access patterns, the
+// numbers compare the policies with each other and are not measured on
+// production traffic:
+//
+//	go test ./core/lru -run ^$ -bench HitRatio
+func BenchmarkHitRatio(b *testing.B) {
+	const entryBytes = 16
+	for _, tr := range syntheticTraces() {
+		for _, name := range policies {
+			b.Run(tr.name+"/"+name, func(b *testing.B) {
+				var hits, total int
+				for n := 0; n < b.N; n++ {
+					c := newPolicyCache(b, name, 1000*entryBytes)
+					for _, key := range tr.keys {
+						total++
+						if _, ok := c.Get(key); ok {
+							hits++
+							continue
+						}
+						c.Add(key, String(make([]byte, entryBytes-len(key)%entryBytes)))
+					}
+				}
+				b.ReportMetric(100*float64(hits)/float64(total), "hit%")
+			})
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package lru
+
+import (
+	"sort"
+	"time"
+)
+
+// Scan 按 key 的 32 位散列值从小到大分页遍历缓存，散列值就是 key 在分片中的
+// 位置，不随写入、淘汰变化：扫描期间一直存在的 key 恰好返回一次，期间写入
+// 或删除的 key 可能返回也可能不返回。key 按散列值的高位放在 scanIndex 的桶中，
+// 桶的顺序就是散列值的顺序，每页只从游标所在的桶开始检查大约 count 个 key。
+
+// scanHash 为 FNV-1a，决定 key 在扫描中的顺序
+func scanHash(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+const (
+	scanMinBits   = 4  // 桶数量不少于 1<<scanMinBits
+	scanBucketLen = 4  // 平均每个桶超过这么多 key 时桶的数量翻倍
+	scanMaxEmpty  = 10 // 每页最多经过 count*scanMaxEmpty 个桶
+)
+
+// scanIndex 按散列值的高 bits 位把 entry 分到 1<<bits 个桶中，桶内无序。
+// 桶的数量随 key 的数量翻倍或减半，平均每个桶只有几个 key。
+type scanIndex struct {
+	bits    uint
+	buckets [][]*entry
+	n       int
+}
+
+func (x *scanIndex) bucket(hash uint32) int {
+	return int(uint64(hash) >> (32 - x.bits))
+}
+
+func (x *scanIndex) add(kv *entry) {
+	if x.buckets == nil {
+		x.resize(scanMinBits)
+	} else if x.n >= scanBucketLen*len(x.buckets) && x.bits < 32 {
+		x.resize(x.bits + 1)
+	}
+	b := x.bucket(kv.hash)
+	x.buckets[b] = append(x.buckets[b], kv)
+	x.n++
+}
+
+func (x *scanIndex) remove(kv *entry) {
+	b := x.bucket(kv.hash)
+	bucket := x.buckets[b]
+	for i, e := range bucket {
+		if e == kv {
+			last := len(bucket) - 1
+			bucket[i], bucket[last] = bucket[last], nil
+			x.buckets[b] = bucket[:last]
+			x.n--
+			break
+		}
+	}
+	if x.bits > scanMinBits && x.n < len(x.buckets)/2 {
+		x.resize(x.bits - 1)
+	}
+}
+
+// resize 把所有 entry 重新分到 1<<bits 个桶中
+func (x *scanIndex) resize(bits uint) {
+	old := x.buckets
+	x.bits, x.buckets = bits, make([][]*entry, 1<<bits)
+	for _, bucket := range old {
+		for _, kv := range bucket {
+			b := x.bucket(kv.hash)
+			x.buckets[b] = append(x.buckets[b], kv)
+		}
+	}
+}
+
+type scanKey struct {
+	hash uint32
+	kv   *entry
+}
+
+// Scan returns the live keys accepted by match, a nil match accepts every
+// key, found by examining about count keys from the position from. next is
+// the position of the following page and done reports that no key is left
+// after this page. Keys sharing a position are examined in the same page,
+// so a page may hold more than count keys; it may also hold fewer, or none,
+// when the examined keys expired or do not match.
+// match is called under the read lock.
+func (c *Cache) Scan(from uint32, count int, match func(key string) bool) (keys []string, next uint32, done bool) {
+	keys, next, _, done = c.scan(from, max(count, 1), match)
+	return keys, next, done
+}
+
+// scan 实现 Scan，同时返回检查过的 key 的数量
+func (c *Cache) scan(from uint32, count int, match func(key string) bool) (keys []string, next uint32, examined int, done bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	x := &c.index
+	if x.n == 0 {
+		return nil, 0, 0, true
+	}
+	now := time.Now().UnixNano()
+	var page []scanKey
+	for b, visited := x.bucket(from), 0; b < len(x.buckets); b, visited = b+1, visited+1 {
+		if examined >= count || visited >= count*scanMaxEmpty {
+			return keys, from, examined, false
+		}
+		page = page[:0]
+		for _, kv := range x.buckets[b] {
+			if kv.hash >= from {
+				page = append(page, scanKey{kv.hash, kv})
+			}
+		}
+		sort.Slice(page, func(i, j int) bool {
+			if page[i].hash != page[j].hash {
+				return page[i].hash < page[j].hash
+			}
+			return page[i].kv.key < page[j].kv.key
+		})
+		for i := 0; i < len(page); {
+			if examined >= count {
+				return keys, page[i].hash, examined, false
+			}
+			// 同一个位置的 key 放在同一页
+			for h := page[i].hash; i < len(page) && page[i].hash == h; i++ {
+				if kv := page[i].kv; !kv.expired(now) && (match == nil || match(kv.key)) {
+					keys = append(keys, kv.key)
+				}
+				examined++
+			}
+		}
+		from = uint32(uint64(b+1) << (32 - x.bits))
+	}
+	return keys, 0, examined, true
+}
+
+// Scan pages through the keys of all the shards, shard by shard, see
+// Cache.Scan. The cursor holds the shard in its high 32 bits and the
+// position in the shard in the low ones: 0 starts a scan and is returned
+// once every shard was walked. About count keys are examined per page,
+// across the shards it spans.
+func (sh *ShardingLRU) Scan(cursor uint64, count int, match func(key string) bool) (keys []string, next uint64) {
+	budget := max(count, 1)
+	shard, from := int(cursor>>32), uint32(cursor)
+	for ; shard < sh.SliceNum && budget > 0; shard, from = shard+1, 0 {
+		page, pos, examined, done := sh.ShardingMap[shard].scan(from, budget, match)
+		keys = append(keys, page...)
+		budget -= examined
+		if !done {
+			return keys, uint64(shard)<<32 | uint64(pos)
+		}
+	}
+	if shard >= sh.SliceNum {
+		return keys, 0
+	}
+	return keys, uint64(shard) << 32
+}
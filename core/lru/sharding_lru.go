@@ -2,12 +2,24 @@ package lru
 
 import (
 	"errors"
+	"sync"
+	"time"
+
 	"github.com/spaolacci/murmur3"
 )
 
+const (
+	// 后台清理过期 key 的周期以及每轮每个分片的抽样数量
+	sweepInterval = 100 * time.Millisecond
+	sweepSample   = 20
+)
+
 type ShardingLRU struct {
 	ShardingMap map[int]*Cache
 	SliceNum    int
+	Policy      string // 淘汰策略名称
+	stop        chan struct{}
+	stopOnce    sync.Once
 }
 type String1 struct {
 	str string
@@ -28,17 +40,34 @@ func (sh *ShardingLRU) GetLru(key string) *Cache {
 	return cache
 }
 func NewShardingLRU(sliceNum int, maxBytes int64) (*ShardingLRU, error) {
+	return NewShardingLRUWithPolicy(sliceNum, maxBytes, DefaultPolicy)
+}
+
+// NewShardingLRUWithPolicy creates sliceNum shards evicting with the named
+// policy, see NewPolicy.
+func NewShardingLRUWithPolicy(sliceNum int, maxBytes int64, policy string) (*ShardingLRU, error) {
 	shardingMap := make(map[int]*Cache, sliceNum)
 	if maxBytes%int64(sliceNum) != 0 {
 		return nil, errors.New("maxBytes must be multiple of sliceNum")
 	}
+	if policy == "" {
+		policy = DefaultPolicy
+	}
 	for i := 0; i < sliceNum; i++ {
-		shardingMap[i] = New(maxBytes/int64(sliceNum), nil)
+		p, err := NewPolicy(policy, maxBytes/int64(sliceNum))
+		if err != nil {
+			return nil, err
+		}
+		shardingMap[i] = NewWithPolicy(maxBytes/int64(sliceNum), p, nil)
 	}
-	return &ShardingLRU{
+	sh := &ShardingLRU{
 		ShardingMap: shardingMap, // 根据sliceNum初始化map大小
 		SliceNum:    sliceNum,
-	}, nil
+		Policy:      policy,
+		stop:        make(chan struct{}),
+	}
+	go sh.sweep()
+	return sh, nil
 }
 
 func (sh *ShardingLRU) Set(key string, value string) {
@@ -48,3 +77,127 @@ func (sh *ShardingLRU) Set(key string, value string) {
 	}
 	cache.Add(key, String1{str: value})
 }
+
+// byShard 将 keys 的下标按所在分片分组
+func (sh *ShardingLRU) byShard(keys []string) map[int][]int {
+	shards := make(map[int][]int)
+	for i, key := range keys {
+		idx := sh.hash(key)
+		shards[idx] = append(shards[idx], i)
+	}
+	return shards
+}
+
+// GetItems looks up keys taking the lock of each shard once.
+func (sh *ShardingLRU) GetItems(keys []string) ([]Item, []bool) {
+	items := make([]Item, len(keys))
+	ok := make([]bool, len(keys))
+	for idx, indices := range sh.byShard(keys) {
+		part := make([]string, len(indices))
+		for j, i := range indices {
+			part[j] = keys[i]
+		}
+		got, found := sh.ShardingMap[idx].GetItems(part)
+		for j, i := range indices {
+			items[i], ok[i] = got[j], found[j]
+		}
+	}
+	return items, ok
+}
+
+// AddItems stores values[i] under keys[i] taking the lock of each shard once.
+func (sh *ShardingLRU) AddItems(keys []string, values []Value, ttl time.Duration) []error {
+	errs := make([]error, len(keys))
+	for idx, indices := range sh.byShard(keys) {
+		partKeys := make([]string, len(indices))
+		partValues := make([]Value, len(indices))
+		for j, i := range indices {
+			partKeys[j], partValues[j] = keys[i], values[i]
+		}
+		for j, err := range sh.ShardingMap[idx].AddItems(partKeys, partValues, ttl) {
+			errs[indices[j]] = err
+		}
+	}
+	return errs
+}
+
+// DeleteKeys removes keys taking the lock of each shard once.
+func (sh *ShardingLRU) DeleteKeys(keys []string) []bool {
+	deleted := make([]bool, len(keys))
+	for idx, indices := range sh.byShard(keys) {
+		part := make([]string, len(indices))
+		for j, i := range indices {
+			part[j] = keys[i]
+		}
+		for j, ok := range sh.ShardingMap[idx].DeleteKeys(part) {
+			deleted[indices[j]] = ok
+		}
+	}
+	return deleted
+}
+
+// GetMemoryUsedSituation returns the capacity, used bytes and key count of
+// all shards.
+func (sh *ShardingLRU) GetMemoryUsedSituation() (maxBytes int64, nbytes int64, keyCount int) {
+	for i := 0; i < sh.SliceNum; i++ {
+		c := sh.ShardingMap[i]
+		maxBytes += c.maxBytes
+		nbytes += c.Bytes()
+		keyCount += c.Len()
+	}
+	return
+}
+
+// Stats sums the stats of all shards.
+func (sh *ShardingLRU) Stats() Stats {
+	var s Stats
+	for i := 0; i < sh.SliceNum; i++ {
+		s = s.Add(sh.ShardingMap[i].Stats())
+	}
+	return s
+}
+
+// ShardStats returns the stats of every shard, indexed by shard.
+func (sh *ShardingLRU) ShardStats() []Stats {
+	stats := make([]Stats, sh.SliceNum)
+	for i := range stats {
+		stats[i] = sh.ShardingMap[i].Stats()
+	}
+	return stats
+}
+
+// Purge removes the entries of all shards.
+func (sh *ShardingLRU) Purge() {
+	for i := 0; i < sh.SliceNum; i++ {
+		sh.ShardingMap[i].Purge()
+	}
+}
+
+// Close stops the background sweeper.
+func (sh *ShardingLRU) Close() {
+	sh.stopOnce.Do(func() {
+		close(sh.stop)
+	})
+}
+
+// sweep 定期对每个分片抽样清理过期 key，与 Redis 的主动过期策略类似：
+// 若一轮中过期比例超过 1/4，则立即对该分片再清理一轮。
+func (sh *ShardingLRU) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sh.stop:
+			return
+		case <-ticker.C:
+			for i := 0; i < sh.SliceNum; i++ {
+				for {
+					sampled, expired := sh.ShardingMap[i].RemoveExpired(sweepSample)
+					if sampled == 0 || expired*4 <= sampled {
+						break
+					}
+				}
+			}
+		}
+	}
+}
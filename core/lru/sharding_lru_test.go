@@ -45,3 +45,76 @@ func TestSetLRUSharding(t *testing.T) {
 	wg.Wait()
 	fmt.Printf("%v", time.Since(start))
 }
+
+func TestShardingLRUSweep(t *testing.T) {
+	sh, _ := NewShardingLRU(8, 8*1024)
+	defer sh.Close()
+	for i := 0; i < 100; i++ {
+		key := randomString(10)
+		sh.GetLru(key).AddWithTTL(key, String("value"), time.Millisecond)
+	}
+	time.Sleep(3 * sweepInterval)
+	for i, c := range sh.ShardingMap {
+		if c.Len() != 0 || c.Bytes() != 0 {
+			t.Fatalf("shard %d not swept, len %d, bytes %d", i, c.Len(), c.Bytes())
+		}
+	}
+}
+
+func TestShardingLRUBatch(t *testing.T) {
+	sh, _ := NewShardingLRU(8, 8*1024)
+	keys := make([]string, 100)
+	values := make([]Value, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		values[i] = String(fmt.Sprintf("v%d", i))
+	}
+	for i, err := range sh.AddItems(keys, values, time.Minute) {
+		if err != nil {
+			t.Fatalf("add %s failed: %v", keys[i], err)
+		}
+	}
+	items, ok := sh.GetItems(append(keys, "missing"))
+	for i := range keys {
+		if !ok[i] || items[i].Value.(String) != values[i] {
+			t.Fatalf("key %s: expect %s, got %v", keys[i], values[i], items[i].Value)
+		}
+	}
+	if ok[100] {
+		t.Fatalf("missing key should not be found")
+	}
+	deleted := sh.DeleteKeys([]string{"key1", "missing", "key2"})
+	if !deleted[0] || deleted[1] || !deleted[2] {
+		t.Fatalf("unexpected delete results %v", deleted)
+	}
+	if _, ok := sh.GetLru("key1").Get("key1"); ok {
+		t.Fatalf("key1 should be deleted")
+	}
+}
+
+func TestShardingScan(t *testing.T) {
+	sh, _ := NewShardingLRU(8, 8*1024*1024)
+	defer sh.Close()
+	for i := 0; i < 500; i++ {
+		sh.Set(fmt.Sprintf("user:%d", i), "v")
+		sh.Set(fmt.Sprintf("item:%d", i), "v")
+	}
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		keys, next := sh.Scan(cursor, 50, func(key string) bool { return key[0] == 'u' })
+		for _, key := range keys {
+			if seen[key] || key[0] != 'u' {
+				t.Fatalf("unexpected key %s", key)
+			}
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 500 {
+		t.Fatalf("expect 500 keys, got %d", len(seen))
+	}
+}
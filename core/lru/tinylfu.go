@@ -0,0 +1,226 @@
+package lru
+
+import (
+	"container/list"
+
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15 // 计数器上限，与 4 位计数器相同
+	sketchMinWidth   = 64
+	sketchMaxWidth   = 1 << 22
+)
+
+// countMinSketch 以较小的内存近似统计 key 的访问频率。每累计 10 倍宽度次访问，
+// 所有计数减半，使频率反映近期的访问情况。宽度随缓存的 key 数量增长。
+type countMinSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	w := sketchMinWidth
+	for w < width && w < sketchMaxWidth {
+		w <<= 1
+	}
+	s := &countMinSketch{mask: uint32(w - 1), resetAt: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// index 使用双重哈希为每一行计算下标
+func (s *countMinSketch) index(h uint64, row int) uint32 {
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	return (h1 + uint32(row)*h2) & s.mask
+}
+
+func (s *countMinSketch) Increment(key string) {
+	h := murmur3.Sum64([]byte(key))
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < sketchMaxCounter {
+			*c++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+// grow 将宽度加倍。新下标的低位与旧下标相同，因此用旧计数初始化新计数，估计值不变。
+func (s *countMinSketch) grow() {
+	w := len(s.rows[0])
+	if w >= sketchMaxWidth {
+		return
+	}
+	for i := range s.rows {
+		rows := make([]uint8, 2*w)
+		for j := range rows {
+			rows[j] = s.rows[i][j&(w-1)]
+		}
+		s.rows[i] = rows
+	}
+	s.mask = uint32(2*w - 1)
+	s.resetAt = 20 * w
+}
+
+func (s *countMinSketch) Estimate(key string) uint8 {
+	h := murmur3.Sum64([]byte(key))
+	est := uint8(sketchMaxCounter)
+	for i := range s.rows {
+		est = min(est, s.rows[i][s.index(h, i)])
+	}
+	return est
+}
+
+// 各区域占容量的比例：窗口 1%，主区域中受保护段占 80%
+const (
+	tinyLFUWindowPercent    = 1
+	tinyLFUProtectedPercent = 80
+)
+
+type tinyLFUList struct {
+	ll    *list.List
+	bytes int64
+}
+
+type tinyLFUEntry struct {
+	key   string
+	size  int64
+	where *tinyLFUList
+}
+
+// tinyLFUPolicy 实现 W-TinyLFU（Einziger 等）：新 key 先进入一个小的 LRU 窗口，
+// 离开窗口时与主区域（分段 LRU，试用段 + 受保护段）的淘汰候选比较 sketch 中的
+// 访问频率，频率更高者留下。窗口吸收突发访问，频率过滤则让扫描类流量无法冲掉热点数据。
+type tinyLFUPolicy struct {
+	windowMax, mainMax, protectedMax int64
+
+	window, probation, protected tinyLFUList
+	items                        map[string]*list.Element
+	sketch                       *countMinSketch
+}
+
+// NewTinyLFUPolicy returns a W-TinyLFU policy for a cache of maxBytes, it
+// admits a key into the main cache only if it is used more often than the
+// key it would replace.
+func NewTinyLFUPolicy(maxBytes int64) Policy {
+	windowMax := max(1, maxBytes*tinyLFUWindowPercent/100)
+	mainMax := maxBytes - windowMax
+	return &tinyLFUPolicy{
+		windowMax:    windowMax,
+		mainMax:      mainMax,
+		protectedMax: mainMax * tinyLFUProtectedPercent / 100,
+		window:       tinyLFUList{ll: list.New()},
+		probation:    tinyLFUList{ll: list.New()},
+		protected:    tinyLFUList{ll: list.New()},
+		items:        make(map[string]*list.Element),
+		sketch:       newCountMinSketch(sketchMinWidth),
+	}
+}
+
+func (p *tinyLFUPolicy) unlink(ele *list.Element) *tinyLFUEntry {
+	e := ele.Value.(*tinyLFUEntry)
+	e.where.ll.Remove(ele)
+	e.where.bytes -= e.size
+	delete(p.items, e.key)
+	return e
+}
+
+func (p *tinyLFUPolicy) pushFront(to *tinyLFUList, e *tinyLFUEntry) {
+	e.where = to
+	to.bytes += e.size
+	p.items[e.key] = to.ll.PushFront(e)
+}
+
+func (p *tinyLFUPolicy) Add(key string, size int64) {
+	// key 数量超过 sketch 宽度后冲突过多，需要扩容
+	if len(p.items) >= len(p.sketch.rows[0]) {
+		p.sketch.grow()
+	}
+	p.sketch.Increment(key)
+	p.pushFront(&p.window, &tinyLFUEntry{key: key, size: size})
+}
+
+func (p *tinyLFUPolicy) Access(key string, size int64) {
+	p.sketch.Increment(key)
+	ele, ok := p.items[key]
+	if !ok {
+		return
+	}
+	e := p.unlink(ele)
+	e.size = size
+	if e.where == &p.window {
+		p.pushFront(&p.window, e)
+		return
+	}
+	// 主区域中再次被访问的 key 晋升到受保护段，受保护段超出配额时降级其最久未使用的 key
+	p.pushFront(&p.protected, e)
+	for p.protected.bytes > p.protectedMax && p.protected.ll.Len() > 1 {
+		p.pushFront(&p.probation, p.unlink(p.protected.ll.Back()))
+	}
+}
+
+func (p *tinyLFUPolicy) Remove(key string) {
+	if ele, ok := p.items[key]; ok {
+		p.unlink(ele)
+	}
+}
+
+// mainVictim 返回主区域中下一个被淘汰的元素
+func (p *tinyLFUPolicy) mainVictim() *list.Element {
+	if ele := p.probation.ll.Back(); ele != nil {
+		return ele
+	}
+	return p.protected.ll.Back()
+}
+
+func (p *tinyLFUPolicy) Evict() (string, bool) {
+	// 先让超出窗口配额的 key 离开窗口，按频率决定它与主区域的候选谁被淘汰
+	for p.window.bytes > p.windowMax && p.window.ll.Len() > 0 {
+		candidate := p.window.ll.Back()
+		size := candidate.Value.(*tinyLFUEntry).size
+		victim := p.mainVictim()
+		if victim == nil || p.probation.bytes+p.protected.bytes+size <= p.mainMax {
+			p.pushFront(&p.probation, p.unlink(candidate))
+			continue
+		}
+		ck, vk := candidate.Value.(*tinyLFUEntry).key, victim.Value.(*tinyLFUEntry).key
+		if p.sketch.Estimate(ck) > p.sketch.Estimate(vk) {
+			p.unlink(victim)
+			p.pushFront(&p.probation, p.unlink(candidate))
+			return vk, true
+		}
+		p.unlink(candidate)
+		return ck, true
+	}
+	victim := p.mainVictim()
+	if victim == nil {
+		victim = p.window.ll.Back()
+	}
+	if victim == nil {
+		return "", false
+	}
+	return p.unlink(victim).key, true
+}
+
+func (p *tinyLFUPolicy) Walk(fn func(key string) bool) {
+	for _, l := range []*tinyLFUList{&p.window, &p.probation, &p.protected} {
+		for ele := l.ll.Back(); ele != nil; ele = ele.Prev() {
+			if !fn(ele.Value.(*tinyLFUEntry).key) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package huacache
+
+import "github.com/huahuoao/huacache/core/metrics"
+
+// CollectGroups writes the counters and memory usage of every group,
+// labelled by group name.
+func CollectGroups(w *metrics.Writer) {
+	statuses := GetStatuses()
+	families := []struct {
+		name, typ, help string
+		value           func(*GroupStatus) float64
+	}{
+		{"huacache_group_hits_total", metrics.Counter, "Lookups that found the key.", func(s *GroupStatus) float64 { return float64(s.Hits) }},
+		{"huacache_group_misses_total", metrics.Counter, "Lookups of missing or expired keys.", func(s *GroupStatus) float64 { return float64(s.Misses) }},
+		{"huacache_group_sets_total", metrics.Counter, "Values written.", func(s *GroupStatus) float64 { return float64(s.Sets) }},
+		{"huacache_group_deletes_total", metrics.Counter, "Keys deleted.", func(s *GroupStatus) float64 { return float64(s.Deletes) }},
+		{"huacache_group_evictions_total", metrics.Counter, "Keys evicted to make room.", func(s *GroupStatus) float64 { return float64(s.Evictions) }},
+		{"huacache_group_expired_total", metrics.Counter, "Keys removed because their ttl passed.", func(s *GroupStatus) float64 { return float64(s.Expired) }},
+		{"huacache_group_bytes_used", metrics.Gauge, "Bytes taken by keys and values.", func(s *GroupStatus) float64 { return float64(s.Used) }},
+		{"huacache_group_bytes_capacity", metrics.Gauge, "Capacity of the group in bytes.", func(s *GroupStatus) float64 { return float64(s.Size) }},
+		{"huacache_group_keys", metrics.Gauge, "Keys cached, expired ones included until they are swept.", func(s *GroupStatus) float64 { return float64(s.KeyCount) }},
+	}
+	for _, f := range families {
+		w.Family(f.name, f.typ, f.help)
+		for _, s := range statuses {
+			w.Sample(f.name, f.value(s), "group", s.Name)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package metrics writes metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 指标类型
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of a
+// latency histogram, from 50µs to 1s.
+var LatencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Writer writes metric families one sample per line.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer writing to w, Flush must be called at the end.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family writes the HELP and TYPE lines of the metric name, they precede
+// its samples.
+func (w *Writer) Family(name, typ, help string) {
+	w.w.WriteString("# HELP " + name + " " + help + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a sample of the metric name, labels are pairs of label
+// names and values.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+// Flush writes the buffered samples.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		// 计数器和字节数不用科学计数法
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Collector writes the current value of some metrics.
+type Collector func(w *Writer)
+
+// Handler serves the metrics written by collectors, in order.
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := NewWriter(rw)
+		for _, collect := range collectors {
+			collect(w)
+		}
+		w.Flush()
+	})
+}
+
+// Histogram counts durations in cumulative buckets, it is safe for
+// concurrent use.
+type Histogram struct {
+	bounds []float64       // 秒
+	counts []atomic.Uint64 // counts[i] 为不超过 bounds[i] 的观测数，最后一个为 +Inf
+	sum    atomic.Int64    // 纳秒
+}
+
+// NewHistogram creates a histogram with buckets of bounds, in seconds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe records a duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// write 写出直方图的 _bucket、_sum 和 _count 样本
+func (h *Histogram) write(w *Writer, name string, labels ...string) {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		w.Sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatFloat(le))...)
+	}
+	w.Sample(name+"_sum", time.Duration(h.sum.Load()).Seconds(), labels...)
+	w.Sample(name+"_count", float64(cumulative), labels...)
+}
+
+// HistogramVec is a set of histograms told apart by the value of a label.
+type HistogramVec struct {
+	label  string
+	bounds []float64
+	m      sync.Map // label value -> *Histogram
+}
+
+// NewHistogramVec creates histograms with buckets of bounds, in seconds,
+// one for each value of label.
+func NewHistogramVec(label string, bounds []float64) *HistogramVec {
+	return &HistogramVec{label: label, bounds: bounds}
+}
+
+// With returns the histogram of the label value, creating it on first use.
+func (v *HistogramVec) With(value string) *Histogram {
+	if h, ok := v.m.Load(value); ok {
+		return h.(*Histogram)
+	}
+	h, _ := v.m.LoadOrStore(value, NewHistogram(v.bounds))
+	return h.(*Histogram)
+}
+
+// Write writes the family name with a histogram per label value, sorted by
+// value.
+func (v *HistogramVec) Write(w *Writer, name, help string) {
+	var values []string
+	v.m.Range(func(key, _ any) bool {
+		values = append(values, key.(string))
+		return true
+	})
+	sort.Strings(values)
+	w.Family(name, "histogram", help)
+	for _, value := range values {
+		v.With(value).write(w, name, v.label, value)
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	latency := NewHistogramVec("command", []float64{0.001, 0.01})
+	latency.With("get").Observe(500 * time.Microsecond)
+	latency.With("get").Observe(5 * time.Millisecond)
+	latency.With("get").Observe(time.Second)
+	handler := Handler(
+		func(w *Writer) {
+			w.Family("test_keys", Gauge, "Keys.")
+			w.Sample("test_keys", 3, "group", `a"b`)
+		},
+		func(w *Writer) {
+			latency.Write(w, "test_duration_seconds", "Latency.")
+		},
+	)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP test_keys Keys.
+# TYPE test_keys gauge
+test_keys{group="a\"b"} 3
+# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="get",le="0.001"} 1
+test_duration_seconds_bucket{command="get",le="0.01"} 2
+test_duration_seconds_bucket{command="get",le="+Inf"} 3
+test_duration_seconds_sum{command="get"} 1.0055
+test_duration_seconds_count{command="get"} 3
+`
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("unexpected metrics:\n%s\nexpect:\n%s", got, want)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+}
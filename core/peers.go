@@ -0,0 +1,29 @@
+package huacache
+
+import "time"
+
+// PeerPicker locates the node that owns a specific key.
+type PeerPicker interface {
+	// PickPeer returns the owner of key, ok is false when this node owns it.
+	PickPeer(key string) (peer PeerClient, ok bool)
+}
+
+// PeerClient operates on the groups of a remote node.
+type PeerClient interface {
+	// GetView returns the value of key like Group.Get.
+	GetView(group string, key string) (ByteView, error)
+	Set(group string, key string, value []byte, ttl time.Duration) error
+	Delete(group string, key string) error
+	Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+	Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+}
+
+// Writer applies the writes of the HTTP API on behalf of the node, routing
+// keys to their owner itself, see HTTPPool.SetWriter.
+type Writer interface {
+	Set(group string, key string, value []byte, ttl time.Duration) error
+	Delete(group string, key string) error
+	Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+	Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error)
+	NewGroup(name string, cacheBytes int64, policy string) error
+}
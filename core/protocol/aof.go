@@ -0,0 +1,410 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// AOF 文件格式：
+//
+//	magic "HUAAOF" | version uint16
+//	{ type byte | time int64 | payload }...
+//
+// type 为 'S' 时 payload 为 uint64 长度 + 快照，重写后的文件以它开头；
+// type 为 'C' 时 payload 为一个 Bluebell 请求帧。time 为写入时的毫秒时间戳，
+// 重放时据此扣除已经流逝的 TTL。
+const (
+	aofMagic          = "HUAAOF"
+	aofVersion        = uint16(1)
+	aofRecordSnapshot = 'S'
+	aofRecordCommand  = 'C'
+	// 文件超过该大小且比上次重写后增长一倍时自动重写
+	aofRewriteMinSize = 64 * huacache.MB
+)
+
+// FsyncPolicy decides when the AOF is flushed to disk.
+type FsyncPolicy int
+
+const (
+	FsyncAlways   FsyncPolicy = iota // 每条命令写入后都 fsync
+	FsyncEverySec                    // 每秒 fsync 一次
+	FsyncNo                          // 由操作系统决定
+)
+
+// ParseFsyncPolicy parses "always", "everysec" or "no".
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q, expect always, everysec or no", s)
+}
+
+// AOF is an append-only log of the write commands.
+type AOF struct {
+	path       string
+	policy     FsyncPolicy
+	mu         sync.Mutex
+	file       *os.File
+	size       int64    // 当前文件大小
+	baseSize   int64    // 上次重写后的文件大小
+	rewriting  bool     // 是否正在重写
+	rewriteBuf [][]byte // 重写期间追加的记录，重写完成后写入新文件
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// OpenAOF replays the log at path into the groups and opens it for
+// appending. A missing log is created from the current groups.
+func OpenAOF(path string, policy FsyncPolicy) (*AOF, error) {
+	a := &AOF{
+		path:   path,
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		a.rewriting = true
+		if err := a.rewrite(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		if err := a.replay(); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		a.file = f
+		a.size = info.Size()
+		a.baseSize = a.size
+	}
+	if policy == FsyncEverySec {
+		go a.syncEverySecond()
+	}
+	return a, nil
+}
+
+// Append logs the request, it returns once the record is handed to the
+// operating system, or is on disk with FsyncAlways.
+func (a *AOF) Append(request *BluebellRequest) error {
+	logged := *request
+	logged.Flags &^= FlagForwarded
+	logged.Version, logged.ID = 0, 0
+	frame, err := logged.Encode()
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 9, 9+len(frame))
+	record[0] = aofRecordCommand
+	binary.BigEndian.PutUint64(record[1:], uint64(time.Now().UnixMilli()))
+	record = append(record, frame...)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, err := a.file.Write(record)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, record)
+	}
+	if a.policy == FsyncAlways {
+		if err := a.file.Sync(); err != nil {
+			return err
+		}
+	}
+	if !a.rewriting && a.size > aofRewriteMinSize && a.size > 2*a.baseSize {
+		a.rewriting = true
+		go a.backgroundRewrite()
+	}
+	return nil
+}
+
+// StartRewrite compacts the log in the background.
+func (a *AOF) StartRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting {
+		return errors.New("aof rewrite already in progress")
+	}
+	a.rewriting = true
+	go a.backgroundRewrite()
+	return nil
+}
+
+// Rewrite compacts the log and waits until it is done.
+func (a *AOF) Rewrite() error {
+	a.mu.Lock()
+	if a.rewriting {
+		a.mu.Unlock()
+		return errors.New("aof rewrite already in progress")
+	}
+	a.rewriting = true
+	a.mu.Unlock()
+	return a.rewrite()
+}
+
+func (a *AOF) backgroundRewrite() {
+	if err := a.rewrite(); err != nil {
+		log.Printf("failed to rewrite aof: %v", err)
+	}
+}
+
+// rewrite 用当前数据的快照替换日志，快照期间追加的命令会接在快照之后。
+// 调用前需已将 rewriting 置为 true。
+func (a *AOF) rewrite() error {
+	start := time.Now()
+	tmp := a.path + ".rewrite"
+	err := writeAOFBase(tmp)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buffered := a.rewriteBuf
+	a.rewriting = false
+	a.rewriteBuf = nil
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for _, record := range buffered {
+		if _, err := f.Write(record); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file = f
+	a.size = info.Size()
+	a.baseSize = a.size
+	log.Printf("aof rewritten to %d bytes in %v", a.size, time.Since(start))
+	return nil
+}
+
+// writeAOFBase 写入文件头和当前数据的快照
+func writeAOFBase(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 0, len(aofMagic)+2+1+8+8)
+	header = append(header, aofMagic...)
+	header = binary.BigEndian.AppendUint16(header, aofVersion)
+	header = append(header, aofRecordSnapshot)
+	header = binary.BigEndian.AppendUint64(header, uint64(time.Now().UnixMilli()))
+	lengthAt := int64(len(header))
+	header = binary.BigEndian.AppendUint64(header, 0) // 快照长度，写完后回填
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	if err := huacache.SaveSnapshot(f); err != nil {
+		return err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	length := binary.BigEndian.AppendUint64(nil, uint64(end-lengthAt-8))
+	if _, err := f.WriteAt(length, lengthAt); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// countingReader 记录已读取的字节数，用于定位最后一条完整的记录
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// replay 重放日志；文件末尾不完整的记录（例如写入时宕机）会被截断
+func (a *AOF) replay() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := &countingReader{r: bufio.NewReader(f)}
+
+	header := make([]byte, len(aofMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("invalid aof header: %w", err)
+	}
+	if !bytes.Equal(header[:len(aofMagic)], []byte(aofMagic)) {
+		return errors.New("not a huacache aof")
+	}
+	if version := binary.BigEndian.Uint16(header[len(aofMagic):]); version != aofVersion {
+		return fmt.Errorf("unsupported aof version %d", version)
+	}
+
+	start := time.Now()
+	commands := 0
+	for {
+		good := r.n
+		err := replayRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("aof %s ends with an incomplete record, truncating it to %d bytes", a.path, good)
+			if err := os.Truncate(a.path, good); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		commands++
+	}
+	log.Printf("replayed %d aof records from %s in %v", commands, a.path, time.Since(start))
+	return nil
+}
+
+func replayRecord(r *countingReader) error {
+	head := make([]byte, 9)
+	n, err := io.ReadFull(r, head)
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	written := time.UnixMilli(int64(binary.BigEndian.Uint64(head[1:])))
+
+	switch head[0] {
+	case aofRecordSnapshot:
+		var length uint64
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		snapshot := io.LimitReader(r, int64(length))
+		if err := huacache.LoadSnapshot(snapshot); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		_, err := io.Copy(io.Discard, snapshot)
+		return err
+	case aofRecordCommand:
+		// 记录由本节点写入，不限制长度
+		request, err := readRequest(r, 0)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if request.TTL > 0 {
+			// 扣除记录写入后流逝的时间，已过期的 key 直接删除
+			request.TTL -= time.Since(written).Milliseconds()
+			if request.TTL <= 0 {
+				request.TTL = 0
+				switch request.Command {
+				case huacache.SET_KEY:
+					request.Command = huacache.DEL_KEY
+				case huacache.MSET_KEYS:
+					// 转换为删除同样的 key，value 只需保留 key 部分
+					entries, err := DecodeBatch(request.Value, true)
+					if err != nil {
+						return err
+					}
+					request.Command = huacache.MDEL_KEYS
+					request.Value = EncodeBatch(entries, false)
+				}
+			}
+		}
+		dispatchWrite(request)
+		return nil
+	default:
+		return fmt.Errorf("invalid aof record type %q", head[0])
+	}
+}
+
+func (a *AOF) syncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			f := a.file
+			a.mu.Unlock()
+			// fsync 不持锁，避免阻塞追加
+			if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Printf("failed to fsync aof: %v", err)
+			}
+		}
+	}
+}
+
+// Close flushes the log to disk and closes it.
+func (a *AOF) Close() error {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
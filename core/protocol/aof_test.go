@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func appendAll(t *testing.T, aof *AOF, requests ...*BluebellRequest) {
+	for _, request := range requests {
+		if res := dispatchWrite(request); res.Code != "200" {
+			t.Fatalf("%s failed: %s", request.Command, res.Result)
+		}
+		if err := aof.Append(request); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+}
+
+func TestAOFReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncAlways)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	appendAll(t, aof,
+		&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: "aof"},
+		&BluebellRequest{Command: huacache.SET_KEY, Key: "k1", Value: []byte("v1"), Group: "aof"},
+		&BluebellRequest{Command: huacache.SET_KEY, Key: "k2", Value: []byte("v2"), Group: "aof"},
+		&BluebellRequest{Command: huacache.DEL_KEY, Key: "k2", Group: "aof"},
+		&BluebellRequest{Command: huacache.SET_KEY, Key: "k3", Value: []byte("v3"), Group: "aof", TTL: 60000},
+	)
+	aof.Close()
+
+	// 模拟写入一半时宕机
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{aofRecordCommand, 0, 0, 0})
+	f.Close()
+
+	huacache.DelGroup("aof")
+	aof, err = OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	group, err := huacache.GetGroup("aof")
+	if err != nil {
+		t.Fatalf("group not replayed: %v", err)
+	}
+	if v, err := group.Get("k1"); err != nil || v.String() != "v1" {
+		t.Fatalf("k1 not replayed: %v", err)
+	}
+	if _, err := group.Get("k2"); err == nil {
+		t.Fatalf("deleted k2 replayed")
+	}
+	if v, err := group.Get("k3"); err != nil || v.String() != "v3" {
+		t.Fatalf("k3 not replayed: %v", err)
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	appendAll(t, aof, &BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: "rewrite"})
+	for i := 0; i < 1000; i++ {
+		appendAll(t, aof, &BluebellRequest{Command: huacache.SET_KEY, Key: "hot", Value: []byte{byte(i)}, Group: "rewrite"})
+	}
+	before := aof.size
+	if err := aof.Rewrite(); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	if aof.size >= before {
+		t.Fatalf("rewrite did not shrink the aof: %d >= %d", aof.size, before)
+	}
+	appendAll(t, aof, &BluebellRequest{Command: huacache.SET_KEY, Key: "hot", Value: []byte("last"), Group: "rewrite"})
+	aof.Close()
+
+	huacache.DelGroup("rewrite")
+	aof, err = OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	group, err := huacache.GetGroup("rewrite")
+	if err != nil {
+		t.Fatalf("group not replayed: %v", err)
+	}
+	if v, err := group.Get("hot"); err != nil || v.String() != "last" {
+		t.Fatalf("hot not replayed: %v %v", v, err)
+	}
+}
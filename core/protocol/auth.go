@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// Credentials authenticate a node or client, User is empty for a token.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// request 构造 AUTH 请求
+func (c *Credentials) request() *BluebellRequest {
+	return &BluebellRequest{Command: huacache.AUTH, Key: c.User, Value: []byte(c.Password)}
+}
+
+// login 在新建立的连接上认证
+func (c *Credentials) login(conn net.Conn) error {
+	data, err := c.request().Encode()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	res, err := ReadResponse(conn)
+	if err != nil {
+		return err
+	}
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("auth failed: %w", err)
+	}
+	return nil
+}
+
+// SetACL makes every connection authenticate before its first command,
+// with AUTH or within HELLO, and checks the permission of the user on the
+// group of every request.
+func (s *BluebellServer) SetACL(acl *huacache.ACL) {
+	s.acl = acl
+}
+
+// SetCredentials sets the credentials the node presents to its peers and
+// to its primary when the cluster or the primary enforce an ACL.
+func (s *BluebellServer) SetCredentials(user, password string) {
+	s.credentials = &Credentials{User: user, Password: password}
+}
+
+// auth 处理 AUTH 请求，user 为连接已认证的用户
+func (s *BluebellServer) auth(request *BluebellRequest, user *huacache.User) (*BluebellResponse, *huacache.User) {
+	if s.acl == nil {
+		return errorResponse(StatusBadRequest, "authentication is not enabled"), nil
+	}
+	if user != nil {
+		return errorResponse(StatusBadRequest, "connection is already authenticated"), user
+	}
+	user, err := s.acl.Authenticate(request.Key, string(request.Value))
+	if err != nil {
+		return errorResponse(StatusAuthRequired, err.Error()), nil
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+	}, user
+}
+
+// trusted 判断连接是否来自集群中的其他节点，只有这样的连接发来的 FlagForwarded 才生效。
+// 开启认证时对端需要对所有分组的管理权限，与 sync 相同；未开启认证时所有连接都被信任
+func (s *BluebellServer) trusted(ss *session) bool {
+	return s.acl == nil || (ss.user != nil && ss.user.Can("", huacache.PermAdmin))
+}
+
+// authorize 检查连接的用户能否执行该请求
+func (s *BluebellServer) authorize(user *huacache.User, request *BluebellRequest) *BluebellResponse {
+	if s.acl == nil {
+		return nil
+	}
+	if user == nil {
+		return errorResponse(StatusAuthRequired, "authentication required")
+	}
+	group := request.Group
+	switch request.Command {
+	case huacache.SYNC, huacache.SAVE, huacache.REWRITE_AOF:
+		// 节点级命令，需要对所有分组的管理权限
+		group = ""
+	}
+	if !user.Can(group, huacache.RequiredPermission(request.Command)) {
+		target := "group " + group
+		if group == "" {
+			target = "this node"
+		}
+		return errorResponse(StatusForbidden, fmt.Sprintf("user %s may not %s on %s", user.Name, request.Command, target))
+	}
+	return nil
+}
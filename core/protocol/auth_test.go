@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func newTestACL(t *testing.T) *huacache.ACL {
+	acl := huacache.NewACL()
+	rw, _ := huacache.ParseACLRule("rw:auth-*")
+	admin, _ := huacache.ParseACLRule("rwa:*")
+	if err := acl.AddUser("app", "pw", rw); err != nil {
+		t.Fatalf("add user failed: %v", err)
+	}
+	if err := acl.AddToken("node", "t0ken", admin); err != nil {
+		t.Fatalf("add token failed: %v", err)
+	}
+	return acl
+}
+
+func TestBluebellAuth(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetACL(newTestACL(t))
+	startServer(t, s)
+	for _, name := range []string{"auth-a", "other"} {
+		huacache.DelGroup(name)
+		if _, err := huacache.NewGroup(name, 1<<20); err != nil {
+			t.Fatalf("create group failed: %v", err)
+		}
+	}
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	do := func(request *BluebellRequest) *BluebellResponse {
+		data, _ := request.Encode()
+		conn.Write(data)
+		res, err := ReadResponse(reader)
+		if err != nil {
+			t.Fatalf("read response failed: %v", err)
+		}
+		return res
+	}
+	set := &BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: []byte("v"), Group: "auth-a"}
+	if res := do(set); res.Status != StatusAuthRequired {
+		t.Fatalf("expect AUTH_REQUIRED before auth, got %s", res.Status)
+	}
+	if res := do(&BluebellRequest{Command: huacache.AUTH, Key: "app", Value: []byte("wrong")}); res.Status != StatusAuthRequired {
+		t.Fatalf("expect a wrong password to fail, got %s", res.Status)
+	}
+	if res := do(&BluebellRequest{Command: huacache.AUTH, Key: "app", Value: []byte("pw")}); res.Status != StatusOK {
+		t.Fatalf("auth failed: %s %s", res.Status, res.Result)
+	}
+	if res := do(set); res.Status != StatusOK {
+		t.Fatalf("set failed: %s %s", res.Status, res.Result)
+	}
+	if res := do(&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "other"}); res.Status != StatusForbidden {
+		t.Fatalf("expect FORBIDDEN on another group, got %s", res.Status)
+	}
+	if res := do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "1048576", Group: "auth-b"}); res.Status != StatusForbidden {
+		t.Fatalf("expect FORBIDDEN creating a group, got %s", res.Status)
+	}
+	if res := do(&BluebellRequest{Command: huacache.SAVE}); res.Status != StatusForbidden {
+		t.Fatalf("expect FORBIDDEN for a node command, got %s", res.Status)
+	}
+	if res := do(&BluebellRequest{Command: huacache.AUTH, Key: "app", Value: []byte("pw")}); res.Status != StatusBadRequest {
+		t.Fatalf("expect a second auth to be rejected, got %s", res.Status)
+	}
+
+	// 令牌在 HELLO 中认证
+	m, err := DialMuxAuth(s.Addr, &Credentials{Password: "t0ken"})
+	if err != nil {
+		t.Fatalf("dial mux failed: %v", err)
+	}
+	defer m.Close()
+	res, err := m.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "auth-a"})
+	if err != nil || res.Status != StatusOK || string(res.Result) != "v" {
+		t.Fatalf("get with token failed: %v %v", res, err)
+	}
+	if _, err := DialMuxAuth(s.Addr, &Credentials{Password: "wrong"}); err == nil {
+		t.Fatalf("expect hello with a wrong token to fail")
+	}
+
+	client := NewClient(s.Addr)
+	client.SetCredentials(&Credentials{User: "app", Password: "pw"})
+	defer client.Close()
+	if v, err := client.Get("auth-a", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("client get failed: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// 批量命令 mget、mset、mdel 的 key 编码在请求的 Value 中：
+//
+//	count uint32 | { key | value }...
+//
+// 只有 mset 带 value，TTL 对所有 key 生效。响应的 Result 按请求中 key 的顺序
+// 给出每个 key 的结果：
+//
+//	count uint32 | { status uint16 | value }...
+//
+// status 与响应的 Status 相同。mget 成功时 value 为值，失败时为错误信息。
+
+// BatchEntry is a key of a batch request, Value is only used by mset.
+type BatchEntry struct {
+	Key   string
+	Value []byte
+}
+
+// BatchResult is the outcome of one key of a batch request.
+type BatchResult struct {
+	Status Status
+	Value  []byte
+}
+
+// NewBatchRequest builds a mget, mset or mdel request of entries.
+func NewBatchRequest(command, group string, entries []BatchEntry, ttl time.Duration) *BluebellRequest {
+	return &BluebellRequest{
+		Command: command,
+		Group:   group,
+		Value:   EncodeBatch(entries, command == huacache.MSET_KEYS),
+		TTL:     ttl.Milliseconds(),
+	}
+}
+
+// EncodeBatch encodes the keys of a batch request, and their values when
+// values is true.
+func EncodeBatch(entries []BatchEntry, values bool) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		writeString(buf, e.Key)
+		if values {
+			writeBytes(buf, e.Value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// DecodeBatch decodes the keys of a batch request.
+func DecodeBatch(data []byte, values bool) ([]BatchEntry, error) {
+	r := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	// 每个 key 至少占 4 字节，防止伪造的数量导致分配过多内存
+	if int64(count)*4 > int64(r.Len()) {
+		return nil, errors.New("invalid batch size")
+	}
+	entries := make([]BatchEntry, count)
+	for i := range entries {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Key = key
+		if values {
+			if entries[i].Value, err = readBytes(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// EncodeBatchResults encodes the per key results of a batch response.
+func EncodeBatchResults(results []BatchResult) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(len(results)))
+	for _, res := range results {
+		binary.Write(buf, binary.BigEndian, uint16(res.Status))
+		writeBytes(buf, res.Value)
+	}
+	return buf.Bytes()
+}
+
+// DecodeBatchResults decodes the per key results of a batch response.
+func DecodeBatchResults(data []byte) ([]BatchResult, error) {
+	r := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if int64(count)*6 > int64(r.Len()) {
+		return nil, errors.New("invalid batch size")
+	}
+	results := make([]BatchResult, count)
+	for i := range results {
+		var status uint16
+		if err := binary.Read(r, binary.BigEndian, &status); err != nil {
+			return nil, err
+		}
+		results[i].Status = Status(status)
+		var err error
+		if results[i].Value, err = readBytes(r); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// batchResult 将单个 key 的错误转换为结果
+func batchResult(value []byte, err error) BatchResult {
+	switch status := StatusOf(err); status {
+	case StatusOK:
+		return BatchResult{Status: status, Value: value}
+	case StatusNotFound:
+		return BatchResult{Status: status}
+	default:
+		return BatchResult{Status: status, Value: []byte(err.Error())}
+	}
+}
+
+// HandleBatch executes a mget, mset or mdel request on this node.
+func HandleBatch(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	entries, err := DecodeBatch(request.Value, request.Command == huacache.MSET_KEYS)
+	if err != nil {
+		return errorResponse(StatusBadRequest, "invalid batch: "+err.Error())
+	}
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+
+	results := make([]BatchResult, len(entries))
+	switch request.Command {
+	case huacache.MGET_KEYS:
+		values, errs := group.GetMany(keys)
+		for i := range results {
+			results[i] = batchResult(values[i].B, errs[i])
+		}
+	case huacache.MSET_KEYS:
+		kvs := make([]huacache.KeyValue, len(entries))
+		for i, e := range entries {
+			kvs[i] = huacache.KeyValue{Key: e.Key, Value: huacache.ByteView{B: e.Value}}
+		}
+		for i, err := range group.SetMany(kvs, time.Duration(request.TTL)*time.Millisecond) {
+			results[i] = batchResult(nil, err)
+		}
+	case huacache.MDEL_KEYS:
+		for i, err := range group.DeleteMany(keys) {
+			results[i] = batchResult(nil, err)
+		}
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: EncodeBatchResults(results),
+	}
+}
+
+// scatter 将批量请求按 key 所属节点拆分：本地的部分直接执行，其余部分并发转发给
+// 对应节点，最后按请求中的顺序合并每个 key 的结果
+func (s *BluebellServer) scatter(request *BluebellRequest) *BluebellResponse {
+	values := request.Command == huacache.MSET_KEYS
+	entries, err := DecodeBatch(request.Value, values)
+	if err != nil {
+		return errorResponse(StatusBadRequest, "invalid batch: "+err.Error())
+	}
+
+	type part struct {
+		peer    *Client // 为 nil 表示本节点
+		entries []BatchEntry
+		index   []int
+	}
+	parts := make(map[string]*part)
+	for i, e := range entries {
+		peer, _ := s.cluster.Owner(e.Key)
+		addr := ""
+		if peer != nil {
+			addr = peer.Addr
+		}
+		p, ok := parts[addr]
+		if !ok {
+			p = &part{peer: peer}
+			parts[addr] = p
+		}
+		p.entries = append(p.entries, e)
+		p.index = append(p.index, i)
+	}
+	if _, ok := parts[""]; ok && len(parts) == 1 {
+		return s.handleLocal(request)
+	}
+
+	results := make([]BatchResult, len(entries))
+	var wg sync.WaitGroup
+	for _, p := range parts {
+		wg.Add(1)
+		go func(p *part) {
+			defer wg.Done()
+			sub := *request
+			sub.Value = EncodeBatch(p.entries, values)
+			var res *BluebellResponse
+			if p.peer == nil {
+				res = s.handleLocal(&sub)
+			} else {
+				res = s.forward(p.peer, &sub)
+			}
+			var partResults []BatchResult
+			err := res.Err()
+			if err == nil {
+				partResults, err = DecodeBatchResults(res.Result)
+			}
+			if err == nil && len(partResults) != len(p.index) {
+				err = fmt.Errorf("expect %d results, got %d", len(p.index), len(partResults))
+			}
+			for j, i := range p.index {
+				if err != nil {
+					results[i] = batchResult(nil, err)
+				} else {
+					results[i] = partResults[j]
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	return &BluebellResponse{
+		Code:   "200",
+		Result: EncodeBatchResults(results),
+	}
+}
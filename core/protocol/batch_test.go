@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBatchCommands(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	group := "batch"
+	if res, err := client.Do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: group}); err != nil || res.Code != "200" {
+		t.Fatalf("create group failed: %v %v", res, err)
+	}
+
+	var entries []BatchEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, BatchEntry{Key: "k" + strconv.Itoa(i), Value: []byte("v" + strconv.Itoa(i))})
+	}
+	results, err := client.Batch(huacache.MSET_KEYS, group, append(entries, BatchEntry{}), time.Minute)
+	if err != nil {
+		t.Fatalf("mset failed: %v", err)
+	}
+	for i, res := range results[:100] {
+		if res.Status != StatusOK {
+			t.Fatalf("mset of %s failed: %s", entries[i].Key, res.Value)
+		}
+	}
+	if results[100].Status != StatusBadRequest {
+		t.Fatalf("expect an error for an empty key, got %d", results[100].Status)
+	}
+
+	keys := []BatchEntry{{Key: "k1"}, {Key: "missing"}, {Key: "k99"}}
+	results, err = client.Batch(huacache.MGET_KEYS, group, keys, 0)
+	if err != nil {
+		t.Fatalf("mget failed: %v", err)
+	}
+	if results[0].Status != StatusOK || string(results[0].Value) != "v1" ||
+		results[1].Status != StatusNotFound ||
+		results[2].Status != StatusOK || string(results[2].Value) != "v99" {
+		t.Fatalf("unexpected mget results %v", results)
+	}
+
+	results, err = client.Batch(huacache.MDEL_KEYS, group, keys, 0)
+	if err != nil {
+		t.Fatalf("mdel failed: %v", err)
+	}
+	if results[0].Status != StatusOK || results[1].Status != StatusNotFound || results[2].Status != StatusOK {
+		t.Fatalf("unexpected mdel results %v", results)
+	}
+	if v, err := client.Get(group, "k1"); err == nil {
+		t.Fatalf("k1 should be deleted, got %s", v)
+	}
+
+	if _, err := client.Batch(huacache.MGET_KEYS, "no-such-group", keys, 0); err == nil {
+		t.Fatalf("expect an error for a missing group")
+	}
+}
+
+func TestScatterBatch(t *testing.T) {
+	// 伪造的对端节点，mget 返回 "peer:" + key
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer peer.Close()
+	received := make(chan *BluebellRequest, 1)
+	go func() {
+		conn, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		received <- request
+		entries, _ := DecodeBatch(request.Value, false)
+		results := make([]BatchResult, len(entries))
+		for i, e := range entries {
+			results[i] = BatchResult{Status: StatusOK, Value: []byte("peer:" + e.Key)}
+		}
+		res, _ := (&BluebellResponse{Code: "200", Result: EncodeBatchResults(results)}).Encode()
+		conn.Write(res)
+	}()
+
+	self := freeAddr(t)
+	cluster := NewCluster(self, self, peer.Addr().String())
+	s := NewBluebellServer("tcp", self, false)
+	s.SetCluster(cluster)
+	startServer(t, s)
+	group, err := huacache.NewGroup("scatter", 1<<20)
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+
+	var keys []BatchEntry
+	remote := 0
+	for i := 0; i < 50; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, BatchEntry{Key: key})
+		if _, ok := cluster.Owner(key); ok {
+			remote++
+			continue
+		}
+		group.AddOrUpdate(key, huacache.ByteView{B: []byte("local:" + key)})
+	}
+	if remote == 0 || remote == len(keys) {
+		t.Fatalf("expect keys on both nodes, %d of %d are remote", remote, len(keys))
+	}
+
+	client := NewClient(self)
+	defer client.Close()
+	results, err := client.Batch(huacache.MGET_KEYS, "scatter", keys, 0)
+	if err != nil {
+		t.Fatalf("mget failed: %v", err)
+	}
+	for i, res := range results {
+		key := keys[i].Key
+		want := "local:" + key
+		if _, ok := cluster.Owner(key); ok {
+			want = "peer:" + key
+		}
+		if res.Status != StatusOK || string(res.Value) != want {
+			t.Fatalf("key %s: expect %s, got %d %s", key, want, res.Status, res.Value)
+		}
+	}
+	request := <-received
+	if request.Flags&FlagForwarded == 0 {
+		t.Fatalf("sub request to the peer is not marked forwarded")
+	}
+	if entries, _ := DecodeBatch(request.Value, false); len(entries) != remote {
+		t.Fatalf("peer got %d keys, expect %d", len(entries), remote)
+	}
+}
+
+func TestAOFReplayBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncAlways)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	entries := []BatchEntry{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}, {Key: "c", Value: []byte("3")}}
+	appendAll(t, aof,
+		&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: "aof-batch"},
+		NewBatchRequest(huacache.MSET_KEYS, "aof-batch", entries, 0),
+		NewBatchRequest(huacache.MDEL_KEYS, "aof-batch", entries[1:2], 0),
+		NewBatchRequest(huacache.MSET_KEYS, "aof-batch", entries[2:], 50*time.Millisecond),
+	)
+	aof.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	huacache.DelGroup("aof-batch")
+	aof, err = OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	group, err := huacache.GetGroup("aof-batch")
+	if err != nil {
+		t.Fatalf("group not replayed: %v", err)
+	}
+	if v, err := group.Get("a"); err != nil || v.String() != "1" {
+		t.Fatalf("a not replayed: %v", err)
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, err := group.Get(key); err == nil {
+			t.Fatalf("%s should not be replayed", key)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBluebellCAS(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	huacache.DelGroup("cas")
+	huacache.NewGroup("cas", 8*huacache.MB)
+	defer huacache.DelGroup("cas")
+
+	added, err := client.Add("cas", "k", []byte("v1"), time.Minute)
+	if err != nil || added == 0 {
+		t.Fatalf("add failed: %d %v", added, err)
+	}
+	if _, err := client.Add("cas", "k", []byte("v2"), 0); StatusOf(err) != StatusExists {
+		t.Fatalf("expect EXISTS, got %v", err)
+	}
+	if _, err := client.Replace("cas", "missing", []byte("v"), 0); StatusOf(err) != StatusNotFound {
+		t.Fatalf("expect NOT_FOUND, got %v", err)
+	}
+	value, cas, err := client.GetItem("cas", "k")
+	if err != nil || string(value) != "v1" || cas != added {
+		t.Fatalf("expect v1 with cas %d, got %s %d %v", added, value, cas, err)
+	}
+	replaced, err := client.Replace("cas", "k", []byte("v2"), 0)
+	if err != nil || replaced <= cas {
+		t.Fatalf("replace failed: %d %v", replaced, err)
+	}
+	if _, err := client.CompareAndSwap("cas", "k", []byte("v3"), 0, cas); StatusOf(err) != StatusVersionMismatch {
+		t.Fatalf("expect VERSION_MISMATCH, got %v", err)
+	}
+	if _, err := client.CompareAndSwap("cas", "k", []byte("v3"), 0, replaced); err != nil {
+		t.Fatalf("cas failed: %v", err)
+	}
+
+	// 并发的读-改-写不会丢失更新
+	client.Set("cas", "n", []byte("0"), 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; {
+				value, cas, err := client.GetItem("cas", "n")
+				if err != nil {
+					t.Errorf("get failed: %v", err)
+					return
+				}
+				n, _ := strconv.Atoi(string(value))
+				if _, err := client.CompareAndSwap("cas", "n", []byte(strconv.Itoa(n+1)), 0, cas); err == nil {
+					j++
+				} else if StatusOf(err) != StatusVersionMismatch {
+					t.Errorf("cas failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := client.Get("cas", "n"); string(value) != "160" {
+		t.Fatalf("expect 160, got %s", value)
+	}
+}
+
+func TestClientExactTTLIgnored(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	huacache.DelGroup("exact")
+	group, _ := huacache.NewGroup("exact", 8*huacache.MB)
+	defer huacache.DelGroup("exact")
+
+	client.Set("exact", "k", []byte("v1"), 0)
+	data, _ := group.NewItem(huacache.ByteView{B: []byte("v2")}, 0).MarshalBinary()
+	// 客户端设置的 FlagExactTTL 被清除，put_item 仍然检查 CAS
+	res, err := client.Do(&BluebellRequest{Command: huacache.PUT_ITEM, Key: "k", Group: "exact", Value: data, Flags: FlagExactTTL})
+	if err != nil || res.Status != StatusExists {
+		t.Fatalf("expect EXISTS, got %v %v", err, res)
+	}
+	if value, _ := client.Get("exact", "k"); string(value) != "v1" {
+		t.Fatalf("expect v1, got %s", value)
+	}
+}
@@ -0,0 +1,333 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+const (
+	clientDialTimeout = 3 * time.Second
+	clientIOTimeout   = 5 * time.Second
+	clientMaxIdle     = 16
+)
+
+// Client is a Bluebell client, nodes use it to talk to their peers.
+// It is safe for concurrent use.
+type Client struct {
+	Addr        string
+	idle        chan net.Conn // 空闲连接池
+	credentials *Credentials  // 非 nil 时新建的连接先认证
+	tls         *tls.Config   // 非 nil 时使用 TLS 连接
+	maxFrame    int           // 响应帧体的最大长度
+}
+
+// NewClient creates a client of the node listening on addr.
+func NewClient(addr string) *Client {
+	return &Client{
+		Addr:     addr,
+		idle:     make(chan net.Conn, clientMaxIdle),
+		maxFrame: huacache.LIMIT_SIZE,
+	}
+}
+
+// Do sends the request and waits for its response.
+func (c *Client) Do(request *BluebellRequest) (*BluebellResponse, error) {
+	data, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(clientIOTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(data); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res, err := readResponse(conn, c.maxFrame)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.release(conn)
+	return res, nil
+}
+
+// Get returns the value of key.
+func (c *Client) Get(group string, key string) ([]byte, error) {
+	v, err := c.GetView(group, key)
+	return v.B, err
+}
+
+// GetView implements huacache.PeerClient, unlike Get it reports whether
+// the value is stale.
+func (c *Client) GetView(group string, key string) (huacache.ByteView, error) {
+	res, err := c.do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: group})
+	if err != nil {
+		return huacache.ByteView{}, err
+	}
+	return huacache.ByteView{B: res.Result, Stale: res.Flags&FlagStale != 0}, nil
+}
+
+// Set implements huacache.PeerClient.
+func (c *Client) Set(group string, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(&BluebellRequest{Command: huacache.SET_KEY, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds()})
+	return err
+}
+
+// GetItem returns the value of key and its CAS token, the version to pass
+// to CompareAndSwap.
+func (c *Client) GetItem(group string, key string) ([]byte, uint64, error) {
+	res, err := c.do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: group})
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Result, res.CAS, nil
+}
+
+// Add stores the value only if key is missing and returns its CAS token.
+func (c *Client) Add(group string, key string, value []byte, ttl time.Duration) (uint64, error) {
+	return c.store(&BluebellRequest{Command: huacache.ADD_KEY, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds()})
+}
+
+// Replace stores the value only if key exists and returns its CAS token.
+func (c *Client) Replace(group string, key string, value []byte, ttl time.Duration) (uint64, error) {
+	return c.store(&BluebellRequest{Command: huacache.REPLACE_KEY, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds()})
+}
+
+// CompareAndSwap stores the value only if the CAS token of key is still
+// cas, otherwise the error has StatusVersionMismatch. It returns the new
+// CAS token.
+func (c *Client) CompareAndSwap(group string, key string, value []byte, ttl time.Duration, cas uint64) (uint64, error) {
+	return c.store(&BluebellRequest{Command: huacache.CAS, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds(), CAS: cas})
+}
+
+// LeaseGet returns the value of key, see huacache.Group.LeaseGet. On a
+// miss the error has StatusNotFound and token is the lease to pass to
+// LeaseSet, or the error has StatusLeaseWait when another client holds it.
+func (c *Client) LeaseGet(group string, key string) (value []byte, token uint64, err error) {
+	res, err := c.Do(&BluebellRequest{Command: huacache.LEASE_GET, Key: key, Group: group, Flags: FlagForwarded})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, res.CAS, fmt.Errorf("peer %s replied %w", c.Addr, err)
+	}
+	return res.Result, 0, nil
+}
+
+// LeaseSet stores the value of key with the lease token from LeaseGet and
+// returns its CAS token, the error has StatusLeaseInvalid when the lease
+// expired or the key was deleted meanwhile.
+func (c *Client) LeaseSet(group string, key string, value []byte, ttl time.Duration, token uint64) (uint64, error) {
+	return c.store(&BluebellRequest{Command: huacache.LEASE_SET, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds(), CAS: token})
+}
+
+func (c *Client) store(request *BluebellRequest) (uint64, error) {
+	res, err := c.do(request)
+	if err != nil {
+		return 0, err
+	}
+	return res.CAS, nil
+}
+
+// Delete implements huacache.PeerClient.
+func (c *Client) Delete(group string, key string) error {
+	_, err := c.do(&BluebellRequest{Command: huacache.DEL_KEY, Key: key, Group: group})
+	return err
+}
+
+// Stats returns the stats of the node, with only the named group when
+// group is not empty.
+func (c *Client) Stats(group string) (*huacache.NodeStats, error) {
+	res, err := c.do(&BluebellRequest{Command: huacache.STATS, Key: "json", Group: group})
+	if err != nil {
+		return nil, err
+	}
+	stats := &huacache.NodeStats{}
+	if err := sonic.Unmarshal(res.Result, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Incr implements huacache.PeerClient, it adds delta to the counter under
+// key and returns its new value. A missing key starts at initial and
+// expires after ttl.
+func (c *Client) Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return c.counter(huacache.INCR, group, key, delta, initial, ttl)
+}
+
+// Decr implements huacache.PeerClient, it subtracts delta from the counter
+// under key, see Incr.
+func (c *Client) Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return c.counter(huacache.DECR, group, key, delta, initial, ttl)
+}
+
+func (c *Client) counter(command, group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	res, err := c.do(NewCounterRequest(command, group, key, delta, initial, ttl))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(res.Result), 10, 64)
+}
+
+// Scan returns a page of about count keys of group matching pattern,
+// starting at cursor, and the cursor of the next page, 0 once all the keys
+// were returned. See huacache.Group.Scan.
+func (c *Client) Scan(group string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	res, err := c.do(NewScanRequest(group, cursor, pattern, count))
+	if err != nil {
+		return nil, 0, err
+	}
+	return DecodeScanResult(res.Result)
+}
+
+// Batch sends a mget, mset or mdel request of entries and returns the
+// result of every key in order.
+func (c *Client) Batch(command, group string, entries []BatchEntry, ttl time.Duration) ([]BatchResult, error) {
+	res, err := c.Do(NewBatchRequest(command, group, entries, ttl))
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("%s replied %w", c.Addr, err)
+	}
+	return DecodeBatchResults(res.Result)
+}
+
+// SetCredentials makes the client authenticate every new connection, it
+// must be called before the client is used.
+func (c *Client) SetCredentials(credentials *Credentials) {
+	c.credentials = credentials
+}
+
+// SetTLS makes the client dial over TLS, it must be called before the
+// client is used.
+func (c *Client) SetTLS(config *tls.Config) {
+	c.tls = config
+}
+
+// SetMaxFrameSize sets the largest response frame the client accepts,
+// huacache.LIMIT_SIZE by default. It must be called before the client is
+// used.
+func (c *Client) SetMaxFrameSize(n int) {
+	c.maxFrame = n
+}
+
+// Close closes all idle connections.
+func (c *Client) Close() {
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// do 发送一个已被路由过的请求，对端不会再次转发
+func (c *Client) do(request *BluebellRequest) (*BluebellResponse, error) {
+	request.Flags |= FlagForwarded
+	res, err := c.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("peer %s replied %w", c.Addr, err)
+	}
+	return res, nil
+}
+
+func (c *Client) conn() (net.Conn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+		return c.dial()
+	}
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	conn, err := dial(c.Addr, c.tls)
+	if err != nil || c.credentials == nil {
+		return conn, err
+	}
+	conn.SetDeadline(time.Now().Add(clientIOTimeout))
+	if err := c.credentials.login(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) release(conn net.Conn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// ErrFrameTooLarge is returned for frames longer than the limit of the
+// reader, the connection must be closed as the rest of the frame is unread.
+var ErrFrameTooLarge = errors.New("frame exceeds the size limit")
+
+// ReadResponse reads one length-prefixed response frame from r, frames
+// longer than huacache.LIMIT_SIZE fail with ErrFrameTooLarge.
+func ReadResponse(r io.Reader) (*BluebellResponse, error) {
+	return readResponse(r, huacache.LIMIT_SIZE)
+}
+
+func readResponse(r io.Reader, limit int) (*BluebellResponse, error) {
+	body, err := readFrame(r, limit)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeResponse(body)
+}
+
+// ReadRequest reads one length-prefixed request frame from r, frames
+// longer than huacache.LIMIT_SIZE fail with ErrFrameTooLarge.
+func ReadRequest(r io.Reader) (*BluebellRequest, error) {
+	return readRequest(r, huacache.LIMIT_SIZE)
+}
+
+func readRequest(r io.Reader, limit int) (*BluebellRequest, error) {
+	body, err := readFrame(r, limit)
+	if err != nil {
+		return nil, err
+	}
+	return Deserialize(body)
+}
+
+// readFrame 读取一个帧体，limit 不大于 0 时不限制长度
+func readFrame(r io.Reader, limit int) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if limit > 0 && int64(length) > int64(limit) {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, length, limit)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
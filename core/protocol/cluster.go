@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"crypto/tls"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/consistenthash"
+)
+
+// Cluster routes keys to their owning node on a consistent hash ring.
+type Cluster struct {
+	self    string
+	ring    *consistenthash.Map
+	clients map[string]*Client
+}
+
+// NewCluster creates the ring of self and its peers, addresses are the
+// Bluebell addresses the nodes are reachable at.
+func NewCluster(self string, peers ...string) *Cluster {
+	return NewClusterWithVirtualNodes(huacache.CONSISTENTHASH_VIRTUAL_NODE_NUM, self, peers...)
+}
+
+// NewClusterWithVirtualNodes is like NewCluster with virtualNodes virtual
+// nodes per node on the ring, all nodes must use the same number.
+func NewClusterWithVirtualNodes(virtualNodes int, self string, peers ...string) *Cluster {
+	c := &Cluster{
+		self:    self,
+		ring:    consistenthash.NewWithReplicas(virtualNodes),
+		clients: make(map[string]*Client),
+	}
+	c.ring.Add(self)
+	for _, peer := range peers {
+		if peer == "" || peer == self {
+			continue
+		}
+		if _, ok := c.clients[peer]; ok {
+			continue
+		}
+		c.ring.Add(peer)
+		c.clients[peer] = NewClient(peer)
+	}
+	return c
+}
+
+// Owner returns the client of the node owning key, ok is false when the
+// key belongs to this node.
+func (c *Cluster) Owner(key string) (client *Client, ok bool) {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return nil, false
+	}
+	return c.clients[owner], true
+}
+
+// PickPeer implements huacache.PeerPicker.
+func (c *Cluster) PickPeer(key string) (huacache.PeerClient, bool) {
+	client, ok := c.Owner(key)
+	if !ok {
+		return nil, false
+	}
+	return client, true
+}
+
+// SetCredentials makes the clients of all peers authenticate with
+// credentials.
+func (c *Cluster) SetCredentials(credentials *Credentials) {
+	for _, client := range c.clients {
+		client.SetCredentials(credentials)
+	}
+}
+
+// SetTLS makes the clients of all peers dial over TLS with config.
+func (c *Cluster) SetTLS(config *tls.Config) {
+	for _, client := range c.clients {
+		client.SetTLS(config)
+	}
+}
+
+// SetMaxFrameSize sets the largest response frame the clients of all peers
+// accept.
+func (c *Cluster) SetMaxFrameSize(n int) {
+	for _, client := range c.clients {
+		client.SetMaxFrameSize(n)
+	}
+}
+
+// Peers returns the clients of all other nodes.
+func (c *Cluster) Peers() []*Client {
+	clients := make([]*Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// freeAddr 返回一个当前可用的本地地址
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer 在后台运行服务，测试结束时关闭
+func startServer(t *testing.T, s *BluebellServer) {
+	runEngine(t, s, s.Network, s.Addr)
+}
+
+// runEngine 在后台运行 gnet 事件处理器并等待其开始监听
+func runEngine(t *testing.T, handler gnet.EventHandler, network, addr string) {
+	go gnet.Run(handler, network+"://"+addr)
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		gnet.Stop(context.Background(), network+"://"+addr)
+	})
+}
+
+func TestClusterOwner(t *testing.T) {
+	nodes := []string{"10.0.0.1:9000", "10.0.0.2:9000", "10.0.0.3:9000"}
+	owned := make(map[string]int)
+	for _, self := range nodes {
+		cluster := NewCluster(self, nodes...)
+		for i := 0; i < 3000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if peer, ok := cluster.Owner(key); ok {
+				if peer.Addr == self {
+					t.Fatalf("node %s routes key %s to itself", self, key)
+				}
+				continue
+			}
+			owned[key+"@"+self]++
+		}
+	}
+	// 每个 key 恰好被一个节点认为属于自己
+	if len(owned) != 3000 {
+		t.Fatalf("expect 3000 owned keys, got %d", len(owned))
+	}
+}
+
+func TestForwardToOwner(t *testing.T) {
+	// 伪造的对端节点，记录收到的请求并返回固定响应
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer peer.Close()
+	received := make(chan *BluebellRequest, 1)
+	go func() {
+		conn, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		received <- request
+		res, _ := (&BluebellResponse{Code: "200", Result: []byte("from peer")}).Encode()
+		conn.Write(res)
+	}()
+
+	self := freeAddr(t)
+	cluster := NewCluster(self, self, peer.Addr().String())
+	s := NewBluebellServer("tcp", self, false)
+	s.SetCluster(cluster)
+	startServer(t, s)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := cluster.Owner("key" + strconv.Itoa(i)); ok {
+			key = "key" + strconv.Itoa(i)
+		}
+	}
+	client := NewClient(self)
+	defer client.Close()
+	res, err := client.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: "g"})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if res.Code != "200" || string(res.Result) != "from peer" {
+		t.Fatalf("unexpected response %s %s", res.Code, res.Result)
+	}
+	request := <-received
+	if request.Key != key || request.Flags&FlagForwarded == 0 {
+		t.Fatalf("peer got unexpected request %v", request)
+	}
+}
+
+func TestClientForwardedFlagIgnored(t *testing.T) {
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer peer.Close()
+	received := make(chan *BluebellRequest, 1)
+	go func() {
+		conn, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		received <- request
+		res, _ := (&BluebellResponse{Code: "200", Result: []byte("from peer")}).Encode()
+		conn.Write(res)
+	}()
+
+	self := freeAddr(t)
+	cluster := NewCluster(self, self, peer.Addr().String())
+	s := NewBluebellServer("tcp", self, false)
+	s.SetCluster(cluster)
+	s.SetACL(newTestACL(t))
+	startServer(t, s)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := cluster.Owner("key" + strconv.Itoa(i)); ok {
+			key = "key" + strconv.Itoa(i)
+		}
+	}
+	// app 没有节点的管理权限，它设置的 FlagForwarded 被忽略，请求仍转发给所属节点
+	client := NewClient(self)
+	client.SetCredentials(&Credentials{User: "app", Password: "pw"})
+	defer client.Close()
+	res, err := client.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: "auth-g", Flags: FlagForwarded})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if res.Code != "200" || string(res.Result) != "from peer" {
+		t.Fatalf("unexpected response %s %s", res.Code, res.Result)
+	}
+	if request := <-received; request.Key != key {
+		t.Fatalf("peer got unexpected request %v", request)
+	}
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// incr、decr 命令的 delta 和 initial 编码在请求的 Value 中：
+//
+//	delta int64 | initial int64
+//
+// Value 为空时 delta 为 1、initial 为 0。TTL 只在创建 key 时生效，
+// 响应的 Result 为计数器的新值（十进制）。
+
+// NewCounterRequest builds an incr or decr request of key, a missing key
+// starts at initial and expires after ttl.
+func NewCounterRequest(command, group, key string, delta, initial int64, ttl time.Duration) *BluebellRequest {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, delta)
+	binary.Write(buf, binary.BigEndian, initial)
+	return &BluebellRequest{
+		Command: command,
+		Key:     key,
+		Group:   group,
+		Value:   buf.Bytes(),
+		TTL:     ttl.Milliseconds(),
+	}
+}
+
+// HandleCounter executes an incr or decr request on this node.
+func HandleCounter(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	delta, initial := int64(1), int64(0)
+	if len(request.Value) > 0 {
+		r := bytes.NewReader(request.Value)
+		if err := binary.Read(r, binary.BigEndian, &delta); err != nil {
+			return errorResponse(StatusBadRequest, "invalid counter: "+err.Error())
+		}
+		if err := binary.Read(r, binary.BigEndian, &initial); err != nil {
+			return errorResponse(StatusBadRequest, "invalid counter: "+err.Error())
+		}
+	}
+	ttl := time.Duration(request.TTL) * time.Millisecond
+	var n int64
+	if request.Command == huacache.INCR {
+		n, err = group.Incr(request.Key, delta, initial, ttl)
+	} else {
+		n, err = group.Decr(request.Key, delta, initial, ttl)
+	}
+	if err != nil {
+		return errResponse(err)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: strconv.AppendInt(nil, n, 10),
+	}
+}
+
+// counterSet 把执行成功的 incr、decr 转换为写入结果的 set，重放 AOF 或副本
+// 重复收到命令时不会重复累加。set 带 FlagExactTTL，保留 key 剩余的寿命
+func counterSet(request *BluebellRequest, res *BluebellResponse) *BluebellRequest {
+	set := &BluebellRequest{Command: huacache.SET_KEY, Key: request.Key, Group: request.Group, Value: res.Result, Flags: FlagExactTTL}
+	if g, err := huacache.GetGroup(request.Group); err == nil {
+		if item, ok := g.Peek(request.Key); ok && !item.ExpireAt.IsZero() {
+			set.TTL = max(item.TTL().Milliseconds(), 1)
+		}
+	}
+	return set
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBluebellCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncAlways)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetAOF(aof)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	huacache.DelGroup("counter")
+	defer huacache.DelGroup("counter")
+	if res, err := client.Do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: "counter"}); err != nil || res.Code != "200" {
+		t.Fatalf("create group failed: %v %v", res, err)
+	}
+
+	if n, err := client.Incr("counter", "n", 1, 10, time.Minute); err != nil || n != 11 {
+		t.Fatalf("expect 11, got %d %v", n, err)
+	}
+	if n, err := client.Decr("counter", "n", 4, 0, 0); err != nil || n != 7 {
+		t.Fatalf("expect 7, got %d %v", n, err)
+	}
+	client.Set("counter", "text", []byte("abc"), 0)
+	if _, err := client.Incr("counter", "text", 1, 0, 0); StatusOf(err) != StatusBadRequest {
+		t.Fatalf("expect a bad request, got %v", err)
+	}
+
+	// AOF 中记录的是结果，重放不会重复累加
+	aof.Close()
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte(huacache.INCR)) || bytes.Contains(data, []byte(huacache.DECR)) {
+		t.Fatalf("expect counters to be logged as set")
+	}
+	huacache.DelGroup("counter")
+	aof, err = OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	g, err := huacache.GetGroup("counter")
+	if err != nil {
+		t.Fatalf("group not replayed: %v", err)
+	}
+	item, ok := g.Peek("n")
+	if !ok || item.Value.String() != "7" || item.ExpireAt.IsZero() {
+		t.Fatalf("expect n=7 with a ttl after replay, got %+v", item)
+	}
+}
@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/bytedance/sonic"
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// ProtocolV1 is the version of the original frame, it is negotiated in
+// HELLO but the frame itself carries no version.
+const ProtocolV1 uint8 = 1
+
+// 可协商的特性
+const (
+	FeatureRequestID   = "request_id"  // v2 帧，响应带请求 ID 并可能乱序
+	FeatureCompression = "compression" // 帧体按需使用 deflate 压缩
+	FeatureAuth        = "auth"        // 需要认证
+	FeatureBatch       = "batch"       // mget、mset、mdel
+)
+
+// Hello is the value of a HELLO request, encoded as JSON.
+type Hello struct {
+	Version  uint8    `json:"version"`
+	Features []string `json:"features"`
+	Name     string   `json:"name,omitempty"` // 客户端名称，仅用于日志
+	// 节点开启认证时可在握手中一并认证，User 为空时 Password 为令牌
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// HelloReply is the result of a HELLO request, encoded as JSON. Version is
+// the highest version both sides speak and Features the ones both support.
+type HelloReply struct {
+	Version  uint8      `json:"version"`
+	Features []string   `json:"features"`
+	Server   ServerInfo `json:"server"`
+}
+
+// ServerInfo identifies the node that answered a HELLO.
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Addr    string `json:"addr"`
+	Role    string `json:"role"` // primary 或 replica
+}
+
+// connMode 是连接经 HELLO 协商后的模式，未握手的连接保持 v1 的行为并可使用所有命令
+type connMode struct {
+	negotiated bool
+	version    uint8
+	features   []string
+	compress   bool // 帧体带编码方式，见 compressBody
+}
+
+func (m *connMode) has(feature string) bool {
+	return !m.negotiated || slices.Contains(m.features, feature)
+}
+
+// features 返回本节点支持的特性
+func (s *BluebellServer) features() []string {
+	features := []string{FeatureRequestID, FeatureCompression, FeatureBatch}
+	if s.acl != nil {
+		features = append(features, FeatureAuth)
+	}
+	return features
+}
+
+func (s *BluebellServer) serverInfo() ServerInfo {
+	role := "primary"
+	if s.primary != "" {
+		role = "replica"
+	}
+	return ServerInfo{Name: "huacache", Version: huacache.VERSION, Addr: s.Addr, Role: role}
+}
+
+// hello 处理连接上的第一个 HELLO 请求，返回协商出的连接模式和认证的用户。模式在响应
+// 写出后才生效，因此响应本身按握手前的模式编码。
+func (s *BluebellServer) hello(request *BluebellRequest) (*BluebellResponse, connMode, *huacache.User) {
+	var hello Hello
+	if err := sonic.Unmarshal(request.Value, &hello); err != nil {
+		return errorResponse(StatusBadRequest, "invalid hello: "+err.Error()), connMode{}, nil
+	}
+	if hello.Version < ProtocolV1 {
+		return errorResponse(StatusBadRequest, fmt.Sprintf("unsupported protocol version %d", hello.Version)), connMode{}, nil
+	}
+	var user *huacache.User
+	if s.acl != nil && (hello.User != "" || hello.Password != "") {
+		var err error
+		if user, err = s.acl.Authenticate(hello.User, hello.Password); err != nil {
+			return errorResponse(StatusAuthRequired, err.Error()), connMode{}, nil
+		}
+	}
+	mode := connMode{negotiated: true, version: min(hello.Version, ProtocolV2)}
+	for _, feature := range s.features() {
+		if !slices.Contains(hello.Features, feature) {
+			continue
+		}
+		if feature == FeatureRequestID && mode.version < ProtocolV2 {
+			continue
+		}
+		mode.features = append(mode.features, feature)
+	}
+	mode.compress = slices.Contains(mode.features, FeatureCompression)
+	reply := HelloReply{Version: mode.version, Features: mode.features, Server: s.serverInfo()}
+	if reply.Features == nil {
+		reply.Features = []string{}
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: SonicSerialize(reply),
+	}, mode, user
+}
+
+// allowed 检查请求是否符合连接协商的模式
+func (m *connMode) allowed(request *BluebellRequest) error {
+	if request.Version >= ProtocolV2 && !m.has(FeatureRequestID) {
+		return errors.New("request ids were not negotiated")
+	}
+	switch request.Command {
+	case huacache.MGET_KEYS, huacache.MSET_KEYS, huacache.MDEL_KEYS:
+		if !m.has(FeatureBatch) {
+			return errors.New("batch commands were not negotiated")
+		}
+	case huacache.HELLO:
+		return errors.New("hello must be the first command on a connection")
+	}
+	return nil
+}
+
+// 协商了压缩的连接上，帧体的第一个字节表示编码方式
+const (
+	encodingRaw     byte = 0
+	encodingDeflate byte = 1
+)
+
+// compressMinSize 以下的帧体压缩收益很小，原样发送
+const compressMinSize = 512
+
+// compressBody 为帧体加上编码方式，足够大且能变小时压缩
+func compressBody(body []byte) []byte {
+	if len(body) >= compressMinSize {
+		buf := new(bytes.Buffer)
+		buf.WriteByte(encodingDeflate)
+		w, _ := flate.NewWriter(buf, flate.BestSpeed)
+		w.Write(body)
+		w.Close()
+		if buf.Len() < len(body)+1 {
+			return buf.Bytes()
+		}
+	}
+	return append([]byte{encodingRaw}, body...)
+}
+
+// decompressBody 还原 compressBody 的结果，解压后超过 limit 时报错
+func decompressBody(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty frame")
+	}
+	switch data[0] {
+	case encodingRaw:
+		return data[1:], nil
+	case encodingDeflate:
+		r := flate.NewReader(bytes.NewReader(data[1:]))
+		defer r.Close()
+		body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > limit {
+			return nil, errors.New("frame too large")
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("unknown frame encoding %d", data[0])
+}
+
+// frame 为帧体加上长度头部
+func frame(body []byte) []byte {
+	data := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(data, uint32(len(body)))
+	copy(data[4:], body)
+	return data
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestCompressBody(t *testing.T) {
+	for _, body := range [][]byte{[]byte("short"), bytes.Repeat([]byte("huacache"), 1000)} {
+		data := compressBody(body)
+		if len(body) >= compressMinSize && data[0] != encodingDeflate {
+			t.Fatalf("expect a large body to be compressed")
+		}
+		got, err := decompressBody(data, huacache.LIMIT_SIZE)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Fatalf("round trip failed: %v", err)
+		}
+	}
+	if _, err := decompressBody([]byte{9, 1, 2}, huacache.LIMIT_SIZE); err == nil {
+		t.Fatalf("expect an error for an unknown encoding")
+	}
+}
+
+func TestHelloNegotiation(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	if _, err := huacache.NewGroup("hello", 1<<20); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	hello := Hello{Version: 3, Features: []string{FeatureCompression, FeatureBatch, "unknown"}}
+	data, _ := (&BluebellRequest{Command: huacache.HELLO, Value: SonicSerialize(hello)}).Encode()
+	conn.Write(data)
+	res, err := ReadResponse(reader)
+	if err != nil || res.Code != "200" {
+		t.Fatalf("hello failed: %v %v", res, err)
+	}
+	var reply HelloReply
+	if err := sonic.Unmarshal(res.Result, &reply); err != nil {
+		t.Fatalf("invalid hello reply %s: %v", res.Result, err)
+	}
+	if reply.Version != ProtocolV2 || !slices.Equal(reply.Features, []string{FeatureCompression, FeatureBatch}) ||
+		reply.Server.Name != "huacache" || reply.Server.Version != huacache.VERSION {
+		t.Fatalf("unexpected hello reply %+v", reply)
+	}
+
+	// 之后的帧都带编码方式
+	do := func(request *BluebellRequest) *BluebellResponse {
+		body, _ := request.Serialize()
+		conn.Write(frame(compressBody(body)))
+		body, err := readFrame(reader, huacache.LIMIT_SIZE)
+		if err != nil {
+			t.Fatalf("read response failed: %v", err)
+		}
+		if body, err = decompressBody(body, huacache.LIMIT_SIZE); err != nil {
+			t.Fatalf("decompress response failed: %v", err)
+		}
+		res, err := DeserializeResponse(body)
+		if err != nil {
+			t.Fatalf("decode response failed: %v", err)
+		}
+		return res
+	}
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	if res := do(&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: value, Group: "hello"}); res.Code != "200" {
+		t.Fatalf("set failed: %s %s", res.Code, res.Result)
+	}
+	if res := do(&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "hello"}); !bytes.Equal(res.Result, value) {
+		t.Fatalf("get returned %d bytes, expect %d", len(res.Result), len(value))
+	}
+	// 未协商请求 ID，v2 帧被拒绝
+	if res := do(&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "hello", Version: ProtocolV2, ID: 1}); res.Code != "400" {
+		t.Fatalf("expect v2 frames to be rejected, got %s", res.Code)
+	}
+	if res := do(&BluebellRequest{Command: huacache.HELLO, Value: SonicSerialize(hello)}); res.Code != "400" || !strings.Contains(string(res.Result), "first") {
+		t.Fatalf("expect a second hello to be rejected, got %s %s", res.Code, res.Result)
+	}
+}
+
+func TestMuxClientCompression(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	if _, err := huacache.NewGroup("mux-compress", 1<<20); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	client, err := DialMux(s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	if !slices.Contains(client.Features, FeatureCompression) || client.Server.Addr != s.Addr {
+		t.Fatalf("unexpected handshake result %v %+v", client.Features, client.Server)
+	}
+	value := bytes.Repeat([]byte("huacache"), 4096)
+	if res, err := client.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: value, Group: "mux-compress"}); err != nil || res.Code != "200" {
+		t.Fatalf("set failed: %v %v", res, err)
+	}
+	res, err := client.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "mux-compress"})
+	if err != nil || !bytes.Equal(res.Result, value) {
+		t.Fatalf("get failed: %v", err)
+	}
+}
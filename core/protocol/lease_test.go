@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBluebellLease(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	huacache.DelGroup("lease")
+	huacache.NewGroup("lease", 8*huacache.MB)
+	defer huacache.DelGroup("lease")
+
+	_, token, err := client.LeaseGet("lease", "k")
+	if StatusOf(err) != StatusNotFound || token == 0 {
+		t.Fatalf("expect a lease on a miss, got %d %v", token, err)
+	}
+	if _, _, err := client.LeaseGet("lease", "k"); StatusOf(err) != StatusLeaseWait {
+		t.Fatalf("expect LEASE_WAIT, got %v", err)
+	}
+	if _, err := client.LeaseSet("lease", "k", []byte("v"), 0, token+1); StatusOf(err) != StatusLeaseInvalid {
+		t.Fatalf("expect LEASE_INVALID, got %v", err)
+	}
+	if cas, err := client.LeaseSet("lease", "k", []byte("v"), 0, token); err != nil || cas == 0 {
+		t.Fatalf("lease set failed: %d %v", cas, err)
+	}
+	if value, token, err := client.LeaseGet("lease", "k"); err != nil || token != 0 || string(value) != "v" {
+		t.Fatalf("expect a hit, got %s %d %v", value, token, err)
+	}
+}
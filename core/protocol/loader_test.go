@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// slowGroup 创建一个回源函数阻塞到 release 被调用的分组，k 已在缓存中
+func slowGroup(t *testing.T, name string) (release func()) {
+	unblock := make(chan struct{})
+	var once sync.Once
+	huacache.DelGroup(name)
+	g, err := huacache.NewGroup(name, 8*huacache.MB, huacache.WithGetter(huacache.GetterFunc(func(key string) ([]byte, error) {
+		<-unblock
+		return []byte("loaded-" + key), nil
+	})))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	t.Cleanup(func() { huacache.DelGroup(name) })
+	g.AddOrUpdate("k", huacache.ByteView{B: []byte("v")})
+	return func() { once.Do(func() { close(unblock) }) }
+}
+
+func dialTest(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestBluebellLoaderOffLoop(t *testing.T) {
+	release := slowGroup(t, "slow-bluebell")
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	// 失败时也要放行回源，否则关闭服务时一直等待
+	t.Cleanup(release)
+
+	slow := NewClient(s.Addr)
+	defer slow.Close()
+	loaded := make(chan []byte, 1)
+	go func() {
+		v, _ := slow.Get("slow-bluebell", "miss")
+		loaded <- v
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// 回源期间事件循环仍然处理其他连接的命令
+	client := NewClient(s.Addr)
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("slow-bluebell", "k")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		release()
+		t.Fatalf("get blocked behind a load")
+	}
+	release()
+	if v := <-loaded; string(v) != "loaded-miss" {
+		t.Fatalf("expect the loaded value, got %q", v)
+	}
+}
+
+func TestMemcachedLoaderOffLoop(t *testing.T) {
+	release := slowGroup(t, "slow-memcached")
+	m := NewMemcachedServer("tcp", freeAddr(t), false, "slow-memcached")
+	slow := startMemcachedServer(t, m)
+	t.Cleanup(release)
+	// 同一连接上的响应保持顺序
+	slow.send("get miss\r\nget k\r\n")
+	time.Sleep(100 * time.Millisecond)
+
+	conn, r := dialTest(t, m.Addr)
+	client := &memcachedClient{t: t, conn: conn, r: r}
+	client.send("get k\r\n")
+	client.expect("VALUE k 0 1", "v", "END")
+	release()
+	slow.expect("VALUE miss 0 11", "loaded-miss", "END", "VALUE k 0 1", "v", "END")
+}
+
+func TestRespLoaderOffLoop(t *testing.T) {
+	release := slowGroup(t, "slow-resp")
+	s := NewRespServer("tcp", freeAddr(t), false, "slow-resp")
+	slow := startRespServer(t, s)
+	t.Cleanup(release)
+	slow.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$4\r\nmiss\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	time.Sleep(100 * time.Millisecond)
+
+	conn, r := dialTest(t, s.Addr)
+	client := &respClient{t: t, conn: conn, r: r}
+	expectReply(t, client.do("GET", "k"), "v")
+	expectReply(t, client.do("PING"), "+PONG")
+	release()
+	expectReply(t, slow.read(), "loaded-miss")
+	expectReply(t, slow.read(), "v")
+	expectReply(t, slow.do("PING"), "+PONG")
+}
@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestWriterLogsToAOF(t *testing.T) {
+	huacache.DelGroup("writer")
+	defer huacache.DelGroup("writer")
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncAlways)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetAOF(aof)
+	w := s.Writer()
+	if err := w.NewGroup("writer", 8*huacache.MB, "lfu"); err != nil {
+		t.Fatalf("new group failed: %v", err)
+	}
+	if err := w.Set("writer", "k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if n, err := w.Incr("writer", "n", 5, 10, 0); err != nil || n != 15 {
+		t.Fatalf("expect incr to return 15, got %d, %v", n, err)
+	}
+	if n, err := w.Decr("writer", "n", 1, 0, 0); err != nil || n != 14 {
+		t.Fatalf("expect decr to return 14, got %d, %v", n, err)
+	}
+	if err := w.Set("writer", "gone", []byte("v"), 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := w.Delete("writer", "gone"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	aof.Close()
+
+	// 重放 AOF 后写入都还在
+	huacache.DelGroup("writer")
+	aof, err = OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	group, err := huacache.GetGroup("writer")
+	if err != nil {
+		t.Fatalf("group not replayed: %v", err)
+	}
+	if v, err := group.Get("k"); err != nil || v.String() != "v" {
+		t.Fatalf("k not replayed: %v", err)
+	}
+	if v, err := group.Get("n"); err != nil || v.String() != "14" {
+		t.Fatalf("expect n to be 14, got %q, %v", v.String(), err)
+	}
+	if _, ok := group.Peek("gone"); ok {
+		t.Fatalf("deleted key replayed")
+	}
+}
+
+func TestWriterOnReplica(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.ReplicaOf("127.0.0.1:1")
+	err := s.Writer().Set("writer", "k", []byte("v"), 0)
+	var e *Error
+	if !errors.As(err, &e) || e.Status != StatusReadOnly || e.HTTPStatus() != http.StatusMisdirectedRequest {
+		t.Fatalf("expect a read-only error, got %v", err)
+	}
+}
@@ -0,0 +1,553 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/lru"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	memcachedMaxLine   = 2048
+	memcachedMaxKeyLen = 250
+	// exptime 不超过 30 天时为相对秒数，否则为 Unix 时间戳
+	memcachedRelativeExpire = 60 * 60 * 24 * 30
+)
+
+// MemcachedServer speaks the memcached text protocol, every command works
+// on a single group. Writes go through an ItemStore, by default straight to
+// the group.
+type MemcachedServer struct {
+	*gnet.BuiltinEventEngine
+	Network   string
+	Addr      string
+	Multicore bool
+	Group     string // 所有命令作用的分组
+	// MaxValueSize 为单个值的最大长度
+	MaxValueSize int
+	started      time.Time
+	stats        memcachedStats
+	eng          gnet.Engine
+	booted       chan struct{} // 引擎启动后关闭
+	drain        drainer
+	store        ItemStore
+}
+
+// memcachedStats 为 stats 命令统计的计数器
+type memcachedStats struct {
+	currConnections  atomic.Int64
+	totalConnections atomic.Int64
+	cmdGet           atomic.Int64
+	cmdSet           atomic.Int64
+	cmdTouch         atomic.Int64
+	cmdFlush         atomic.Int64
+	getHits          atomic.Int64
+	getMisses        atomic.Int64
+}
+
+// memcachedConn 为单个连接的状态。tail 不为 nil 之后命令都在 goroutine 中
+// 依次执行，swallow 和 pending 也只由这些 goroutine 访问
+type memcachedConn struct {
+	swallow int           // 需要丢弃的数据字节数，用于跳过被拒绝的大 value
+	tail    chan struct{} // 最近一批在 goroutine 中执行的命令，关闭表示已执行完
+	pending []byte        // 已从事件循环取出、尚不完整的命令
+}
+
+// NewMemcachedServer creates a memcached listener serving group.
+func NewMemcachedServer(network, addr string, multicore bool, group string) *MemcachedServer {
+	return &MemcachedServer{
+		Network:      network,
+		Addr:         addr,
+		Multicore:    multicore,
+		Group:        group,
+		MaxValueSize: huacache.LIMIT_SIZE,
+		booted:       make(chan struct{}),
+		store:        groupStore{},
+	}
+}
+
+// SetStore makes every write go through store, usually the Store of the
+// Bluebell server of the node. It must be called before the server starts.
+func (m *MemcachedServer) SetStore(store ItemStore) {
+	m.store = store
+}
+
+func (m *MemcachedServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
+	log.Printf("running memcached server on %s with multi-core=%t, group=%s",
+		fmt.Sprintf("%s://%s", m.Network, m.Addr), m.Multicore, m.Group)
+	m.started = time.Now()
+	m.eng = eng
+	close(m.booted)
+	return
+}
+
+func (m *MemcachedServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
+	m.stats.currConnections.Add(1)
+	m.stats.totalConnections.Add(1)
+	c.SetContext(&memcachedConn{})
+	if m.drain.draining.Load() {
+		return nil, gnet.Close
+	}
+	return
+}
+
+func (m *MemcachedServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+	m.stats.currConnections.Add(-1)
+	return
+}
+
+func (m *MemcachedServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
+	mc := c.Context().(*memcachedConn)
+	buf, _ := c.Peek(-1)
+	if len(buf) == 0 {
+		return
+	}
+	if mc.tail != nil || m.blocks() {
+		m.serveAsync(c, mc, bytes.Clone(buf))
+		c.Discard(len(buf))
+		return
+	}
+	out := new(bytes.Buffer)
+	consumed, action := m.process(mc, buf, out)
+	// Discard(0) 会清空整个缓冲区，不完整的命令需要保留
+	if consumed > 0 {
+		c.Discard(consumed)
+	}
+	m.reply(c, out)
+	return
+}
+
+// process 执行 buf 中所有完整的命令，返回消耗的字节数
+func (m *MemcachedServer) process(mc *memcachedConn, buf []byte, out *bytes.Buffer) (consumed int, action gnet.Action) {
+	for consumed < len(buf) {
+		if mc.swallow > 0 {
+			n := min(mc.swallow, len(buf)-consumed)
+			mc.swallow -= n
+			consumed += n
+			continue
+		}
+		n, act := m.execute(mc, buf[consumed:], out)
+		if n == 0 {
+			break
+		}
+		consumed += n
+		if act != gnet.None {
+			return consumed, act
+		}
+	}
+	return consumed, gnet.None
+}
+
+func (m *MemcachedServer) reply(c gnet.Conn, out *bytes.Buffer) {
+	if out.Len() > 0 {
+		if err := m.drain.write(c, out.Bytes()); err != nil {
+			log.Println("Async write error:", err)
+		}
+	}
+}
+
+// blocks 判断命令是否可能阻塞事件循环：分组未命中时会调用回源函数
+func (m *MemcachedServer) blocks() bool {
+	return loads(m.Group) || remote(m.store)
+}
+
+// serveAsync 在 goroutine 中执行 data 中的命令，等前一批执行完后才开始，
+// 保证响应的顺序。连接从此不再回到事件循环中执行命令，不完整的命令留在 pending 中。
+func (m *MemcachedServer) serveAsync(c gnet.Conn, mc *memcachedConn, data []byte) {
+	prev := mc.tail
+	done := make(chan struct{})
+	mc.tail = done
+	m.drain.pending.Add(1)
+	go func() {
+		defer m.drain.pending.Add(-1)
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		mc.pending = append(mc.pending, data...)
+		out := new(bytes.Buffer)
+		consumed, action := m.process(mc, mc.pending, out)
+		mc.pending = append(mc.pending[:0], mc.pending[consumed:]...)
+		m.reply(c, out)
+		if action == gnet.Close {
+			c.CloseWithCallback(nil)
+		}
+	}()
+}
+
+// execute 解析并执行 buf 开头的一条命令，返回消耗的字节数；命令不完整时返回 0
+func (m *MemcachedServer) execute(mc *memcachedConn, buf []byte, out *bytes.Buffer) (int, gnet.Action) {
+	end := bytes.Index(buf, []byte("\n"))
+	if end < 0 {
+		if len(buf) > memcachedMaxLine {
+			out.WriteString("CLIENT_ERROR line too long\r\n")
+			return len(buf), gnet.Close
+		}
+		return 0, gnet.None
+	}
+	line := strings.TrimRight(string(buf[:end]), "\r")
+	consumed := end + 1
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		out.WriteString("ERROR\r\n")
+		return consumed, gnet.None
+	}
+
+	group, err := huacache.GetGroup(m.Group)
+	if err != nil {
+		out.WriteString("SERVER_ERROR group not found\r\n")
+		return m.skipData(mc, fields, consumed, buf), gnet.None
+	}
+
+	switch cmd := fields[0]; cmd {
+	case "get", "gets":
+		m.handleGet(group, fields[1:], cmd == "gets", out)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return m.handleStore(mc, group, fields, buf, consumed, out)
+	case "delete":
+		m.handleDelete(fields, out)
+	case "incr", "decr":
+		m.handleIncr(fields, out)
+	case "touch":
+		m.handleTouch(group, fields, out)
+	case "flush_all":
+		m.handleFlush(fields, out)
+	case "stats":
+		m.handleStats(group, out)
+	case "version":
+		fmt.Fprintf(out, "VERSION %s\r\n", huacache.VERSION)
+	case "verbosity":
+		memcachedReply(out, fields, "OK")
+	case "quit":
+		return consumed, gnet.Close
+	default:
+		out.WriteString("ERROR\r\n")
+	}
+	return consumed, gnet.None
+}
+
+// skipData 对存储命令跳过其数据块
+func (m *MemcachedServer) skipData(mc *memcachedConn, fields []string, consumed int, buf []byte) int {
+	switch fields[0] {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		if len(fields) >= 5 {
+			if size, err := strconv.Atoi(fields[4]); err == nil && size >= 0 {
+				mc.swallow = size + 2
+			}
+		}
+	}
+	return consumed
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > memcachedMaxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcachedReply 在客户端没有要求 noreply 时写出响应
+func memcachedReply(out *bytes.Buffer, fields []string, msg string) {
+	if fields[len(fields)-1] == "noreply" {
+		return
+	}
+	out.WriteString(msg)
+	out.WriteString("\r\n")
+}
+
+// memcachedTTL 将 memcached 的 exptime 转换为 TTL，负数或已过去的时间表示立即过期
+func memcachedTTL(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return time.Nanosecond
+	case exptime <= memcachedRelativeExpire:
+		return time.Duration(exptime) * time.Second
+	default:
+		if ttl := time.Until(time.Unix(exptime, 0)); ttl > 0 {
+			return ttl
+		}
+		return time.Nanosecond
+	}
+}
+
+func (m *MemcachedServer) handleGet(group *huacache.Group, keys []string, cas bool, out *bytes.Buffer) {
+	if len(keys) == 0 {
+		out.WriteString("ERROR\r\n")
+		return
+	}
+	for _, key := range keys {
+		m.stats.cmdGet.Add(1)
+		if !validKey(key) {
+			out.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+		item, err := group.GetItem(key)
+		if err != nil {
+			m.stats.getMisses.Add(1)
+			continue
+		}
+		m.stats.getHits.Add(1)
+		if cas {
+			fmt.Fprintf(out, "VALUE %s %d %d %d\r\n", key, item.Value.Flags, item.Value.Len(), item.Version)
+		} else {
+			fmt.Fprintf(out, "VALUE %s %d %d\r\n", key, item.Value.Flags, item.Value.Len())
+		}
+		out.Write(item.Value.B)
+		out.WriteString("\r\n")
+	}
+	out.WriteString("END\r\n")
+}
+
+// handleStore 处理 set/add/replace/append/prepend/cas：
+// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]\r\n<data>\r\n
+func (m *MemcachedServer) handleStore(mc *memcachedConn, group *huacache.Group, fields []string, buf []byte, consumed int, out *bytes.Buffer) (int, gnet.Action) {
+	cmd := fields[0]
+	args := 5
+	if cmd == "cas" {
+		args = 6
+	}
+	if len(fields) < args || len(fields) > args+1 {
+		out.WriteString("ERROR\r\n")
+		return consumed, gnet.None
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 {
+		out.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return consumed, gnet.Close
+	}
+	flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(fields[3], 10, 64)
+	var version uint64
+	var err3 error
+	if cmd == "cas" {
+		version, err3 = strconv.ParseUint(fields[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || !validKey(fields[1]) {
+		out.WriteString("CLIENT_ERROR bad command line format\r\n")
+		mc.swallow = size + 2
+		return consumed, gnet.None
+	}
+	if size > m.MaxValueSize {
+		out.WriteString("SERVER_ERROR object too large for cache\r\n")
+		mc.swallow = size + 2
+		return consumed, gnet.None
+	}
+	if len(buf) < consumed+size+2 {
+		return 0, gnet.None
+	}
+	data := buf[consumed : consumed+size]
+	if !bytes.Equal(buf[consumed+size:consumed+size+2], []byte("\r\n")) {
+		out.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return consumed + size + 2, gnet.Close
+	}
+	consumed += size + 2
+
+	m.stats.cmdSet.Add(1)
+	key := fields[1]
+	// 读缓冲区会被复用，需要拷贝
+	value := huacache.ByteView{B: bytes.Clone(data), Flags: uint32(flags)}
+	if value.B == nil {
+		value.B = []byte{}
+	}
+	ttl := memcachedTTL(exptime)
+	_, err = m.store.Update(m.Group, key, func(old huacache.Item, ok bool) (huacache.Item, error) {
+		switch {
+		case cmd == "add" && ok:
+			return huacache.Item{}, huacache.ErrKeyExists
+		case cmd != "set" && cmd != "add" && !ok:
+			return huacache.Item{}, huacache.ErrKeyNotFound
+		case cmd == "cas" && old.Version != version:
+			return huacache.Item{}, huacache.ErrVersionMismatch
+		}
+		// append 和 prepend 保留原值的 flags 和 ttl
+		switch cmd {
+		case "append":
+			old.Value = huacache.ByteView{B: append(bytes.Clone(old.Value.B), value.B...), Flags: old.Value.Flags}
+			return old, nil
+		case "prepend":
+			old.Value = huacache.ByteView{B: append(bytes.Clone(value.B), old.Value.B...), Flags: old.Value.Flags}
+			return old, nil
+		}
+		return group.NewItem(value, ttl), nil
+	})
+
+	switch {
+	case err == nil:
+		memcachedReply(out, fields, "STORED")
+	case errors.Is(err, huacache.ErrKeyExists):
+		memcachedReply(out, fields, "NOT_STORED")
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		if cmd == "cas" {
+			memcachedReply(out, fields, "NOT_FOUND")
+		} else {
+			memcachedReply(out, fields, "NOT_STORED")
+		}
+	case errors.Is(err, huacache.ErrVersionMismatch):
+		memcachedReply(out, fields, "EXISTS")
+	case errors.Is(err, lru.ErrTooLarge), errors.Is(err, huacache.ErrValueTooLarge):
+		memcachedReply(out, fields, "SERVER_ERROR object too large for cache")
+	default:
+		memcachedReply(out, fields, "SERVER_ERROR "+err.Error())
+	}
+	return consumed, gnet.None
+}
+
+// handleDelete 处理 delete <key> [0] [noreply]
+func (m *MemcachedServer) handleDelete(fields []string, out *bytes.Buffer) {
+	if len(fields) < 2 || len(fields) > 4 || !validKey(fields[1]) {
+		out.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	err := m.store.Delete(m.Group, fields[1])
+	switch {
+	case err == nil:
+		memcachedReply(out, fields, "DELETED")
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		memcachedReply(out, fields, "NOT_FOUND")
+	default:
+		memcachedReply(out, fields, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// handleIncr 处理 incr/decr <key> <delta> [noreply]；incr 在 64 位上回绕，decr 最小为 0
+func (m *MemcachedServer) handleIncr(fields []string, out *bytes.Buffer) {
+	if len(fields) < 3 || len(fields) > 4 || !validKey(fields[1]) {
+		out.WriteString("ERROR\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		out.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	errNonNumeric := errors.New("non-numeric value")
+	item, err := m.store.Update(m.Group, fields[1], func(old huacache.Item, ok bool) (huacache.Item, error) {
+		if !ok {
+			return huacache.Item{}, huacache.ErrKeyNotFound
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(old.Value.String()), 10, 64)
+		if err != nil {
+			return huacache.Item{}, errNonNumeric
+		}
+		if fields[0] == "incr" {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		old.Value = huacache.ByteView{B: strconv.AppendUint(nil, n, 10), Flags: old.Value.Flags}
+		return old, nil
+	})
+	switch {
+	case err == nil:
+		memcachedReply(out, fields, item.Value.String())
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		memcachedReply(out, fields, "NOT_FOUND")
+	case errors.Is(err, errNonNumeric):
+		memcachedReply(out, fields, "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	default:
+		memcachedReply(out, fields, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// handleTouch 处理 touch <key> <exptime> [noreply]
+func (m *MemcachedServer) handleTouch(group *huacache.Group, fields []string, out *bytes.Buffer) {
+	if len(fields) < 3 || len(fields) > 4 || !validKey(fields[1]) {
+		out.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		out.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	m.stats.cmdTouch.Add(1)
+	ttl := memcachedTTL(exptime)
+	_, err = m.store.Update(m.Group, fields[1], func(old huacache.Item, ok bool) (huacache.Item, error) {
+		if !ok {
+			return huacache.Item{}, huacache.ErrKeyNotFound
+		}
+		if ttl == 0 {
+			return huacache.Item{Value: old.Value}, nil
+		}
+		return group.NewItem(old.Value, ttl), nil
+	})
+	switch {
+	case err == nil:
+		memcachedReply(out, fields, "TOUCHED")
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		memcachedReply(out, fields, "NOT_FOUND")
+	default:
+		memcachedReply(out, fields, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// handleFlush 处理 flush_all [delay] [noreply]
+func (m *MemcachedServer) handleFlush(fields []string, out *bytes.Buffer) {
+	m.stats.cmdFlush.Add(1)
+	var delay int64
+	if len(fields) > 1 && fields[1] != "noreply" {
+		var err error
+		delay, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || delay < 0 {
+			out.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			if err := m.store.Flush(m.Group); err != nil {
+				log.Printf("delayed flush_all of group %s failed: %v", m.Group, err)
+			}
+		})
+		memcachedReply(out, fields, "OK")
+		return
+	}
+	if err := m.store.Flush(m.Group); err != nil {
+		memcachedReply(out, fields, "SERVER_ERROR "+err.Error())
+		return
+	}
+	memcachedReply(out, fields, "OK")
+}
+
+func (m *MemcachedServer) handleStats(group *huacache.Group, out *bytes.Buffer) {
+	maxBytes, nbytes, items := group.MemoryUsage()
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(out, "STAT %s %v\r\n", name, value)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(m.started).Seconds()))
+	stat("time", now.Unix())
+	stat("version", huacache.VERSION)
+	stat("pointer_size", 64)
+	stat("curr_connections", m.stats.currConnections.Load())
+	stat("total_connections", m.stats.totalConnections.Load())
+	stat("cmd_get", m.stats.cmdGet.Load())
+	stat("cmd_set", m.stats.cmdSet.Load())
+	stat("cmd_flush", m.stats.cmdFlush.Load())
+	stat("cmd_touch", m.stats.cmdTouch.Load())
+	stat("get_hits", m.stats.getHits.Load())
+	stat("get_misses", m.stats.getMisses.Load())
+	stat("curr_items", items)
+	stat("bytes", nbytes)
+	stat("limit_maxbytes", maxBytes)
+	out.WriteString("END\r\n")
+}
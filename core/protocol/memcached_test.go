@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// memcachedClient 发送原始命令并逐行读取响应
+type memcachedClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *memcachedClient) send(s string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(s)); err != nil {
+		c.t.Fatalf("write failed: %v", err)
+	}
+}
+
+func (c *memcachedClient) expect(lines ...string) {
+	c.t.Helper()
+	for _, want := range lines {
+		c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read failed, expect %q: %v", want, err)
+		}
+		if got = strings.TrimSuffix(got, "\r\n"); got != want {
+			c.t.Fatalf("expect %q, got %q", want, got)
+		}
+	}
+}
+
+func startMemcached(t *testing.T, group string) *memcachedClient {
+	if _, err := huacache.GetGroup(group); err != nil {
+		if _, err := huacache.NewGroup(group, 8*huacache.MB); err != nil {
+			t.Fatalf("create group failed: %v", err)
+		}
+		t.Cleanup(func() { huacache.DelGroup(group) })
+	}
+	return startMemcachedServer(t, NewMemcachedServer("tcp", freeAddr(t), false, group))
+}
+
+// startMemcachedServer 启动 m 并返回连接到它的客户端
+func startMemcachedServer(t *testing.T, m *MemcachedServer) *memcachedClient {
+	runEngine(t, m, m.Network, m.Addr)
+	conn, err := net.Dial("tcp", m.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &memcachedClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func TestMemcachedStorage(t *testing.T) {
+	c := startMemcached(t, "memcached_storage")
+
+	c.send("set foo 42 0 3\r\nbar\r\n")
+	c.expect("STORED")
+	c.send("get foo missing\r\n")
+	c.expect("VALUE foo 42 3", "bar", "END")
+
+	c.send("add foo 0 0 1\r\nx\r\n")
+	c.expect("NOT_STORED")
+	c.send("replace missing 0 0 1\r\nx\r\n")
+	c.expect("NOT_STORED")
+	c.send("append foo 0 0 2\r\n!!\r\n")
+	c.expect("STORED")
+	c.send("prepend foo 0 0 1\r\n<\r\n")
+	c.expect("STORED")
+	c.send("get foo\r\n")
+	c.expect("VALUE foo 42 6", "<bar!!", "END")
+
+	c.send("delete foo\r\n")
+	c.expect("DELETED")
+	c.send("delete foo\r\n")
+	c.expect("NOT_FOUND")
+
+	// noreply 的命令没有响应，下一条命令的响应紧随其后
+	c.send("set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n")
+	c.expect("VALUE quiet 0 1", "q", "END")
+}
+
+func TestMemcachedCAS(t *testing.T) {
+	c := startMemcached(t, "memcached_cas")
+
+	c.send("set k 0 0 1\r\na\r\n")
+	c.expect("STORED")
+	group, _ := huacache.GetGroup("memcached_cas")
+	item, err := group.GetItem("k")
+	if err != nil {
+		t.Fatalf("get item failed: %v", err)
+	}
+	version := item.Version
+
+	c.send("gets k\r\n")
+	c.expect("VALUE k 0 1 "+itoa(version), "a", "END")
+	c.send("cas k 0 0 1 " + itoa(version) + "\r\nb\r\n")
+	c.expect("STORED")
+	c.send("cas k 0 0 1 " + itoa(version) + "\r\nc\r\n")
+	c.expect("EXISTS")
+	c.send("cas nope 0 0 1 1\r\nc\r\n")
+	c.expect("NOT_FOUND")
+}
+
+func TestMemcachedIncrTouchFlush(t *testing.T) {
+	c := startMemcached(t, "memcached_incr")
+
+	c.send("set n 5 0 2\r\n10\r\n")
+	c.expect("STORED")
+	c.send("incr n 5\r\n")
+	c.expect("15")
+	c.send("decr n 100\r\n")
+	c.expect("0")
+	c.send("incr n 18446744073709551615\r\nincr n 1\r\n")
+	c.expect("18446744073709551615", "0")
+	c.send("get n\r\n")
+	c.expect("VALUE n 5 1", "0", "END")
+	c.send("incr missing 1\r\n")
+	c.expect("NOT_FOUND")
+	c.send("set s 0 0 3\r\nabc\r\nincr s 1\r\n")
+	c.expect("STORED", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+
+	c.send("touch n -1\r\n")
+	c.expect("TOUCHED")
+	c.send("get n\r\n")
+	c.expect("END")
+
+	c.send("flush_all\r\nget s\r\n")
+	c.expect("OK", "END")
+}
+
+func TestMemcachedErrors(t *testing.T) {
+	c := startMemcached(t, "memcached_errors")
+
+	c.send("bogus\r\n")
+	c.expect("ERROR")
+	c.send("set k abc 0 1\r\nx\r\n")
+	c.expect("CLIENT_ERROR bad command line format")
+	// 命令被拆成多个 TCP 包发送
+	c.send("set split 0 0 5\r\nhel")
+	time.Sleep(20 * time.Millisecond)
+	c.send("lo\r\n")
+	c.expect("STORED")
+	c.send("version\r\n")
+	c.expect("VERSION " + huacache.VERSION)
+	c.send("quit\r\n")
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("expect connection to be closed after quit")
+	}
+}
+
+func itoa(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func TestMemcachedWritesThroughBluebell(t *testing.T) {
+	huacache.DelGroup("memcached_bluebell")
+	huacache.NewGroup("memcached_bluebell", 8*huacache.MB)
+	defer huacache.DelGroup("memcached_bluebell")
+	group, _ := huacache.GetGroup("memcached_bluebell")
+
+	primary := NewBluebellServer("tcp", freeAddr(t), false)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := OpenAOF(path, FsyncNo)
+	if err != nil {
+		t.Fatalf("open aof failed: %v", err)
+	}
+	primary.SetAOF(aof)
+	startServer(t, primary)
+	// 副本把写入发给主节点
+	replica := NewBluebellServer("tcp", freeAddr(t), false)
+	replica.ReplicaOf(primary.Addr)
+
+	for _, store := range []*BluebellServer{primary, replica} {
+		m := NewMemcachedServer("tcp", freeAddr(t), false, "memcached_bluebell")
+		m.SetStore(store.Store())
+		c := startMemcachedServer(t, m)
+		c.send("flush_all\r\n")
+		c.expect("OK")
+		c.send("set k 7 0 1\r\n1\r\n")
+		c.expect("STORED")
+		c.send("append k 0 0 1\r\n0\r\n")
+		c.expect("STORED")
+		c.send("incr k 5\r\n")
+		c.expect("15")
+		c.send("touch k 100\r\n")
+		c.expect("TOUCHED")
+		c.send("cas k 0 0 1 12345\r\nx\r\n")
+		c.expect("EXISTS")
+		c.send("set gone 0 0 1\r\nx\r\n")
+		c.expect("STORED")
+		c.send("delete gone\r\n")
+		c.expect("DELETED")
+		c.send("delete gone\r\n")
+		c.expect("NOT_FOUND")
+	}
+
+	// 所有写入都记录在主节点的 AOF 中
+	aof.Close()
+	group.Flush()
+	if aof, err = OpenAOF(path, FsyncNo); err != nil {
+		t.Fatalf("replay aof failed: %v", err)
+	}
+	defer aof.Close()
+	// AOF 开头的快照会重建分组
+	group, _ = huacache.GetGroup("memcached_bluebell")
+	item, ok := group.Peek("k")
+	if !ok || item.Value.String() != "15" || item.Value.Flags != 7 || item.ExpireAt.IsZero() {
+		t.Fatalf("unexpected replayed item %+v", item)
+	}
+	if _, ok := group.Peek("gone"); ok {
+		t.Fatalf("deleted key replayed")
+	}
+}
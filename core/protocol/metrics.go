@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"sync/atomic"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/metrics"
+)
+
+// knownCommands 为耗时直方图的 command 标签的取值，其余命令都记为 unknown
+var knownCommands = map[string]bool{
+	huacache.GET_KEY: true, huacache.SET_KEY: true, huacache.DEL_KEY: true,
+	huacache.NEW_GROUP: true, huacache.LIST_GROUP: true, huacache.DEL_GROUP: true,
+	huacache.GET_KEYS: true, huacache.MGET_KEYS: true, huacache.MSET_KEYS: true, huacache.MDEL_KEYS: true,
+	huacache.HELLO: true, huacache.AUTH: true, huacache.SYNC: true, huacache.SAVE: true,
+	huacache.REWRITE_AOF: true, huacache.STATS: true, huacache.INCR: true, huacache.DECR: true,
+	huacache.ADD_KEY: true, huacache.REPLACE_KEY: true, huacache.CAS: true,
+	huacache.LEASE_GET: true, huacache.LEASE_SET: true,
+	huacache.GET_ITEM: true, huacache.PUT_ITEM: true, huacache.FLUSH_GROUP: true,
+}
+
+// observe 记录命令的处理耗时。命令名来自客户端，不在 knownCommands 中的
+// 一律合并为 unknown，无论请求是否在认证或权限检查时就被拒绝，避免标签无限增长
+func (s *BluebellServer) observe(request *BluebellRequest, res *BluebellResponse, start time.Time) {
+	command := request.Command
+	if !knownCommands[command] {
+		command = "unknown"
+	}
+	s.latency.With(command).Observe(time.Since(start))
+}
+
+// Connections implements huacache.ConnectionCounter.
+func (s *BluebellServer) Connections() (open, total int64) {
+	open = int64(atomic.LoadInt32(&s.connected))
+	return open, open + int64(atomic.LoadInt32(&s.disconnected))
+}
+
+// Connections implements huacache.ConnectionCounter.
+func (m *MemcachedServer) Connections() (open, total int64) {
+	return m.stats.currConnections.Load(), m.stats.totalConnections.Load()
+}
+
+// Connections implements huacache.ConnectionCounter.
+func (s *RespServer) Connections() (open, total int64) {
+	s.conns.Range(func(_, _ any) bool {
+		open++
+		return true
+	})
+	return open, s.nextID.Load()
+}
+
+// CollectMetrics writes the connection counts and the latency histogram of
+// every command, see metrics.Handler.
+func (s *BluebellServer) CollectMetrics(w *metrics.Writer) {
+	w.Family("huacache_bluebell_connections", metrics.Gauge, "Open Bluebell connections.")
+	w.Sample("huacache_bluebell_connections", float64(atomic.LoadInt32(&s.connected)))
+	w.Family("huacache_bluebell_connections_closed_total", metrics.Counter, "Bluebell connections closed.")
+	w.Sample("huacache_bluebell_connections_closed_total", float64(atomic.LoadInt32(&s.disconnected)))
+	s.latency.Write(w, "huacache_bluebell_command_duration_seconds", "Time to process a Bluebell command, forwarding to peers included.")
+}
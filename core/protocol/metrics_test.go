@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/metrics"
+)
+
+func TestBluebellMetrics(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	huacache.DelGroup("metrics")
+	if _, err := huacache.NewGroup("metrics", huacache.MB); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	client := NewClient(s.Addr)
+	defer client.Close()
+	if err := client.Set("metrics", "k", []byte("v"), 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	client.Get("metrics", "k")
+	client.Get("metrics", "missing")
+	client.Do(&BluebellRequest{Command: "no_such_command"})
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	huacache.CollectGroups(w)
+	s.CollectMetrics(w)
+	w.Flush()
+	for _, want := range []string{
+		`huacache_group_hits_total{group="metrics"} 1`,
+		`huacache_group_misses_total{group="metrics"} 1`,
+		`huacache_group_sets_total{group="metrics"} 1`,
+		`huacache_group_keys{group="metrics"} 1`,
+		`huacache_group_bytes_capacity{group="metrics"} 1048576`,
+		`huacache_bluebell_connections 1`,
+		`huacache_bluebell_command_duration_seconds_count{command="set"} 1`,
+		`huacache_bluebell_command_duration_seconds_count{command="get"} 2`,
+		`huacache_bluebell_command_duration_seconds_count{command="unknown"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expect %s in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBluebellMetricsUnknownCommands(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetACL(newTestACL(t))
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	if res, err := client.Do(&BluebellRequest{Command: huacache.AUTH, Key: "app", Value: []byte("pw")}); err != nil || res.Status != StatusOK {
+		t.Fatalf("auth failed: %v %v", res, err)
+	}
+	// 权限检查时就被拒绝的命令也不能把客户端发来的名字变成标签
+	for _, command := range []string{"bogus-1", "bogus-2", huacache.GET_KEY} {
+		if res, err := client.Do(&BluebellRequest{Command: command, Group: "other", Key: "k"}); err != nil || res.Status != StatusForbidden {
+			t.Fatalf("expect %s to be forbidden, got %v %v", command, res, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	s.CollectMetrics(w)
+	w.Flush()
+	if strings.Contains(buf.String(), "bogus") {
+		t.Fatalf("expect no label for unknown commands in:\n%s", buf.String())
+	}
+	for _, want := range []string{
+		`huacache_bluebell_command_duration_seconds_count{command="unknown"} 2`,
+		`huacache_bluebell_command_duration_seconds_count{command="get"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expect %s in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBluebellStats(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	huacache.DelGroup("bluebell_stats")
+	if _, err := huacache.NewGroup("bluebell_stats", huacache.MB); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	client := NewClient(s.Addr)
+	defer client.Close()
+	client.Set("bluebell_stats", "k", []byte("v"), 0)
+
+	stats, err := client.Stats("bluebell_stats")
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Version != huacache.VERSION || len(stats.Groups) != 1 || stats.Groups[0].KeyCount != 1 || stats.Groups[0].Sets != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	res, err := client.Do(&BluebellRequest{Command: huacache.STATS})
+	if err != nil || !strings.Contains(string(res.Result), "# Group bluebell_stats") {
+		t.Fatalf("unexpected text stats %v %v", res, err)
+	}
+	if _, err := client.Stats("no_such_group"); err == nil {
+		t.Fatalf("expect an error for a missing group")
+	}
+	if open, total := s.Connections(); open < 1 || total < open {
+		t.Fatalf("unexpected connections %d %d", open, total)
+	}
+}
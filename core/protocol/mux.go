@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// MuxClient sends v2 requests over a single connection. It is safe for
+// concurrent use, every goroutine may have requests in flight at the same
+// time and responses are matched to them by request ID.
+type MuxClient struct {
+	Addr     string
+	Server   ServerInfo // 握手时服务端返回的身份
+	Features []string   // 协商出的特性
+	conn     net.Conn
+	compress bool
+
+	wmu sync.Mutex // 串行化写入
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *BluebellResponse // 等待响应的请求
+	err     error                             // 连接关闭的原因
+	done    chan struct{}                     // 连接关闭时关闭
+}
+
+// DialMux connects to the node listening on addr and negotiates request
+// IDs with HELLO, along with batch commands and compression.
+func DialMux(addr string) (*MuxClient, error) {
+	return DialMuxAuth(addr, nil)
+}
+
+// DialMuxAuth is like DialMux but also authenticates with credentials in
+// the HELLO, for nodes that enforce an ACL.
+func DialMuxAuth(addr string, credentials *Credentials) (*MuxClient, error) {
+	return DialMuxTLS(addr, nil, credentials)
+}
+
+// DialMuxTLS is like DialMuxAuth but dials over TLS when config is not
+// nil. credentials may be nil, e.g. when the client certificate is enough.
+func DialMuxTLS(addr string, config *tls.Config, credentials *Credentials) (*MuxClient, error) {
+	conn, err := dial(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	m := &MuxClient{
+		Addr:    addr,
+		conn:    conn,
+		pending: make(map[uint64]chan *BluebellResponse),
+		done:    make(chan struct{}),
+	}
+	reader := bufio.NewReader(conn)
+	if err := m.handshake(reader, credentials); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go m.readLoop(reader)
+	return m, nil
+}
+
+// handshake 发送 HELLO 并按协商的结果设置连接模式
+func (m *MuxClient) handshake(reader *bufio.Reader, credentials *Credentials) error {
+	hello := Hello{Version: ProtocolV2, Features: []string{FeatureRequestID, FeatureBatch, FeatureCompression}}
+	if credentials != nil {
+		hello.User, hello.Password = credentials.User, credentials.Password
+	}
+	data, err := (&BluebellRequest{Command: huacache.HELLO, Value: SonicSerialize(hello)}).Encode()
+	if err != nil {
+		return err
+	}
+	m.conn.SetDeadline(time.Now().Add(clientIOTimeout))
+	defer m.conn.SetDeadline(time.Time{})
+	if _, err := m.conn.Write(data); err != nil {
+		return err
+	}
+	res, err := ReadResponse(reader)
+	if err != nil {
+		return err
+	}
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("hello failed: %w", err)
+	}
+	var reply HelloReply
+	if err := sonic.Unmarshal(res.Result, &reply); err != nil {
+		return err
+	}
+	if !slices.Contains(reply.Features, FeatureRequestID) {
+		return fmt.Errorf("%s does not support request ids", m.Addr)
+	}
+	m.Server, m.Features = reply.Server, reply.Features
+	m.compress = slices.Contains(reply.Features, FeatureCompression)
+	return nil
+}
+
+// Do sends the request and waits for its response.
+func (m *MuxClient) Do(request *BluebellRequest) (*BluebellResponse, error) {
+	ch := make(chan *BluebellResponse, 1)
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return nil, m.err
+	}
+	m.nextID++
+	id := m.nextID
+	m.pending[id] = ch
+	m.mu.Unlock()
+
+	sent := *request
+	sent.Version, sent.ID = ProtocolV2, id
+	body, err := sent.Serialize()
+	if err != nil {
+		m.forget(id)
+		return nil, err
+	}
+	if m.compress {
+		body = compressBody(body)
+	}
+	data := frame(body)
+	m.wmu.Lock()
+	m.conn.SetWriteDeadline(time.Now().Add(clientIOTimeout))
+	_, err = m.conn.Write(data)
+	m.wmu.Unlock()
+	if err != nil {
+		m.fail(err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(clientIOTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-m.done:
+		return nil, m.err
+	case <-timer.C:
+		m.forget(id)
+		return nil, errors.New("timeout waiting for response")
+	}
+}
+
+// Close closes the connection, requests in flight fail.
+func (m *MuxClient) Close() {
+	m.fail(net.ErrClosed)
+}
+
+func (m *MuxClient) forget(id uint64) {
+	m.mu.Lock()
+	delete(m.pending, id)
+	m.mu.Unlock()
+}
+
+// fail 关闭连接，记录原因并唤醒所有等待中的请求
+func (m *MuxClient) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	m.conn.Close()
+	close(m.done)
+}
+
+func (m *MuxClient) readLoop(reader *bufio.Reader) {
+	for {
+		body, err := readFrame(reader, huacache.LIMIT_SIZE)
+		if err == nil && m.compress {
+			body, err = decompressBody(body, huacache.LIMIT_SIZE)
+		}
+		var res *BluebellResponse
+		if err == nil {
+			res, err = DeserializeResponse(body)
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		m.mu.Lock()
+		ch, ok := m.pending[res.ID]
+		delete(m.pending, res.ID)
+		m.mu.Unlock()
+		if ok {
+			ch <- res
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestMuxClient(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client, err := DialMux(s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	if res, err := client.Do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: "mux"}); err != nil || res.Code != "200" {
+		t.Fatalf("create group failed: %v %v", res, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, value := "k"+strconv.Itoa(i), "v"+strconv.Itoa(i)
+			if res, err := client.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: key, Value: []byte(value), Group: "mux"}); err != nil || res.Code != "200" {
+				t.Errorf("set %s failed: %v %v", key, res, err)
+				return
+			}
+			res, err := client.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: "mux"})
+			if err != nil || string(res.Result) != value {
+				t.Errorf("get %s: expect %s, got %v %v", key, value, res, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOutOfOrderResponses(t *testing.T) {
+	// 伪造的对端节点，过一段时间才响应
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer peer.Close()
+	go func() {
+		conn, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := ReadRequest(conn); err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		res, _ := (&BluebellResponse{Code: "200", Result: []byte("slow")}).Encode()
+		conn.Write(res)
+	}()
+
+	self := freeAddr(t)
+	cluster := NewCluster(self, self, peer.Addr().String())
+	s := NewBluebellServer("tcp", self, false)
+	s.SetCluster(cluster)
+	startServer(t, s)
+	group, err := huacache.NewGroup("ooo", 1<<20)
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	var remote, local string
+	for i := 0; remote == "" || local == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := cluster.Owner(key); ok {
+			remote = key
+		} else {
+			local = key
+		}
+	}
+	group.AddOrUpdate(local, huacache.ByteView{B: []byte("fast")})
+
+	conn, err := net.Dial("tcp", self)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	var data []byte
+	for i, key := range []string{remote, local} {
+		frame, _ := (&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: "ooo", Version: ProtocolV2, ID: uint64(i + 1)}).Encode()
+		data = append(data, frame...)
+	}
+	conn.Write(data)
+	reader := bufio.NewReader(conn)
+	for _, want := range []struct {
+		id     uint64
+		result string
+	}{{2, "fast"}, {1, "slow"}} {
+		res, err := ReadResponse(reader)
+		if err != nil {
+			t.Fatalf("read response failed: %v", err)
+		}
+		if res.Version != ProtocolV2 || res.ID != want.id || string(res.Result) != want.result {
+			t.Fatalf("expect response %d %s, got %d %s", want.id, want.result, res.ID, res.Result)
+		}
+	}
+}
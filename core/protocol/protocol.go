@@ -2,13 +2,16 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 
 	"github.com/bytedance/sonic"
 	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/metrics"
 	"github.com/panjf2000/gnet/v2"
 )
 
@@ -18,14 +21,53 @@ type BluebellRequest struct {
 	Key     string // 键，通常是用于标识数据的字符串
 	Value   []byte // 值，存储数据的字节数组
 	Group   string // 组，表示消息所属的组或类别
+	TTL     int64  // 过期时间（毫秒），0 表示永不过期；旧客户端不携带该字段
+	Flags   uint8  // 请求标志位，见 FlagForwarded
+	Version uint8  // 帧版本，0 为 v1，ProtocolV2 时携带 ID
+	ID      uint64 // 请求 ID，由客户端分配，响应中原样返回
+	CAS     uint64 // cas 命令期望的版本，即 get 响应中的 CAS
 }
+
+// v1 的消息体以 Command（或响应的 Code）的 4 字节长度开头，首字节总是 0；
+// v2 的消息体以非 0 的版本号开头，随后是 8 字节的请求 ID，其余部分与 v1 相同：
+//
+//	version uint8 | id uint64 | v1 body
+//
+// v2 的响应带有同样的头部，服务端可以不按请求的顺序返回 v2 请求的响应，
+// 客户端据 ID 匹配请求与响应，从而在一个连接上同时发出多个请求。
+const ProtocolV2 uint8 = 2
+
+const (
+	// FlagForwarded 表示请求已由集群中的其他节点路由过，收到的节点直接在本地处理。
+	// 开启认证时只接受有节点管理权限的连接设置该标志，见 BluebellServer.trusted
+	FlagForwarded uint8 = 1 << iota
+	// FlagExactTTL 表示 set 的 TTL 是 key 剩余的实际寿命，不再套用分组的默认 ttl
+	// 和 stale 时长，0 表示永不过期。用于 AOF 和副本中改写的计数器；put_item
+	// 带有该标志时不检查 CAS，无条件写入。只在 apply 中设置，客户端发来的请求会清除该标志
+	FlagExactTTL
+)
+
+// 响应标志位
+const (
+	// FlagStale 表示 get 返回的值已超过分组的 soft ttl，见 huacache.WithSoftTTL
+	FlagStale uint8 = 1 << iota
+	// FlagMore 表示 sync 的快照还有后续分片，见 replicaFeed.run
+	FlagMore
+)
+
 type BluebellResponse struct {
-	Code   string
-	Result []byte // 响应数据
+	Code    string
+	Result  []byte // 响应数据，失败时为错误信息
+	Status  Status // 类型化的状态；旧版服务端不携带该字段，按 Code 推断
+	Version uint8  // 与请求的帧版本相同
+	ID      uint64 // 对应请求的 ID
+	CAS     uint64 // key 的版本，get 和单个 key 的写入成功时携带，0 表示没有
+	Flags   uint8  // 响应标志位，见 FlagStale
 }
 
 func (b *BluebellResponse) Serialize() ([]byte, error) {
 	buf := new(bytes.Buffer)
+	writeHeader(buf, b.Version, b.ID)
 	if err := writeString(buf, b.Code); err != nil {
 		return nil, err
 	}
@@ -34,6 +76,19 @@ func (b *BluebellResponse) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// Status 字段（可选）
+	if err := binary.Write(buf, binary.BigEndian, uint16(b.Status)); err != nil {
+		return nil, err
+	}
+
+	// CAS 字段（可选）
+	if err := binary.Write(buf, binary.BigEndian, b.CAS); err != nil {
+		return nil, err
+	}
+
+	// Flags 字段（可选）
+	buf.WriteByte(b.Flags)
+
 	return buf.Bytes(), nil
 }
 func (b *BluebellResponse) Encode() ([]byte, error) {
@@ -59,6 +114,10 @@ func (b *BluebellResponse) Encode() ([]byte, error) {
 }
 func DeserializeResponse(data []byte) (*BluebellResponse, error) {
 	buf := bytes.NewBuffer(data)
+	version, id, err := readHeader(buf, data)
+	if err != nil {
+		return nil, err
+	}
 
 	code, err := readString(buf)
 	if err != nil {
@@ -70,17 +129,36 @@ func DeserializeResponse(data []byte) (*BluebellResponse, error) {
 		return nil, err
 	}
 
+	status := statusFromCode(code)
+	if buf.Len() >= 2 {
+		status = Status(binary.BigEndian.Uint16(buf.Next(2)))
+	}
+	var cas uint64
+	if buf.Len() >= 8 {
+		cas = binary.BigEndian.Uint64(buf.Next(8))
+	}
+	var flags uint8
+	if buf.Len() >= 1 {
+		flags, _ = buf.ReadByte()
+	}
+
 	return &BluebellResponse{
-		Code:   code,
-		Result: result,
+		Code:    code,
+		Result:  result,
+		Status:  status,
+		Version: version,
+		ID:      id,
+		CAS:     cas,
+		Flags:   flags,
 	}, nil
 }
 func (b *BluebellRequest) String() string {
-	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n  Group: %s\n}",
+	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n  Group: %s,\n  TTL: %d\n}",
 		b.Command,
 		b.Key,
 		string(b.Value), // 将 []byte 转换为 string
 		b.Group,
+		b.TTL,
 	)
 }
 func (b *BluebellRequest) Encode() ([]byte, error) {
@@ -103,6 +181,7 @@ func (b *BluebellRequest) Encode() ([]byte, error) {
 // 序列化：将 Bluebell 结构体序列化为二进制
 func (b *BluebellRequest) Serialize() ([]byte, error) {
 	buf := new(bytes.Buffer)
+	writeHeader(buf, b.Version, b.ID)
 
 	// Command 字段
 	if err := writeString(buf, b.Command); err != nil {
@@ -124,6 +203,21 @@ func (b *BluebellRequest) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// TTL 字段（可选）
+	if err := binary.Write(buf, binary.BigEndian, b.TTL); err != nil {
+		return nil, err
+	}
+
+	// Flags 字段（可选）
+	if err := buf.WriteByte(b.Flags); err != nil {
+		return nil, err
+	}
+
+	// CAS 字段（可选）
+	if err := binary.Write(buf, binary.BigEndian, b.CAS); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -132,6 +226,13 @@ func Deserialize(data []byte) (*BluebellRequest, error) {
 	buf := bytes.NewReader(data)
 	b := &BluebellRequest{}
 
+	// v2 头部
+	version, id, err := readHeader(buf, data)
+	if err != nil {
+		return nil, err
+	}
+	b.Version, b.ID = version, id
+
 	// Command 字段
 	command, err := readString(buf)
 	if err != nil {
@@ -160,9 +261,58 @@ func Deserialize(data []byte) (*BluebellRequest, error) {
 	}
 	b.Group = group
 
+	// TTL 字段是可选的，旧客户端的消息到 Group 为止
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.BigEndian, &b.TTL); err != nil {
+			return nil, err
+		}
+	}
+
+	// Flags 字段（可选）
+	if buf.Len() > 0 {
+		flags, err := buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		b.Flags = flags
+	}
+
+	// CAS 字段（可选）
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.BigEndian, &b.CAS); err != nil {
+			return nil, err
+		}
+	}
+
 	return b, nil
 }
 
+// writeHeader 写入 v2 的头部，v1 没有头部
+func writeHeader(buf *bytes.Buffer, version uint8, id uint64) {
+	if version < ProtocolV2 {
+		return
+	}
+	buf.WriteByte(version)
+	binary.Write(buf, binary.BigEndian, id)
+}
+
+// readHeader 根据消息体的首字节判断帧版本，v2 时读取头部
+func readHeader(buf io.Reader, data []byte) (version uint8, id uint64, err error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, nil
+	}
+	if data[0] != ProtocolV2 {
+		return 0, 0, fmt.Errorf("unsupported protocol version %d", data[0])
+	}
+	if err := binary.Read(buf, binary.BigEndian, &version); err != nil {
+		return 0, 0, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &id); err != nil {
+		return 0, 0, err
+	}
+	return version, id, nil
+}
+
 // writeString 将字符串以长度+内容的形式写入到缓冲区
 func writeString(buf *bytes.Buffer, s string) error {
 	length := uint32(len(s))
@@ -217,18 +367,44 @@ type BluebellServer struct {
 	Network      string
 	Addr         string
 	Multicore    bool
+	MaxFrameSize int // 帧体的最大长度，超过时关闭连接
 	connected    int32
 	disconnected int32
 	inBufferPool *sync.Pool
+	cluster      *Cluster // 集群路由，为 nil 时为单机模式
+	primary      string   // 主节点地址，非空时本节点为只读副本
+	// upstreamClient 为副本把 memcached 和 RESP 的写入发给主节点的客户端，见 upstream
+	upstreamOnce   sync.Once
+	upstreamClient *Client
+	// 写命令在执行时持有 writeMu 的读锁；有副本或开启 AOF 时改为持有写锁，
+	// 保证命令的执行顺序与推送给副本、写入 AOF 的顺序一致
+	writeMu     sync.RWMutex
+	replication replication
+	aof         *AOF                  // 为 nil 时不记录 AOF
+	snapshotter *huacache.Snapshotter // 为 nil 时不支持 save 命令
+	stop        chan struct{}         // 服务停止时关闭
+	acl         *huacache.ACL         // 为 nil 时不需要认证
+	credentials *Credentials          // 连接对端和主节点时使用的凭据
+	tls         *tls.Config           // 非 nil 时只接受 TLS 连接，见 Run
+	peerTLS     *tls.Config           // 非 nil 时使用 TLS 连接对端和主节点
+	tlsListener net.Listener
+	engineAddr  string        // 开启 TLS 时引擎监听的 unix socket
+	booted      chan struct{} // 引擎启动后关闭
+	drain       drainer
+	latency     *metrics.HistogramVec // 按命令统计的处理耗时
 }
 
 // 创建新服务
 func NewBluebellServer(network, addr string, multicore bool) *BluebellServer {
 	return &BluebellServer{
-		buffer:    make(map[gnet.Conn]*bytes.Buffer),
-		Network:   network,
-		Addr:      addr,
-		Multicore: multicore,
+		buffer:       make(map[gnet.Conn]*bytes.Buffer),
+		Network:      network,
+		Addr:         addr,
+		Multicore:    multicore,
+		MaxFrameSize: huacache.LIMIT_SIZE,
+		stop:         make(chan struct{}),
+		booted:       make(chan struct{}),
+		latency:      metrics.NewHistogramVec("command", metrics.LatencyBuckets),
 		inBufferPool: &sync.Pool{
 			New: func() interface{} {
 				return make([]byte, huacache.LIMIT_SIZE) // 预先创建缓冲区
@@ -236,6 +412,22 @@ func NewBluebellServer(network, addr string, multicore bool) *BluebellServer {
 		}}
 }
 
+// SetAOF makes the server log every write to aof before acknowledging it.
+func (s *BluebellServer) SetAOF(aof *AOF) {
+	s.aof = aof
+}
+
+// SetSnapshotter enables the save command.
+func (s *BluebellServer) SetSnapshotter(snapshotter *huacache.Snapshotter) {
+	s.snapshotter = snapshotter
+}
+
+// SetCluster makes the server route keys it does not own to their peers,
+// it must be called before the server starts.
+func (s *BluebellServer) SetCluster(cluster *Cluster) {
+	s.cluster = cluster
+}
+
 func SonicSerialize(b interface{}) []byte {
 	jsonBytes, err := sonic.Marshal(b)
 	if err != nil {
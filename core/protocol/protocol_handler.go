@@ -3,48 +3,103 @@ package protocol
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	huacache "github.com/huahuoao/huacache/core"
 )
 
 func HandleSetKey(request *BluebellRequest) *BluebellResponse {
-	group, _ := huacache.GetGroup(request.Group)
-	err := group.AddOrUpdate(request.Key, huacache.ByteView{B: request.Value})
+	group, err := huacache.GetGroup(request.Group)
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte("failed to set key"),
+		return errResponse(err)
+	}
+	ttl := time.Duration(request.TTL) * time.Millisecond
+	value := huacache.ByteView{B: request.Value}
+	var item huacache.Item
+	if request.Flags&FlagExactTTL != 0 {
+		var expire time.Time
+		if ttl > 0 {
+			expire = time.Now().Add(ttl)
 		}
+		item, err = group.Restore(request.Key, value, expire)
+	} else {
+		item, err = group.Set(request.Key, value, ttl)
+	}
+	if err != nil {
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
 		Result: []byte("OK"),
+		CAS:    item.Version,
+	}
+}
+
+// HandleConditionalSet 处理 add、replace、cas 和 lease_set：add 只在 key 不存在时写入，
+// replace 只在 key 存在时写入，cas 只在 key 的版本仍为请求的 CAS 时写入，
+// lease_set 只在请求的 CAS 为 key 有效的租约时写入
+func HandleConditionalSet(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	value := huacache.ByteView{B: request.Value}
+	ttl := time.Duration(request.TTL) * time.Millisecond
+	var item huacache.Item
+	switch request.Command {
+	case huacache.ADD_KEY:
+		item, err = group.Add(request.Key, value, ttl)
+	case huacache.REPLACE_KEY:
+		item, err = group.Replace(request.Key, value, ttl)
+	case huacache.LEASE_SET:
+		item, err = group.LeaseSet(request.Key, value, ttl, request.CAS)
+	default:
+		item, err = group.CompareAndSwap(request.Key, value, ttl, request.CAS)
+	}
+	if err != nil {
+		return errResponse(err)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+		CAS:    item.Version,
 	}
 }
 
 func HandleGetKey(request *BluebellRequest) *BluebellResponse {
-	group, _ := huacache.GetGroup(request.Group)
-	value, err := group.Get(request.Key)
+	group, err := huacache.GetGroup(request.Group)
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte("failed to get key"),
-		}
+		return errResponse(err)
+	}
+	item, err := group.GetItem(request.Key)
+	if err != nil {
+		// 回源失败（LOAD_FAILED）与未命中（NOT_FOUND）区分开，便于客户端处理
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
-		Result: value.B,
+		Result: item.Value.B,
+		CAS:    item.Version,
+		Flags:  staleFlag(item.Value),
 	}
 }
 
+// staleFlag 为 stale 的值返回 FlagStale
+func staleFlag(v huacache.ByteView) uint8 {
+	if v.Stale {
+		return FlagStale
+	}
+	return 0
+}
+
 func HandleDeleteKey(request *BluebellRequest) *BluebellResponse {
-	group, _ := huacache.GetGroup(request.Group)
-	err := group.Delete(request.Key)
+	group, err := huacache.GetGroup(request.Group)
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte("failed to delete key"),
-		}
+		return errResponse(err)
+	}
+	err = group.Delete(request.Key)
+	if err != nil {
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
@@ -55,17 +110,12 @@ func HandleDeleteKey(request *BluebellRequest) *BluebellResponse {
 func HandleNewGroup(request *BluebellRequest) *BluebellResponse {
 	size, err := strconv.ParseInt(request.Key, 10, 64)
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "400",
-			Result: []byte("invalid size"),
-		}
+		return errorResponse(StatusBadRequest, "invalid size")
 	}
-	_, err = huacache.NewGroup(request.Group, size)
+	// Value 为可选的淘汰策略名称，为空时使用 LRU
+	_, err = huacache.NewGroup(request.Group, size, huacache.WithPolicy(string(request.Value)))
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte(err.Error()),
-		}
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
@@ -76,10 +126,7 @@ func HandleNewGroup(request *BluebellRequest) *BluebellResponse {
 func HandleDeleteGroup(request *BluebellRequest) *BluebellResponse {
 	err := huacache.DelGroup(request.Group)
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte("failed to delete group"),
-		}
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
@@ -90,13 +137,116 @@ func HandleDeleteGroup(request *BluebellRequest) *BluebellResponse {
 func HandleListGroup(request *BluebellRequest) *BluebellResponse {
 	groups, err := huacache.ListGroups()
 	if err != nil {
-		return &BluebellResponse{
-			Code:   "500",
-			Result: []byte("failed to list groups"),
-		}
+		return errResponse(err)
 	}
 	return &BluebellResponse{
 		Code:   "200",
 		Result: []byte(fmt.Sprintf("%v", groups)),
 	}
 }
+
+// HandleStats 返回节点的统计信息，Key 为 json 时返回 JSON，否则返回文本；
+// 指定 Group 时只包含该分组
+func HandleStats(request *BluebellRequest) *BluebellResponse {
+	stats, err := huacache.GetNodeStats(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	result := []byte(stats.Text())
+	if request.Key == "json" {
+		result = SonicSerialize(stats)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: result,
+	}
+}
+
+// HandleLeaseGet 处理 lease_get：命中时与 get 相同；未命中时返回 NOT_FOUND，
+// 拿到租约的请求在 CAS 中携带租约令牌，其余请求返回 LEASE_WAIT
+func HandleLeaseGet(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	item, token, err := group.LeaseGet(request.Key)
+	if err != nil {
+		res := errResponse(err)
+		res.CAS = token
+		return res
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: item.Value.B,
+		CAS:    item.Version,
+		Flags:  staleFlag(item.Value),
+	}
+}
+
+// HandleGetItem 处理 get_item：返回缓存中 key 的完整 item（见 huacache.Item.MarshalBinary），
+// 不回源，CAS 为其版本
+func HandleGetItem(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	item, ok := group.Peek(request.Key)
+	if !ok {
+		return errResponse(huacache.ErrKeyNotFound)
+	}
+	data, err := item.MarshalBinary()
+	if err != nil {
+		return errResponse(err)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: data,
+		CAS:    item.Version,
+	}
+}
+
+// HandlePutItem 处理 put_item：Value 为编码后的 item，原样写入。CAS 为 0 时 key 必须
+// 不存在，否则其版本必须等于 CAS；带 FlagExactTTL 时无条件写入
+func HandlePutItem(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	var item huacache.Item
+	if err := item.UnmarshalBinary(request.Value); err != nil {
+		return errorResponse(StatusBadRequest, err.Error())
+	}
+	item, err = group.Update(request.Key, func(old huacache.Item, ok bool) (huacache.Item, error) {
+		switch {
+		case request.Flags&FlagExactTTL != 0:
+		case request.CAS == 0 && ok:
+			return huacache.Item{}, huacache.ErrKeyExists
+		case request.CAS != 0 && !ok:
+			return huacache.Item{}, huacache.ErrKeyNotFound
+		case request.CAS != 0 && old.Version != request.CAS:
+			return huacache.Item{}, huacache.ErrVersionMismatch
+		}
+		return item, nil
+	})
+	if err != nil {
+		return errResponse(err)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+		CAS:    item.Version,
+	}
+}
+
+// HandleFlushGroup 处理 flush_group：删除分组中的所有 key
+func HandleFlushGroup(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	group.Flush()
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+	}
+}
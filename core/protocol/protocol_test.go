@@ -47,3 +47,77 @@ func TestBluebellCodec(t *testing.T) {
 		t.Errorf("Group 不匹配, 得到: %v, 期望: %v", deserialized.Group, original.Group)
 	}
 }
+
+// TestBluebellCodecTTL 测试 TTL 字段以及不带 TTL 的旧版消息
+func TestBluebellCodecTTL(t *testing.T) {
+	original := &BluebellRequest{Command: "set", Key: "k", Value: []byte("v"), Group: "g", TTL: 1500}
+	data, err := original.Serialize()
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	deserialized, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("反序列化失败: %v", err)
+	}
+	if deserialized.TTL != original.TTL {
+		t.Errorf("TTL 不匹配, 得到: %v, 期望: %v", deserialized.TTL, original.TTL)
+	}
+
+	// 旧版客户端不携带 TTL 及之后的字段
+	legacy, err := Deserialize(data[:len(data)-8-1-8])
+	if err != nil {
+		t.Fatalf("反序列化旧版消息失败: %v", err)
+	}
+	if legacy.TTL != 0 || legacy.Group != "g" {
+		t.Errorf("旧版消息解析错误: %v", legacy)
+	}
+}
+
+// TestBluebellCodecCAS 测试请求和响应的 CAS 字段
+func TestBluebellCodecCAS(t *testing.T) {
+	data, _ := (&BluebellRequest{Command: "cas", Key: "k", Group: "g", CAS: 42}).Serialize()
+	request, err := Deserialize(data)
+	if err != nil || request.CAS != 42 {
+		t.Fatalf("CAS 不匹配, 得到: %v %v", request, err)
+	}
+	body, _ := (&BluebellResponse{Code: "200", CAS: 43}).Serialize()
+	res, err := DeserializeResponse(body)
+	if err != nil || res.CAS != 43 {
+		t.Fatalf("CAS 不匹配, 得到: %v %v", res, err)
+	}
+	// 旧版服务端的响应不带 CAS
+	if res, _ = DeserializeResponse(body[:len(body)-8]); res.CAS != 0 || res.Status != StatusOK {
+		t.Fatalf("旧版响应解析错误: %v", res)
+	}
+}
+
+// TestBluebellCodecV2 测试带请求 ID 的 v2 消息
+func TestBluebellCodecV2(t *testing.T) {
+	original := &BluebellRequest{Command: "get", Key: "k", Group: "g", Version: ProtocolV2, ID: 1<<40 + 7}
+	data, err := original.Serialize()
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	if data[0] != ProtocolV2 {
+		t.Fatalf("v2 消息应以版本号开头, 得到: %v", data[0])
+	}
+	deserialized, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("反序列化失败: %v", err)
+	}
+	if deserialized.Version != ProtocolV2 || deserialized.ID != original.ID || deserialized.Key != "k" {
+		t.Errorf("v2 消息解析错误: %+v", deserialized)
+	}
+
+	res := &BluebellResponse{Code: "200", Result: []byte("v"), Version: ProtocolV2, ID: 42}
+	body, _ := res.Serialize()
+	decoded, err := DeserializeResponse(body)
+	if err != nil || decoded.ID != 42 || decoded.Code != "200" || string(decoded.Result) != "v" {
+		t.Errorf("v2 响应解析错误: %+v %v", decoded, err)
+	}
+
+	data[0] = 9
+	if _, err := Deserialize(data); err == nil {
+		t.Errorf("未知版本应当报错")
+	}
+}
@@ -0,0 +1,291 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	// 副本落后太多时主节点断开连接，副本重连后重新全量同步
+	replicaBacklog      = 64 * 1024
+	replicaRetryBackoff = time.Second
+	// 快照按该长度分片发送，副本不必一次读入整个快照
+	syncChunkSize = 256 * 1024
+)
+
+// replication 记录连接到本节点的副本，replicas 由 BluebellServer.writeMu 保护
+type replication struct {
+	replicas map[gnet.Conn]*replicaFeed
+}
+
+// replicaFeed 是推送给单个副本的命令队列
+type replicaFeed struct {
+	conn   gnet.Conn
+	frames chan []byte
+	done   chan struct{}
+}
+
+// isWrite reports whether the command modifies the data set.
+func isWrite(command string) bool {
+	switch command {
+	case huacache.SET_KEY, huacache.DEL_KEY, huacache.MSET_KEYS, huacache.MDEL_KEYS, huacache.NEW_GROUP, huacache.DEL_GROUP,
+		huacache.INCR, huacache.DECR, huacache.ADD_KEY, huacache.REPLACE_KEY, huacache.CAS, huacache.LEASE_SET,
+		huacache.PUT_ITEM, huacache.FLUSH_GROUP:
+		return true
+	}
+	return false
+}
+
+func dispatchWrite(request *BluebellRequest) *BluebellResponse {
+	switch request.Command {
+	case huacache.SET_KEY:
+		return HandleSetKey(request)
+	case huacache.DEL_KEY:
+		return HandleDeleteKey(request)
+	case huacache.MSET_KEYS, huacache.MDEL_KEYS:
+		return HandleBatch(request)
+	case huacache.INCR, huacache.DECR:
+		return HandleCounter(request)
+	case huacache.ADD_KEY, huacache.REPLACE_KEY, huacache.CAS, huacache.LEASE_SET:
+		return HandleConditionalSet(request)
+	case huacache.PUT_ITEM:
+		return HandlePutItem(request)
+	case huacache.FLUSH_GROUP:
+		return HandleFlushGroup(request)
+	case huacache.NEW_GROUP:
+		return HandleNewGroup(request)
+	default:
+		return HandleDeleteGroup(request)
+	}
+}
+
+// feed 把命令加入每个副本的队列，调用方需持有写锁
+func (r *replication) feed(request *BluebellRequest) {
+	replicated := *request
+	replicated.Flags &^= FlagForwarded
+	replicated.Version, replicated.ID = 0, 0
+	frame, err := replicated.Encode()
+	if err != nil {
+		log.Println("Failed to encode replicated command:", err)
+		return
+	}
+	for c, f := range r.replicas {
+		select {
+		case f.frames <- frame:
+		default:
+			log.Printf("replica %s is too far behind, disconnecting", c.RemoteAddr())
+			r.remove(c)
+			c.Close()
+		}
+	}
+}
+
+// addReplica 注册副本：先登记命令队列再生成快照，快照之后的写命令都会进入队列。
+// 快照生成期间的命令可能同时出现在快照和队列中，重放它们是幂等的。
+func (s *BluebellServer) addReplica(c gnet.Conn) {
+	f := &replicaFeed{
+		conn:   c,
+		frames: make(chan []byte, replicaBacklog),
+		done:   make(chan struct{}),
+	}
+	s.writeMu.Lock()
+	r := &s.replication
+	if r.replicas == nil {
+		r.replicas = make(map[gnet.Conn]*replicaFeed)
+	}
+	r.replicas[c] = f
+	s.writeMu.Unlock()
+	c.Context().(*session).replica.Store(true)
+	log.Printf("replica %s connected", c.RemoteAddr())
+	go f.run()
+}
+
+// removeReplica is called when the connection of a replica is closed.
+func (s *BluebellServer) removeReplica(c gnet.Conn) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.replication.remove(c)
+}
+
+func (r *replication) remove(c gnet.Conn) {
+	if f, ok := r.replicas[c]; ok {
+		close(f.done)
+		delete(r.replicas, c)
+	}
+}
+
+// run 先发送全量快照，再持续推送命令。快照分成多个响应发送，除最后一个外都带有 FlagMore
+func (f *replicaFeed) run() {
+	w := &chunkWriter{conn: f.conn}
+	if err := huacache.SaveSnapshot(w); err != nil {
+		log.Printf("failed to snapshot for replica %s: %v", f.conn.RemoteAddr(), err)
+		f.conn.Close()
+		return
+	}
+	if err := w.flush(0); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-f.done:
+			return
+		case frame := <-f.frames:
+			if err := f.conn.AsyncWrite(frame, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// chunkWriter 把写入的数据切分成 syncChunkSize 大小的响应发给副本
+type chunkWriter struct {
+	conn gnet.Conn
+	buf  []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(syncChunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if len(w.buf) == syncChunkSize {
+			if err := w.flush(FlagMore); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush(flags uint8) error {
+	frame, err := (&BluebellResponse{Code: "200", Result: w.buf, Flags: flags}).Encode()
+	if err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return w.conn.AsyncWrite(frame, nil)
+}
+
+// chunkReader 依次读取主节点发来的快照分片，读完最后一个分片后返回 io.EOF
+type chunkReader struct {
+	r     io.Reader
+	limit int
+	buf   []byte
+	done  bool
+	size  int // 已读取的分片总长度
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		res, err := readResponse(r.r, r.limit)
+		if err != nil {
+			return 0, err
+		}
+		if err := res.Err(); err != nil {
+			return 0, fmt.Errorf("primary replied %w", err)
+		}
+		r.buf, r.done = res.Result, res.Flags&FlagMore == 0
+		r.size += len(res.Result)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// ReplicaOf makes the server a read-only replica of primary, it must be
+// called before the server starts. Writes from clients are answered with
+// code 307 and the address of the primary.
+func (s *BluebellServer) ReplicaOf(primary string) {
+	s.primary = primary
+}
+
+// redirect 拒绝副本上的写命令
+func (s *BluebellServer) redirect() *BluebellResponse {
+	return errorResponse(StatusReadOnly, s.primary)
+}
+
+// follow 持续从主节点同步数据，断线后重新全量同步
+func (s *BluebellServer) follow() {
+	for {
+		err := s.syncFrom(s.primary)
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		log.Printf("replication from %s stopped: %v, retrying", s.primary, err)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(replicaRetryBackoff):
+		}
+	}
+}
+
+func (s *BluebellServer) syncFrom(primary string) error {
+	conn, err := dial(primary, s.peerTLS)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-s.stop:
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+
+	if s.credentials != nil {
+		conn.SetDeadline(time.Now().Add(clientIOTimeout))
+		if err := s.credentials.login(conn); err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	data, err := (&BluebellRequest{Command: huacache.SYNC}).Encode()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	snapshot := &chunkReader{r: reader, limit: s.MaxFrameSize}
+	if err := huacache.LoadSnapshot(snapshot); err != nil {
+		return err
+	}
+	// 快照的结尾之后可能还有空的最后一个分片
+	if _, err := io.Copy(io.Discard, snapshot); err != nil {
+		return err
+	}
+	log.Printf("loaded %d bytes snapshot from primary %s", snapshot.size, primary)
+	if s.aof != nil {
+		// 全量同步替换了所有数据，旧的 AOF 已经失效
+		if err := s.aof.Rewrite(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		request, err := readRequest(reader, s.MaxFrameSize)
+		if err != nil {
+			return err
+		}
+		if res := s.apply(request); res.Status != StatusOK {
+			log.Printf("failed to apply replicated %s: %s", request.Command, res.Result)
+		}
+	}
+}
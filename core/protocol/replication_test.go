@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestSyncStream(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	group := "replicated"
+	if res, err := client.Do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "8388608", Group: group}); err != nil || res.Code != "200" {
+		t.Fatalf("create group failed: %v %v", res, err)
+	}
+	// 超过一个分片的快照
+	big := bytes.Repeat([]byte("x"), 2*syncChunkSize)
+	if res, err := client.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: "big", Value: big, Group: group}); err != nil || res.Code != "200" {
+		t.Fatalf("set failed: %v %v", res, err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	data, _ := (&BluebellRequest{Command: huacache.SYNC}).Encode()
+	conn.Write(data)
+	reader := bufio.NewReader(conn)
+	var snapshot []byte
+	chunks := 0
+	for more := true; more; chunks++ {
+		res, err := ReadResponse(reader)
+		if err != nil || res.Code != "200" || len(res.Result) > syncChunkSize {
+			t.Fatalf("unexpected snapshot chunk %v %v", res, err)
+		}
+		snapshot = append(snapshot, res.Result...)
+		more = res.Flags&FlagMore != 0
+	}
+	if chunks < 3 || !bytes.HasPrefix(snapshot, []byte("HUACACHE")) || !bytes.Contains(snapshot, big) {
+		t.Fatalf("unexpected snapshot of %d bytes in %d chunks", len(snapshot), chunks)
+	}
+
+	if res, err := client.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: []byte("v"), Group: group, TTL: 1000}); err != nil || res.Code != "200" {
+		t.Fatalf("set failed: %v %v", res, err)
+	}
+	request, err := ReadRequest(reader)
+	if err != nil {
+		t.Fatalf("read replicated command failed: %v", err)
+	}
+	if request.Command != huacache.SET_KEY || request.Key != "k" || string(request.Value) != "v" || request.TTL != 1000 {
+		t.Fatalf("unexpected replicated command %v", request)
+	}
+}
+
+func TestReplicaRejectsWrites(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.ReplicaOf("127.0.0.1:1")
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	res, err := client.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: []byte("v"), Group: "g"})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if res.Code != "307" || string(res.Result) != "127.0.0.1:1" {
+		t.Fatalf("expect redirect to primary, got %s %s", res.Code, res.Result)
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	huacache.DelGroup("chunked")
+	group, _ := huacache.NewGroup("chunked", 8*huacache.MB)
+	defer huacache.DelGroup("chunked")
+	big := bytes.Repeat([]byte("x"), 2*syncChunkSize)
+	group.Set("big", huacache.ByteView{B: big}, 0)
+	var snapshot bytes.Buffer
+	if err := huacache.SaveSnapshot(&snapshot); err != nil {
+		t.Fatalf("save snapshot failed: %v", err)
+	}
+
+	// 按分片写出快照，最后一个分片为空，之后是同步的命令
+	var stream bytes.Buffer
+	data := snapshot.Bytes()
+	for len(data) > 0 {
+		n := min(syncChunkSize, len(data))
+		frame, _ := (&BluebellResponse{Code: "200", Result: data[:n], Flags: FlagMore}).Encode()
+		stream.Write(frame)
+		data = data[n:]
+	}
+	frame, _ := (&BluebellResponse{Code: "200"}).Encode()
+	stream.Write(frame)
+	frame, _ = (&BluebellRequest{Command: huacache.DEL_KEY, Key: "big", Group: "chunked"}).Encode()
+	stream.Write(frame)
+
+	group.Flush()
+	reader := bufio.NewReader(&stream)
+	r := &chunkReader{r: reader, limit: syncChunkSize + 1024}
+	if err := huacache.LoadSnapshot(r); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil || r.size != snapshot.Len() {
+		t.Fatalf("expect %d bytes, read %d: %v", snapshot.Len(), r.size, err)
+	}
+	group, _ = huacache.GetGroup("chunked")
+	if v, err := group.Get("big"); err != nil || !bytes.Equal(v.B, big) {
+		t.Fatalf("big not restored: %v", err)
+	}
+	if request, err := ReadRequest(reader); err != nil || request.Command != huacache.DEL_KEY {
+		t.Fatalf("unexpected command after the snapshot %v %v", request, err)
+	}
+}
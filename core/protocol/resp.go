@@ -0,0 +1,1088 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/lru"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	respMaxInline    = 64 * 1024
+	respMaxMultibulk = 1024 * 1024
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// RespServer speaks the Redis protocol (RESP2, and RESP3 after HELLO 3) so
+// that existing Redis clients can use huacache. SELECT switches the group a
+// connection works on, numeric databases select the group named by the
+// number. Its writes go through an ItemStore, by default straight to the
+// groups. With
+// an ACL, clients authenticate with AUTH or HELLO AUTH and every command is
+// checked against the permissions of the user on the selected group.
+type RespServer struct {
+	*gnet.BuiltinEventEngine
+	Network   string
+	Addr      string
+	Multicore bool
+	Group     string // 新连接默认使用的分组
+	// MaxValueSize 为单个参数的最大长度
+	MaxValueSize int
+	started      time.Time
+	conns        sync.Map // id -> *respConn
+	nextID       atomic.Int64
+	commands     atomic.Int64
+	eng          gnet.Engine
+	booted       chan struct{} // 引擎启动后关闭
+	drain        drainer
+	acl          *huacache.ACL // 为 nil 时不需要认证
+	store        ItemStore
+}
+
+// respConn 为单个连接的状态
+type respConn struct {
+	id    int64
+	addr  string
+	proto int // 2 或 3，由 HELLO 协商
+	mu    sync.Mutex
+	name  string
+	group string
+	user  *huacache.User // 已认证的用户，同一时间只有事件循环或 tail 的 goroutine 访问
+	tail  chan struct{}  // 最近一批在 goroutine 中执行的命令，关闭表示已执行完，只在事件循环中访问
+}
+
+// busy reports whether commands of the connection are still running in a
+// goroutine, later commands have to wait for them.
+func (rc *respConn) busy() bool {
+	if rc.tail == nil {
+		return false
+	}
+	select {
+	case <-rc.tail:
+		return false
+	default:
+		return true
+	}
+}
+
+func (rc *respConn) selected() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.group
+}
+
+// NewRespServer creates a RESP listener, connections start on group.
+func NewRespServer(network, addr string, multicore bool, group string) *RespServer {
+	return &RespServer{
+		Network:      network,
+		Addr:         addr,
+		Multicore:    multicore,
+		Group:        group,
+		MaxValueSize: huacache.LIMIT_SIZE,
+		booted:       make(chan struct{}),
+		store:        groupStore{},
+	}
+}
+
+// SetACL makes clients authenticate before running any command but AUTH,
+// HELLO and QUIT.
+func (s *RespServer) SetACL(acl *huacache.ACL) {
+	s.acl = acl
+}
+
+// SetStore makes every write go through store, usually the Store of the
+// Bluebell server of the node. It must be called before the server starts.
+func (s *RespServer) SetStore(store ItemStore) {
+	s.store = store
+}
+
+func (s *RespServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
+	log.Printf("running resp server on %s with multi-core=%t, group=%s",
+		fmt.Sprintf("%s://%s", s.Network, s.Addr), s.Multicore, s.Group)
+	s.started = time.Now()
+	s.eng = eng
+	close(s.booted)
+	return
+}
+
+func (s *RespServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
+	rc := &respConn{
+		id:    s.nextID.Add(1),
+		proto: 2,
+		group: s.Group,
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		rc.addr = addr.String()
+	}
+	s.conns.Store(rc.id, rc)
+	c.SetContext(rc)
+	if s.drain.draining.Load() {
+		return nil, gnet.Close
+	}
+	return
+}
+
+func (s *RespServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+	if rc, ok := c.Context().(*respConn); ok {
+		s.conns.Delete(rc.id)
+	}
+	return
+}
+
+func (s *RespServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
+	rc := c.Context().(*respConn)
+	buf, _ := c.Peek(-1)
+	w := &respWriter{}
+	consumed := 0
+	// 可能阻塞的命令和它之后的命令都放到 goroutine 中依次执行
+	var async [][][]byte
+	var protoErr error
+	for consumed < len(buf) {
+		args, n, err := parseRESP(buf[consumed:], s.MaxValueSize)
+		if err != nil {
+			protoErr = err
+			consumed = len(buf)
+			break
+		}
+		if n == 0 {
+			break
+		}
+		consumed += n
+		if len(args) == 0 {
+			continue
+		}
+		if async != nil || rc.busy() || s.blocks(rc, args) {
+			for i := range args {
+				args[i] = bytes.Clone(args[i])
+			}
+			async = append(async, args)
+			continue
+		}
+		w.proto = rc.proto
+		if s.execute(rc, w, args) {
+			action = gnet.Close
+			break
+		}
+	}
+	// Discard(0) 会清空整个缓冲区，不完整的命令需要保留
+	if consumed > 0 {
+		c.Discard(consumed)
+	}
+	if async != nil {
+		s.reply(c, w)
+		s.serveAsync(c, rc, async, protoErr)
+		return
+	}
+	if protoErr != nil {
+		w.proto = rc.proto
+		w.error("ERR " + protoErr.Error())
+		action = gnet.Close
+	}
+	s.reply(c, w)
+	return
+}
+
+func (s *RespServer) reply(c gnet.Conn, w *respWriter) {
+	if w.Len() > 0 {
+		if err := s.drain.write(c, w.Bytes()); err != nil {
+			log.Println("Async write error:", err)
+		}
+	}
+}
+
+// blocks 判断命令是否可能阻塞事件循环：读取的分组未命中时会调用回源函数
+func (s *RespServer) blocks(rc *respConn, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "get", "mget":
+		return loads(rc.selected())
+	}
+	return isWrite(respCommands[name].command) && remote(s.store)
+}
+
+// serveAsync 在 goroutine 中依次执行 commands，等前一批执行完后才开始，保证响应的顺序。
+// protoErr 不为 nil 时最后回复协议错误并关闭连接。
+func (s *RespServer) serveAsync(c gnet.Conn, rc *respConn, commands [][][]byte, protoErr error) {
+	prev := rc.tail
+	done := make(chan struct{})
+	rc.tail = done
+	s.drain.pending.Add(1)
+	go func() {
+		defer s.drain.pending.Add(-1)
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		w := &respWriter{}
+		closing := false
+		for _, args := range commands {
+			w.proto = rc.proto
+			if closing = s.execute(rc, w, args); closing {
+				break
+			}
+		}
+		if protoErr != nil && !closing {
+			w.proto = rc.proto
+			w.error("ERR " + protoErr.Error())
+			closing = true
+		}
+		s.reply(c, w)
+		if closing {
+			c.CloseWithCallback(nil)
+		}
+	}()
+}
+
+// parseRESP 解析 buf 开头的一条命令，支持多条批量回复格式和 inline 格式。
+// 命令不完整时返回的消耗字节数为 0；参数引用 buf，存储前需要拷贝。参数长度不能超过 maxBulk。
+func parseRESP(buf []byte, maxBulk int) (args [][]byte, n int, err error) {
+	if buf[0] != '*' {
+		end := bytes.IndexByte(buf, '\n')
+		if end < 0 {
+			if len(buf) > respMaxInline {
+				return nil, 0, fmt.Errorf("%w: too big inline request", errRESPProtocol)
+			}
+			return nil, 0, nil
+		}
+		for _, f := range bytes.Fields(buf[:end]) {
+			args = append(args, f)
+		}
+		return args, end + 1, nil
+	}
+
+	count, pos, err := respLength(buf, 0)
+	if err != nil || pos == 0 {
+		return nil, 0, err
+	}
+	if count > respMaxMultibulk {
+		return nil, 0, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	args = make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if pos >= len(buf) {
+			return nil, 0, nil
+		}
+		if buf[pos] != '$' {
+			return nil, 0, fmt.Errorf("%w: expected '$', got '%c'", errRESPProtocol, buf[pos])
+		}
+		size, next, err := respLength(buf, pos)
+		if err != nil || next == 0 {
+			return nil, 0, err
+		}
+		if size > maxBulk {
+			return nil, 0, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		if len(buf) < next+size+2 {
+			return nil, 0, nil
+		}
+		args = append(args, buf[next:next+size])
+		pos = next + size + 2
+	}
+	return args, pos, nil
+}
+
+// respLength 解析 buf[pos:] 处形如 "*3\r\n" 或 "$5\r\n" 的长度行，返回长度和下一行的位置，
+// 行不完整时位置为 0
+func respLength(buf []byte, pos int) (int, int, error) {
+	end := bytes.Index(buf[pos:], []byte("\r\n"))
+	if end < 0 {
+		if len(buf)-pos > respMaxInline {
+			return 0, 0, fmt.Errorf("%w: too big length line", errRESPProtocol)
+		}
+		return 0, 0, nil
+	}
+	n, err := strconv.Atoi(string(buf[pos+1 : pos+end]))
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("%w: invalid length", errRESPProtocol)
+	}
+	return n, pos + end + 2, nil
+}
+
+// respWriter 按连接协商的协议版本编码回复
+type respWriter struct {
+	bytes.Buffer
+	proto int
+}
+
+func (w *respWriter) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) error(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w *respWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+func (w *respWriter) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// mapHeader 在 RESP2 中以键值交替的数组表示
+func (w *respWriter) mapHeader(n int) {
+	if w.proto == 3 {
+		w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.array(2 * n)
+}
+
+// respCommand 描述一个命令：arity 为正数时参数个数（含命令名）必须相等，
+// 为负数时至少为其绝对值。开启 ACL 时用户需要有 Bluebell 命令 command 对当前
+// 分组的权限，node 为 true 时需要对所有分组的权限，command 为空时不检查
+type respCommand struct {
+	arity   int
+	command string
+	node    bool
+	handler func(s *RespServer, rc *respConn, w *respWriter, args [][]byte) (quit bool)
+}
+
+var respCommands map[string]respCommand
+
+func init() {
+	respCommands = map[string]respCommand{
+		"ping":     {-1, "", false, (*RespServer).ping},
+		"echo":     {2, "", false, (*RespServer).echo},
+		"quit":     {1, "", false, (*RespServer).quit},
+		"hello":    {-1, "", false, (*RespServer).hello},
+		"auth":     {-2, "", false, (*RespServer).auth},
+		"select":   {2, "", false, (*RespServer).selectGroup},
+		"client":   {-2, "", false, (*RespServer).client},
+		"info":     {-1, huacache.STATS, true, (*RespServer).info},
+		"command":  {-1, "", false, (*RespServer).command},
+		"get":      {2, huacache.GET_KEY, false, (*RespServer).get},
+		"set":      {-3, huacache.SET_KEY, false, (*RespServer).set},
+		"del":      {-2, huacache.DEL_KEY, false, (*RespServer).del},
+		"exists":   {-2, huacache.GET_KEY, false, (*RespServer).exists},
+		"mget":     {-2, huacache.MGET_KEYS, false, (*RespServer).mget},
+		"mset":     {-3, huacache.MSET_KEYS, false, (*RespServer).mset},
+		"incr":     {2, huacache.INCR, false, (*RespServer).incr},
+		"decr":     {2, huacache.DECR, false, (*RespServer).incr},
+		"incrby":   {3, huacache.INCR, false, (*RespServer).incr},
+		"decrby":   {3, huacache.DECR, false, (*RespServer).incr},
+		"expire":   {3, huacache.SET_KEY, false, (*RespServer).expire},
+		"pexpire":  {3, huacache.SET_KEY, false, (*RespServer).expire},
+		"ttl":      {2, huacache.GET_KEY, false, (*RespServer).ttl},
+		"pttl":     {2, huacache.GET_KEY, false, (*RespServer).ttl},
+		"scan":     {-2, huacache.GET_KEYS, false, (*RespServer).scan},
+		"dbsize":   {1, huacache.STATS, false, (*RespServer).dbsize},
+		"flushdb":  {-1, huacache.DEL_GROUP, false, (*RespServer).flushdb},
+		"flushall": {-1, huacache.DEL_GROUP, true, (*RespServer).flushall},
+	}
+}
+
+// execute 执行一条命令，返回是否需要关闭连接
+func (s *RespServer) execute(rc *respConn, w *respWriter, args [][]byte) bool {
+	s.commands.Add(1)
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := respCommands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	if s.acl != nil {
+		switch {
+		case rc.user == nil && name != "auth" && name != "hello" && name != "quit":
+			w.error("NOAUTH Authentication required.")
+			return false
+		case cmd.command != "":
+			group := rc.selected()
+			if cmd.node {
+				group = ""
+			}
+			if !rc.user.Can(group, huacache.RequiredPermission(cmd.command)) {
+				w.error(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", rc.user.Name, name))
+				return false
+			}
+		}
+	}
+	return cmd.handler(s, rc, w, args)
+}
+
+// authenticate 检查用户名和密码。Redis 客户端只有密码时使用 default 用户，
+// 此时按令牌认证
+func (s *RespServer) authenticate(name, secret string) (*huacache.User, error) {
+	user, err := s.acl.Authenticate(name, secret)
+	if err != nil && (name == "" || name == "default") {
+		user, err = s.acl.Authenticate("", secret)
+	}
+	return user, err
+}
+
+// auth 处理 AUTH [username] password
+func (s *RespServer) auth(rc *respConn, w *respWriter, args [][]byte) bool {
+	if len(args) > 3 {
+		w.error("ERR syntax error")
+		return false
+	}
+	if s.acl == nil {
+		w.error("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return false
+	}
+	name := ""
+	if len(args) == 3 {
+		name = string(args[1])
+	}
+	user, err := s.authenticate(name, string(args[len(args)-1]))
+	if err != nil {
+		w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	rc.user = user
+	w.simple("OK")
+	return false
+}
+
+// group 返回连接当前选择的分组，分组不存在时写出错误并返回 nil
+func (s *RespServer) group(rc *respConn, w *respWriter) *huacache.Group {
+	name := rc.selected()
+	g, err := huacache.GetGroup(name)
+	if err != nil {
+		w.error(fmt.Sprintf("ERR group '%s' not found", name))
+		return nil
+	}
+	return g
+}
+
+// writeError 将缓存的错误转换为 RESP 错误
+func writeError(w *respWriter, err error) {
+	var loadErr *huacache.LoadError
+	switch {
+	case errors.Is(err, lru.ErrTooLarge):
+		w.error("ERR value exceeds the group capacity")
+	case errors.As(err, &loadErr):
+		w.error("ERR failed to load key: " + loadErr.Err.Error())
+	default:
+		w.error("ERR " + err.Error())
+	}
+}
+
+func (s *RespServer) ping(rc *respConn, w *respWriter, args [][]byte) bool {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		w.error("ERR wrong number of arguments for 'ping' command")
+	}
+	return false
+}
+
+func (s *RespServer) echo(rc *respConn, w *respWriter, args [][]byte) bool {
+	w.bulk(args[1])
+	return false
+}
+
+func (s *RespServer) quit(rc *respConn, w *respWriter, args [][]byte) bool {
+	w.simple("OK")
+	return true
+}
+
+// hello 处理 HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (s *RespServer) hello(rc *respConn, w *respWriter, args [][]byte) bool {
+	proto := rc.proto
+	if len(args) > 1 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			w.error("ERR Protocol version is not an integer or out of range")
+			return false
+		}
+		if v != 2 && v != 3 {
+			w.error("NOPROTO unsupported protocol version")
+			return false
+		}
+		proto = v
+	}
+	name, rename := "", false
+	var user *huacache.User
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "auth" && i+2 < len(args):
+			if s.acl == nil {
+				w.error("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+				return false
+			}
+			var err error
+			if user, err = s.authenticate(string(args[i+1]), string(args[i+2])); err != nil {
+				w.error("WRONGPASS invalid username-password pair or user is disabled.")
+				return false
+			}
+			i += 2
+		case opt == "setname" && i+1 < len(args):
+			name, rename = string(args[i+1]), true
+			i++
+		default:
+			w.error(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+			return false
+		}
+	}
+	if user != nil {
+		rc.user = user
+	}
+	if s.acl != nil && rc.user == nil {
+		w.error("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return false
+	}
+	rc.proto = proto
+	w.proto = proto
+	if rename {
+		rc.mu.Lock()
+		rc.name = name
+		rc.mu.Unlock()
+	}
+
+	w.mapHeader(7)
+	w.bulkString("server")
+	w.bulkString("huacache")
+	w.bulkString("version")
+	w.bulkString(huacache.VERSION)
+	w.bulkString("proto")
+	w.integer(int64(proto))
+	w.bulkString("id")
+	w.integer(rc.id)
+	w.bulkString("mode")
+	w.bulkString("standalone")
+	w.bulkString("role")
+	w.bulkString(s.role("master", "replica"))
+	w.bulkString("modules")
+	w.array(0)
+	return false
+}
+
+func (s *RespServer) selectGroup(rc *respConn, w *respWriter, args [][]byte) bool {
+	name := string(args[1])
+	if _, err := huacache.GetGroup(name); err != nil {
+		w.error(fmt.Sprintf("ERR group '%s' not found", name))
+		return false
+	}
+	if s.acl != nil && !rc.user.Can(name, huacache.PermRead) {
+		w.error(fmt.Sprintf("NOPERM User %s has no permissions to access the '%s' group", rc.user.Name, name))
+		return false
+	}
+	rc.mu.Lock()
+	rc.group = name
+	rc.mu.Unlock()
+	w.simple("OK")
+	return false
+}
+
+// client 处理 CLIENT ID|SETNAME|GETNAME|SETINFO|LIST|INFO
+func (s *RespServer) client(rc *respConn, w *respWriter, args [][]byte) bool {
+	sub := strings.ToLower(string(args[1]))
+	switch {
+	case sub == "id" && len(args) == 2:
+		w.integer(rc.id)
+	case sub == "setname" && len(args) == 3:
+		if bytes.ContainsAny(args[2], " \n") {
+			w.error("ERR Client names cannot contain spaces, newlines or special characters.")
+			break
+		}
+		rc.mu.Lock()
+		rc.name = string(args[2])
+		rc.mu.Unlock()
+		w.simple("OK")
+	case sub == "getname" && len(args) == 2:
+		rc.mu.Lock()
+		name := rc.name
+		rc.mu.Unlock()
+		if name == "" {
+			w.null()
+		} else {
+			w.bulkString(name)
+		}
+	case sub == "setinfo" && len(args) == 4:
+		// 客户端库上报的名称和版本，仅确认
+		w.simple("OK")
+	case sub == "info" && len(args) == 2:
+		w.bulkString(clientLine(rc))
+	case sub == "list" && len(args) == 2:
+		var conns []*respConn
+		s.conns.Range(func(_, v any) bool {
+			conns = append(conns, v.(*respConn))
+			return true
+		})
+		sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+		var b strings.Builder
+		for _, c := range conns {
+			b.WriteString(clientLine(c))
+		}
+		w.bulkString(b.String())
+	default:
+		w.error(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[1]))
+	}
+	return false
+}
+
+func clientLine(rc *respConn) string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return fmt.Sprintf("id=%d addr=%s name=%s db=%s resp=%d\n", rc.id, rc.addr, rc.name, rc.group, rc.proto)
+}
+
+// info 返回 server、clients、stats 和 keyspace 信息，每个分组作为一个 keyspace
+func (s *RespServer) info(rc *respConn, w *respWriter, args [][]byte) bool {
+	clients := 0
+	s.conns.Range(func(_, _ any) bool {
+		clients++
+		return true
+	})
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(&b, "huacache_version:%s\r\n", huacache.VERSION)
+	fmt.Fprintf(&b, "redis_mode:standalone\r\n")
+	fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(&b, "tcp_port:%s\r\n", s.Addr[strings.LastIndex(s.Addr, ":")+1:])
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.started).Seconds()))
+	b.WriteString("\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", clients)
+	b.WriteString("\r\n# Replication\r\n")
+	fmt.Fprintf(&b, "role:%s\r\n", s.role("master", "slave"))
+	b.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", s.commands.Load())
+	b.WriteString("\r\n# Keyspace\r\n")
+	names, _ := huacache.ListGroups()
+	sort.Strings(names)
+	for _, name := range names {
+		g, err := huacache.GetGroup(name)
+		if err != nil {
+			continue
+		}
+		_, used, keys := g.MemoryUsage()
+		fmt.Fprintf(&b, "%s:keys=%d,bytes=%d\r\n", name, keys, used)
+	}
+	w.bulkString(b.String())
+	return false
+}
+
+// role 按节点是否为副本返回 primary 或 replica，HELLO 和 INFO 对副本的称呼不同
+func (s *RespServer) role(primary, replica string) string {
+	if replicated(s.store) {
+		return replica
+	}
+	return primary
+}
+
+// command 不提供命令元数据，返回空数组即可让 redis-cli 等客户端正常工作
+func (s *RespServer) command(rc *respConn, w *respWriter, args [][]byte) bool {
+	w.array(0)
+	return false
+}
+
+func (s *RespServer) get(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	v, err := g.Get(string(args[1]))
+	switch {
+	case err == nil:
+		w.bulk(v.B)
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		w.null()
+	default:
+		writeError(w, err)
+	}
+	return false
+}
+
+// errSetAborted 表示 NX/XX 条件不满足
+var errSetAborted = errors.New("set aborted")
+
+// set 处理 SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix|PXAT unix-ms|KEEPTTL]
+func (s *RespServer) set(rc *respConn, w *respWriter, args [][]byte) bool {
+	var nx, xx, get, keepTTL, expiry bool
+	var ttl time.Duration
+	var at time.Time // EXAT、PXAT 指定的绝对时间
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry || i+1 >= len(args) {
+				w.error("ERR syntax error")
+				return false
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return false
+			}
+			if n <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return false
+			}
+			expiry = true
+			switch opt {
+			case "EX":
+				ttl = time.Duration(n) * time.Second
+			case "PX":
+				ttl = time.Duration(n) * time.Millisecond
+			case "EXAT":
+				at = time.Unix(n, 0)
+			case "PXAT":
+				at = time.UnixMilli(n)
+			}
+		default:
+			w.error("ERR syntax error")
+			return false
+		}
+	}
+	if (nx && xx) || (keepTTL && expiry) {
+		w.error("ERR syntax error")
+		return false
+	}
+
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	// 与 Group.Set 一样套用分组的默认 ttl 和 stale 时长，绝对时间是 soft ttl 到期的时间，
+	// 已经过去时立即 stale。item 记录 ttl，后台刷新 stale 的值时沿用
+	if !at.IsZero() {
+		ttl = max(time.Until(at), time.Nanosecond)
+	}
+	value := huacache.ByteView{B: bytes.Clone(args[2])}
+	item := g.NewItem(value, ttl)
+	var old huacache.Item
+	var existed bool
+	_, err := s.store.Update(g.Name(), string(args[1]), func(o huacache.Item, ok bool) (huacache.Item, error) {
+		old, existed = o, ok
+		if (nx && ok) || (xx && !ok) {
+			return huacache.Item{}, errSetAborted
+		}
+		if keepTTL && ok {
+			o.Value = value
+			return o, nil
+		}
+		return item, nil
+	})
+	switch {
+	case err != nil && !errors.Is(err, errSetAborted):
+		writeError(w, err)
+	case get && existed:
+		w.bulk(old.Value.B)
+	case get, err != nil:
+		w.null()
+	default:
+		w.simple("OK")
+	}
+	return false
+}
+
+func (s *RespServer) del(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	var n int64
+	for _, key := range args[1:] {
+		err := s.store.Delete(g.Name(), string(key))
+		switch {
+		case err == nil:
+			n++
+		case !errors.Is(err, huacache.ErrKeyNotFound):
+			writeError(w, err)
+			return false
+		}
+	}
+	w.integer(n)
+	return false
+}
+
+func (s *RespServer) exists(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	var n int64
+	for _, key := range args[1:] {
+		if _, ok := g.Peek(string(key)); ok {
+			n++
+		}
+	}
+	w.integer(n)
+	return false
+}
+
+func (s *RespServer) mget(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	w.array(len(args) - 1)
+	for _, key := range args[1:] {
+		if v, err := g.Get(string(key)); err == nil {
+			w.bulk(v.B)
+		} else {
+			w.null()
+		}
+	}
+	return false
+}
+
+func (s *RespServer) mset(rc *respConn, w *respWriter, args [][]byte) bool {
+	if len(args)%2 != 1 {
+		w.error("ERR wrong number of arguments for 'mset' command")
+		return false
+	}
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	for i := 1; i < len(args); i += 2 {
+		item := g.NewItem(huacache.ByteView{B: bytes.Clone(args[i+1])}, 0)
+		_, err := s.store.Update(g.Name(), string(args[i]), func(huacache.Item, bool) (huacache.Item, error) {
+			return item, nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return false
+		}
+	}
+	w.simple("OK")
+	return false
+}
+
+// incr 处理 INCR key、DECR key、INCRBY key increment 和 DECRBY key decrement
+func (s *RespServer) incr(rc *respConn, w *respWriter, args [][]byte) bool {
+	delta := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			w.error("ERR value is not an integer or out of range")
+			return false
+		}
+		delta = n
+	}
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	if !strings.HasPrefix(strings.ToLower(string(args[0])), "incr") {
+		if delta == math.MinInt64 {
+			w.error("ERR decrement would overflow")
+			return false
+		}
+		delta = -delta
+	}
+	var n int64
+	_, err := s.store.Update(g.Name(), string(args[1]), func(old huacache.Item, ok bool) (huacache.Item, error) {
+		n = 0
+		if ok {
+			v, err := strconv.ParseInt(old.Value.String(), 10, 64)
+			if err != nil {
+				return huacache.Item{}, huacache.ErrNotInteger
+			}
+			n = v
+		} else {
+			old = g.NewItem(huacache.ByteView{}, 0)
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return huacache.Item{}, huacache.ErrOverflow
+		}
+		n += delta
+		old.Value = huacache.ByteView{B: strconv.AppendInt(nil, n, 10), Flags: old.Value.Flags}
+		return old, nil
+	})
+	switch {
+	case err == nil:
+		w.integer(n)
+	case errors.Is(err, huacache.ErrNotInteger):
+		w.error("ERR value is not an integer or out of range")
+	case errors.Is(err, huacache.ErrOverflow):
+		w.error("ERR increment or decrement would overflow")
+	default:
+		writeError(w, err)
+	}
+	return false
+}
+
+// expire 处理 EXPIRE key seconds 和 PEXPIRE key milliseconds，非正数的过期时间会删除 key
+func (s *RespServer) expire(rc *respConn, w *respWriter, args [][]byte) bool {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return false
+	}
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	ttl := time.Duration(n) * time.Millisecond
+	if strings.EqualFold(string(args[0]), "expire") {
+		ttl = time.Duration(n) * time.Second
+	}
+	key := string(args[1])
+	if ttl <= 0 {
+		err = s.store.Delete(g.Name(), key)
+	} else {
+		_, err = s.store.Update(g.Name(), key, func(old huacache.Item, ok bool) (huacache.Item, error) {
+			if !ok {
+				return huacache.Item{}, huacache.ErrKeyNotFound
+			}
+			return g.NewItem(old.Value, ttl), nil
+		})
+	}
+	switch {
+	case err == nil:
+		w.integer(1)
+	case errors.Is(err, huacache.ErrKeyNotFound):
+		w.integer(0)
+	default:
+		writeError(w, err)
+	}
+	return false
+}
+
+// ttl 处理 TTL 和 PTTL：key 不存在返回 -2，没有过期时间返回 -1
+func (s *RespServer) ttl(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	item, ok := g.Peek(string(args[1]))
+	switch {
+	case !ok:
+		w.integer(-2)
+	case item.ExpireAt.IsZero():
+		w.integer(-1)
+	case strings.EqualFold(string(args[0]), "ttl"):
+		w.integer(int64((item.TTL() + 500*time.Millisecond) / time.Second))
+	default:
+		w.integer(item.TTL().Milliseconds())
+	}
+	return false
+}
+
+// scan 处理 SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]，
+// 游标与 Group.Scan 相同，可以跨越所有分片。
+func (s *RespServer) scan(rc *respConn, w *respWriter, args [][]byte) bool {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return false
+	}
+	pattern, count, typeMatch := "", huacache.DefaultScanCount, true
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error("ERR syntax error")
+			return false
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				w.error("ERR syntax error")
+				return false
+			}
+		case "TYPE":
+			// 所有值都是字符串
+			typeMatch = strings.EqualFold(string(args[i+1]), "string")
+		default:
+			w.error("ERR syntax error")
+			return false
+		}
+	}
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+
+	var keys []string
+	next := uint64(0)
+	if typeMatch {
+		if keys, next, err = g.Scan(cursor, pattern, count); err != nil {
+			w.error("ERR invalid cursor")
+			return false
+		}
+	}
+	w.array(2)
+	w.bulkString(strconv.FormatUint(next, 10))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulkString(key)
+	}
+	return false
+}
+
+func (s *RespServer) dbsize(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	_, _, keys := g.MemoryUsage()
+	w.integer(int64(keys))
+	return false
+}
+
+func (s *RespServer) flushdb(rc *respConn, w *respWriter, args [][]byte) bool {
+	g := s.group(rc, w)
+	if g == nil {
+		return false
+	}
+	if err := s.store.Flush(g.Name()); err != nil {
+		writeError(w, err)
+		return false
+	}
+	w.simple("OK")
+	return false
+}
+
+func (s *RespServer) flushall(rc *respConn, w *respWriter, args [][]byte) bool {
+	names, _ := huacache.ListGroups()
+	for _, name := range names {
+		if err := s.store.Flush(name); err != nil && !errors.Is(err, huacache.ErrGroupNotFound) {
+			writeError(w, err)
+			return false
+		}
+	}
+	w.simple("OK")
+	return false
+}
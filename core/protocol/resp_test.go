@@ -0,0 +1,383 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// respClient 以多条批量回复格式发送命令，并把回复读成字符串便于比较
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *respClient) do(args ...string) string {
+	c.t.Helper()
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("write failed: %v", err)
+	}
+	return c.read()
+}
+
+// read 读取一个回复，数组和 map 的元素以空格连接，例如 "[a b]"
+func (c *respClient) read() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read failed: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)"
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatalf("read failed: %v", err)
+		}
+		return string(data[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case '_':
+		return "(nil)"
+	}
+	return line
+}
+
+func startResp(t *testing.T, group string) *respClient {
+	if _, err := huacache.GetGroup(group); err != nil {
+		if _, err := huacache.NewGroup(group, 8*huacache.MB); err != nil {
+			t.Fatalf("create group failed: %v", err)
+		}
+		t.Cleanup(func() { huacache.DelGroup(group) })
+	}
+	return startRespServer(t, NewRespServer("tcp", freeAddr(t), false, group))
+}
+
+// startRespServer 启动 s 并返回连接到它的客户端
+func startRespServer(t *testing.T, s *RespServer) *respClient {
+	runEngine(t, s, s.Network, s.Addr)
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func expectReply(t *testing.T, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expect %q, got %q", want, got)
+	}
+}
+
+func TestRespStrings(t *testing.T) {
+	c := startResp(t, "resp_strings")
+
+	expectReply(t, c.do("PING"), "+PONG")
+	expectReply(t, c.do("SET", "k", "v"), "+OK")
+	expectReply(t, c.do("GET", "k"), "v")
+	expectReply(t, c.do("GET", "missing"), "(nil)")
+	expectReply(t, c.do("SET", "k", "x", "NX"), "(nil)")
+	expectReply(t, c.do("SET", "other", "x", "XX"), "(nil)")
+	expectReply(t, c.do("SET", "k", "v2", "XX", "GET"), "v")
+	expectReply(t, c.do("SET", "k", "v", "NX", "XX"), "-ERR syntax error")
+
+	expectReply(t, c.do("MSET", "a", "1", "b", "2"), "+OK")
+	expectReply(t, c.do("MGET", "a", "missing", "b"), "[1 (nil) 2]")
+	expectReply(t, c.do("EXISTS", "a", "b", "missing", "a"), ":3")
+	expectReply(t, c.do("DEL", "a", "missing"), ":1")
+	expectReply(t, c.do("DBSIZE"), ":2")
+	expectReply(t, c.do("get"), "-ERR wrong number of arguments for 'get' command")
+	expectReply(t, c.do("NOPE"), "-ERR unknown command 'NOPE'")
+}
+
+func TestRespExpire(t *testing.T) {
+	c := startResp(t, "resp_expire")
+
+	expectReply(t, c.do("SET", "k", "v", "EX", "100"), "+OK")
+	expectReply(t, c.do("TTL", "k"), ":100")
+	expectReply(t, c.do("SET", "k", "v2", "KEEPTTL"), "+OK")
+	expectReply(t, c.do("TTL", "k"), ":100")
+	expectReply(t, c.do("SET", "k", "v"), "+OK")
+	expectReply(t, c.do("TTL", "k"), ":-1")
+	expectReply(t, c.do("TTL", "missing"), ":-2")
+	expectReply(t, c.do("EXPIRE", "k", "10"), ":1")
+	expectReply(t, c.do("EXPIRE", "missing", "10"), ":0")
+	expectReply(t, c.do("PEXPIRE", "k", "20"), ":1")
+	time.Sleep(40 * time.Millisecond)
+	expectReply(t, c.do("GET", "k"), "(nil)")
+	expectReply(t, c.do("SET", "k", "v", "PX", "0"), "-ERR invalid expire time in 'set' command")
+}
+
+func TestRespGroupTTL(t *testing.T) {
+	huacache.DelGroup("resp_ttl")
+	if _, err := huacache.NewGroup("resp_ttl", 8*huacache.MB, huacache.WithSoftTTL(time.Minute, 3*time.Minute)); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer huacache.DelGroup("resp_ttl")
+	c := startResp(t, "resp_ttl")
+
+	// SET 与 Group.Set 一样使用分组的默认 ttl，并加上 stale 的时长
+	expectReply(t, c.do("SET", "k", "v"), "+OK")
+	expectReply(t, c.do("TTL", "k"), ":180")
+	expectReply(t, c.do("SET", "k", "v", "EX", "100"), "+OK")
+	expectReply(t, c.do("TTL", "k"), ":220")
+	at := strconv.FormatInt(time.Now().Add(10*time.Second).Unix(), 10)
+	expectReply(t, c.do("SET", "k", "v", "EXAT", at), "+OK")
+	if ttl := c.do("TTL", "k"); ttl < ":128" || ttl > ":131" {
+		t.Fatalf("expect a ttl of about 130s, got %s", ttl)
+	}
+}
+
+func TestRespStaleRefreshKeepsTTL(t *testing.T) {
+	huacache.DelGroup("resp_refresh")
+	_, err := huacache.NewGroup("resp_refresh", 8*huacache.MB, huacache.WithSoftTTL(time.Hour, time.Hour+time.Minute),
+		huacache.WithGetter(huacache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte("fresh"), nil
+		})))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer huacache.DelGroup("resp_refresh")
+	c := startResp(t, "resp_refresh")
+
+	// 后台刷新沿用 SET 指定的 ttl，而不是分组的默认 ttl
+	expectReply(t, c.do("SET", "k", "v", "PX", "50"), "+OK")
+	expectReply(t, c.do("SET", "kept", "v", "PX", "50"), "+OK")
+	expectReply(t, c.do("SET", "kept", "w", "KEEPTTL"), "+OK")
+	time.Sleep(100 * time.Millisecond)
+	for _, key := range []string{"k", "kept"} {
+		for i := 0; c.do("GET", key) != "fresh"; i++ {
+			if i == 100 {
+				t.Fatalf("stale %s not refreshed", key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		pttl, _ := strconv.Atoi(strings.TrimPrefix(c.do("PTTL", key), ":"))
+		if pttl <= 0 || pttl > 60050 {
+			t.Fatalf("expect %s to live 50ms plus the stale window, got %dms", key, pttl)
+		}
+	}
+}
+
+func TestRespCounters(t *testing.T) {
+	c := startResp(t, "resp_counters")
+
+	expectReply(t, c.do("INCR", "n"), ":1")
+	expectReply(t, c.do("INCRBY", "n", "9"), ":10")
+	expectReply(t, c.do("DECRBY", "n", "15"), ":-5")
+	expectReply(t, c.do("DECR", "n"), ":-6")
+	expectReply(t, c.do("GET", "n"), "-6")
+	expectReply(t, c.do("SET", "k", "v"), "+OK")
+	expectReply(t, c.do("INCR", "k"), "-ERR value is not an integer or out of range")
+	expectReply(t, c.do("INCRBY", "n", "x"), "-ERR value is not an integer or out of range")
+	expectReply(t, c.do("SET", "max", "9223372036854775807"), "+OK")
+	expectReply(t, c.do("INCR", "max"), "-ERR increment or decrement would overflow")
+}
+
+func TestRespSelectAndScan(t *testing.T) {
+	c := startResp(t, "resp_scan")
+	huacache.NewGroup("1", 8*huacache.MB)
+	defer huacache.DelGroup("1")
+
+	for i := 0; i < 50; i++ {
+		c.do("SET", "user:"+strconv.Itoa(i), "v")
+	}
+	c.do("SET", "other", "v")
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "10")
+		fields := strings.Fields(strings.NewReplacer("[", " ", "]", " ").Replace(reply))
+		cursor = fields[0]
+		for _, key := range fields[1:] {
+			seen[key] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 50 || seen["other"] {
+		t.Fatalf("expect 50 user keys from scan, got %d", len(seen))
+	}
+
+	expectReply(t, c.do("SELECT", "1"), "+OK")
+	expectReply(t, c.do("GET", "other"), "(nil)")
+	expectReply(t, c.do("SELECT", "nope"), "-ERR group 'nope' not found")
+	expectReply(t, c.do("CLIENT", "SETNAME", "tester"), "+OK")
+	expectReply(t, c.do("CLIENT", "GETNAME"), "tester")
+}
+
+func TestRespHello(t *testing.T) {
+	c := startResp(t, "resp_hello")
+
+	expectReply(t, c.do("HELLO", "4"), "-NOPROTO unsupported protocol version")
+	reply := c.do("HELLO", "3", "SETNAME", "app")
+	if !strings.HasPrefix(reply, "[server huacache version "+huacache.VERSION+" proto :3 ") || !strings.Contains(reply, " role master ") {
+		t.Fatalf("unexpected hello reply %q", reply)
+	}
+	// RESP3 下空值编码为 "_"
+	c.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n"))
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, _ := c.r.ReadString('\n')
+	expectReply(t, line, "_\r\n")
+	expectReply(t, c.do("CLIENT", "GETNAME"), "app")
+
+	// inline 命令
+	c.conn.Write([]byte("PING\r\n"))
+	expectReply(t, c.read(), "+PONG")
+}
+
+func TestRespReplicaRole(t *testing.T) {
+	if _, err := huacache.GetGroup("resp_role"); err != nil {
+		huacache.NewGroup("resp_role", 8*huacache.MB)
+		defer huacache.DelGroup("resp_role")
+	}
+	replica := NewBluebellServer("tcp", freeAddr(t), false)
+	replica.ReplicaOf("127.0.0.1:1")
+	s := NewRespServer("tcp", freeAddr(t), false, "resp_role")
+	s.SetStore(replica.Store())
+	c := startRespServer(t, s)
+
+	if reply := c.do("HELLO", "3"); !strings.Contains(reply, " role replica ") {
+		t.Fatalf("expect role replica, got %q", reply)
+	}
+	if reply := c.do("INFO"); !strings.Contains(reply, "role:slave\r\n") {
+		t.Fatalf("expect role:slave, got %q", reply)
+	}
+}
+
+func TestRespAuth(t *testing.T) {
+	for _, name := range []string{"auth-resp", "other"} {
+		huacache.DelGroup(name)
+		if _, err := huacache.NewGroup(name, 1<<20); err != nil {
+			t.Fatalf("create group failed: %v", err)
+		}
+		defer huacache.DelGroup(name)
+	}
+	s := NewRespServer("tcp", freeAddr(t), false, "auth-resp")
+	s.SetACL(newTestACL(t))
+	c := startRespServer(t, s)
+
+	expectReply(t, c.do("GET", "k"), "-NOAUTH Authentication required.")
+	expectReply(t, c.do("AUTH", "app", "wrong"), "-WRONGPASS invalid username-password pair or user is disabled.")
+	if reply := c.do("HELLO", "3"); !strings.HasPrefix(reply, "-NOAUTH") {
+		t.Fatalf("expect hello without auth to be refused, got %q", reply)
+	}
+	if reply := c.do("HELLO", "2", "AUTH", "app", "pw"); !strings.HasPrefix(reply, "[server huacache") {
+		t.Fatalf("expect hello to authenticate, got %q", reply)
+	}
+	expectReply(t, c.do("SET", "k", "v"), "+OK")
+	expectReply(t, c.do("GET", "k"), "v")
+	expectReply(t, c.do("FLUSHDB"), "-NOPERM User app has no permissions to run the 'flushdb' command")
+	expectReply(t, c.do("FLUSHALL"), "-NOPERM User app has no permissions to run the 'flushall' command")
+	expectReply(t, c.do("SELECT", "other"), "-NOPERM User app has no permissions to access the 'other' group")
+
+	// 只有密码时按令牌认证
+	expectReply(t, c.do("AUTH", "t0ken"), "+OK")
+	expectReply(t, c.do("SELECT", "other"), "+OK")
+	expectReply(t, c.do("FLUSHDB"), "+OK")
+
+	open := startResp(t, "auth-resp")
+	expectReply(t, open.do("AUTH", "pw"), "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	if reply := open.do("HELLO", "3", "AUTH", "app", "pw"); !strings.HasPrefix(reply, "-ERR AUTH") {
+		t.Fatalf("expect hello auth to fail without an acl, got %q", reply)
+	}
+}
+
+func TestRespWritesThroughBluebell(t *testing.T) {
+	huacache.DelGroup("resp_bluebell")
+	huacache.NewGroup("resp_bluebell", 8*huacache.MB)
+	defer huacache.DelGroup("resp_bluebell")
+	group, _ := huacache.GetGroup("resp_bluebell")
+
+	// 两个节点组成集群，各自记录 AOF
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	nodes := make([]*BluebellServer, len(addrs))
+	paths := make([]string, len(addrs))
+	aofs := make([]*AOF, len(addrs))
+	var cluster *Cluster
+	for i, addr := range addrs {
+		nodes[i] = NewBluebellServer("tcp", addr, false)
+		c := NewCluster(addr, addrs...)
+		nodes[i].SetCluster(c)
+		if i == 0 {
+			cluster = c
+		}
+		paths[i] = filepath.Join(t.TempDir(), "appendonly.aof")
+		aof, err := OpenAOF(paths[i], FsyncNo)
+		if err != nil {
+			t.Fatalf("open aof failed: %v", err)
+		}
+		aofs[i] = aof
+		nodes[i].SetAOF(aof)
+		startServer(t, nodes[i])
+	}
+	// local 属于第一个节点，remote 属于第二个
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, ok := cluster.Owner(key); ok {
+			remote = key
+		} else {
+			local = key
+		}
+	}
+
+	s := NewRespServer("tcp", freeAddr(t), false, "resp_bluebell")
+	s.SetStore(nodes[0].Store())
+	c := startRespServer(t, s)
+	expectReply(t, c.do("FLUSHDB"), "+OK")
+	for _, key := range []string{local, remote} {
+		expectReply(t, c.do("SET", key, "1", "EX", "100"), "+OK")
+		expectReply(t, c.do("INCRBY", key, "41"), ":42")
+		expectReply(t, c.do("SET", key+"-gone", "x"), "+OK")
+		expectReply(t, c.do("DEL", key+"-gone", "missing"), ":1")
+		expectReply(t, c.do("GET", key), "42")
+	}
+
+	// 每个 key 的写入只记录在其所属节点的 AOF 中
+	for i, owned := range []string{local, remote} {
+		aofs[i].Close()
+		group.Flush()
+		aof, err := OpenAOF(paths[i], FsyncNo)
+		if err != nil {
+			t.Fatalf("replay aof failed: %v", err)
+		}
+		aof.Close()
+		// AOF 开头的快照会重建分组
+		group, _ = huacache.GetGroup("resp_bluebell")
+		_, _, keys := group.MemoryUsage()
+		item, ok := group.Peek(owned)
+		if keys != 1 || !ok || item.Value.String() != "42" || item.ExpireAt.IsZero() {
+			t.Fatalf("node %d replayed %d keys, %s is %+v", i, keys, owned, item)
+		}
+	}
+}
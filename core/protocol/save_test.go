@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestSaveOffLoop(t *testing.T) {
+	huacache.DelGroup("save")
+	g, _ := huacache.NewGroup("save", 8*huacache.MB)
+	defer huacache.DelGroup("save")
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := g.AddOrUpdate(key, huacache.ByteView{B: bytes.Repeat([]byte("v"), 256<<10)}); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	// 快照的临时文件是一个没有读者的管道，写满缓冲区后 SAVE 一直阻塞
+	path := filepath.Join(t.TempDir(), "dump.snapshot")
+	if err := syscall.Mkfifo(path+".tmp", 0600); err != nil {
+		t.Skipf("mkfifo failed: %v", err)
+	}
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetSnapshotter(huacache.NewSnapshotter(path, 0))
+	startServer(t, s)
+	saver := NewClient(s.Addr)
+	defer saver.Close()
+	saved := make(chan error, 1)
+	go func() {
+		res, err := saver.Do(&BluebellRequest{Command: huacache.SAVE})
+		if err == nil {
+			err = res.Err()
+		}
+		saved <- err
+	}()
+
+	// SAVE 阻塞期间事件循环仍然处理其他连接的命令
+	client := NewClient(s.Addr)
+	defer client.Close()
+	time.Sleep(100 * time.Millisecond)
+	set := make(chan error, 1)
+	go func() { set <- client.Set("save", "k", []byte("v"), 0) }()
+	var blocked bool
+	select {
+	case err := <-set:
+		if err != nil {
+			t.Fatalf("set during save failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		blocked = true
+	}
+	select {
+	case err := <-saved:
+		t.Fatalf("expect save to block on the pipe, got %v", err)
+	default:
+	}
+
+	pipe, err := os.Open(path + ".tmp")
+	if err != nil {
+		t.Fatalf("open pipe failed: %v", err)
+	}
+	defer pipe.Close()
+	go io.Copy(io.Discard, pipe)
+	select {
+	case err := <-saved:
+		// 管道不能 fsync，只要 SAVE 收到了回复即可
+		if StatusOf(err) != StatusInternal {
+			t.Fatalf("expect save to fail on syncing the pipe, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("save never replied")
+	}
+	if blocked {
+		t.Fatalf("set blocked behind save")
+	}
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// keys 命令分页遍历分组中的 key，参数编码在请求的 Value 中：
+//
+//	cursor uint64 | count uint32 | pattern
+//
+// 响应的 Result 为下一页的游标和本页的 key：
+//
+//	cursor uint64 | count uint32 | { key }...
+//
+// 游标的含义见 huacache.Group.Scan，集群中每个节点只遍历本地的 key。
+
+// NewScanRequest builds a keys request for a page of count keys of group
+// matching pattern, starting at cursor.
+func NewScanRequest(group string, cursor uint64, pattern string, count int) *BluebellRequest {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, cursor)
+	binary.Write(buf, binary.BigEndian, uint32(count))
+	writeString(buf, pattern)
+	return &BluebellRequest{
+		Command: huacache.GET_KEYS,
+		Group:   group,
+		Value:   buf.Bytes(),
+	}
+}
+
+// HandleScan executes a keys request on this node.
+func HandleScan(request *BluebellRequest) *BluebellResponse {
+	group, err := huacache.GetGroup(request.Group)
+	if err != nil {
+		return errResponse(err)
+	}
+	r := bytes.NewReader(request.Value)
+	var cursor uint64
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &cursor); err != nil {
+		return errorResponse(StatusBadRequest, "invalid scan: "+err.Error())
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return errorResponse(StatusBadRequest, "invalid scan: "+err.Error())
+	}
+	pattern, err := readString(r)
+	if err != nil {
+		return errorResponse(StatusBadRequest, "invalid scan: "+err.Error())
+	}
+	keys, next, err := group.Scan(cursor, pattern, int(count))
+	if err != nil {
+		return errResponse(err)
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, next)
+	binary.Write(buf, binary.BigEndian, uint32(len(keys)))
+	for _, key := range keys {
+		writeString(buf, key)
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: buf.Bytes(),
+	}
+}
+
+// DecodeScanResult decodes the cursor of the next page and the keys of a
+// keys response.
+func DecodeScanResult(data []byte) (keys []string, next uint64, err error) {
+	r := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &next); err != nil {
+		return nil, 0, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, 0, err
+	}
+	if int64(count)*4 > int64(r.Len()) {
+		return nil, 0, errors.New("invalid scan size")
+	}
+	keys = make([]string, count)
+	for i := range keys {
+		if keys[i], err = readString(r); err != nil {
+			return nil, 0, err
+		}
+	}
+	return keys, next, nil
+}
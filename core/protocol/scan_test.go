@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBluebellScan(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	huacache.DelGroup("scan")
+	g, _ := huacache.NewGroup("scan", 8*huacache.MB)
+	defer huacache.DelGroup("scan")
+	for i := 0; i < 100; i++ {
+		g.AddOrUpdate("user:"+strconv.Itoa(i), huacache.ByteView{B: []byte("v")})
+		g.AddOrUpdate("item:"+strconv.Itoa(i), huacache.ByteView{B: []byte("v")})
+	}
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		keys, next, err := client.Scan("scan", cursor, "user:*", 30)
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 100 || seen["item:1"] {
+		t.Fatalf("expect 100 user keys, got %d", len(seen))
+	}
+
+	var e *Error
+	if _, _, err := client.Scan("scan", 1<<62, "", 10); !errors.As(err, &e) || e.Status != StatusBadRequest {
+		t.Fatalf("expect a bad request for an invalid cursor, got %v", err)
+	}
+	if _, _, err := client.Scan("missing", 0, "", 10); StatusOf(err) != StatusGroupNotFound {
+		t.Fatalf("expect group not found, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"sync/atomic"
+	"time"
 
 	huacache "github.com/huahuoao/huacache/core"
 	"github.com/panjf2000/gnet/v2"
@@ -15,12 +16,40 @@ func (s *BluebellServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
 	log.Printf("running server on %s with multi-core=%t",
 		fmt.Sprintf("%s://%s", s.Network, s.Addr), s.Multicore)
 	s.eng = eng
+	if s.cluster != nil && s.credentials != nil {
+		s.cluster.SetCredentials(s.credentials)
+	}
+	if s.cluster != nil && s.peerTLS != nil {
+		s.cluster.SetTLS(s.peerTLS)
+	}
+	if s.cluster != nil {
+		s.cluster.SetMaxFrameSize(s.MaxFrameSize)
+	}
+	if s.primary != "" {
+		go s.follow()
+	}
+	if s.tlsListener != nil {
+		go s.serveTLS()
+	}
+	close(s.booted)
 	return
 }
 
+func (s *BluebellServer) OnShutdown(eng gnet.Engine) {
+	close(s.stop)
+	if s.tlsListener != nil {
+		s.tlsListener.Close()
+	}
+}
+
 func (s *BluebellServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
-	atomic.AddInt32(&s.connected, 1)
-	log.Printf("now the client nums is %v", s.connected)
+	connected := atomic.AddInt32(&s.connected, 1)
+	log.Printf("now the client nums is %v", connected)
+	c.SetContext(&session{})
+	if s.drain.draining.Load() {
+		// 关闭期间不再接受新连接
+		return nil, gnet.Close
+	}
 	return
 }
 
@@ -28,51 +57,65 @@ func (s *BluebellServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
 	if err != nil {
 		log.Printf("error occurred on connection=%s, %v\n", c.RemoteAddr().String(), err)
 	}
+	if c.Context().(*session).replica.Load() {
+		s.removeReplica(c)
+	}
 	atomic.AddInt32(&s.disconnected, 1)
 	connected := atomic.AddInt32(&s.connected, -1)
 	if connected == 0 {
-		log.Printf("all %d connections are closed", s.disconnected)
+		log.Printf("all %d connections are closed", atomic.LoadInt32(&s.disconnected))
 	}
 	return
 }
 
 func (s *BluebellServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	reader := c.(gnet.Reader)
-	writer := c.(gnet.Writer)
+	ss := c.Context().(*session)
 
+	var requests []*BluebellRequest
 	for {
 		// Peek the first 4 bytes (header) to get the message length
 		header, err := reader.Peek(4)
 		if err != nil {
-			if err == io.ErrShortBuffer {
-				// Not enough data, exit the loop and wait for more data
-				return gnet.None
+			if err != io.ErrShortBuffer {
+				log.Println("Read header error:", err)
 			}
-			log.Println("Read header error:", err)
-			return gnet.None
+			// Not enough data, exit the loop and wait for more data
+			break
 		}
 
 		// Extract message length
 		messageLength := binary.BigEndian.Uint32(header)
+		if int64(messageLength) > int64(s.MaxFrameSize) {
+			log.Printf("frame of %d bytes from %s exceeds the limit of %d bytes", messageLength, c.RemoteAddr(), s.MaxFrameSize)
+			return gnet.Close
+		}
 
 		// Check if we have enough data in the buffer
 		if reader.InboundBuffered() < int(messageLength+4) {
 			// Not enough data for a complete message, exit the loop
-			return gnet.None
+			break
 		}
 
 		// Discard the header (advance buffer)
 		_, err = reader.Discard(4)
 		if err != nil {
 			log.Println("Discard error:", err)
-			return gnet.None
+			break
 		}
 
 		// Read the message body
 		message, err := reader.Next(int(messageLength))
 		if err != nil {
 			log.Println("Read message error:", err)
-			return gnet.None
+			break
+		}
+
+		if ss.mode.compress {
+			if message, err = decompressBody(message, s.MaxFrameSize); err != nil {
+				log.Println("Failed to decompress message:", err)
+				continue
+			}
 		}
 
 		// Deserialize the message
@@ -81,35 +124,344 @@ func (s *BluebellServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 			log.Println("Failed to deserialize message:", err)
 			continue
 		}
+		switch {
+		case s.tls != nil && !ss.proxied:
+			if !s.peer(ss, bluebell) {
+				log.Println("Connection bypassed the tls front end")
+				return gnet.Close
+			}
+			continue
+		case !ss.started && bluebell.Command == huacache.HELLO:
+			// 握手在解码后续的帧之前完成，之后的帧按协商的模式解码
+			res, mode, user := s.hello(bluebell)
+			res.Version, res.ID = bluebell.Version, bluebell.ID
+			s.reply(c, res)
+			if res.Status == StatusOK {
+				ss.mode = mode
+				if user != nil {
+					ss.user = user
+				}
+			}
+		case s.acl != nil && ss.user == nil:
+			// 认证之前只接受 AUTH，认证同样在解码后续的帧之前完成
+			var res *BluebellResponse
+			if bluebell.Command == huacache.AUTH {
+				res, ss.user = s.auth(bluebell, nil)
+			} else {
+				res = errorResponse(StatusAuthRequired, "authentication required")
+			}
+			res.Version, res.ID = bluebell.Version, bluebell.ID
+			s.reply(c, res)
+		default:
+			// FlagExactTTL 只出现在 AOF 和副本同步流中，它们不经过 OnTraffic
+			bluebell.Flags &^= FlagExactTTL
+			if !s.trusted(ss) {
+				bluebell.Flags &^= FlagForwarded
+			}
+			requests = append(requests, bluebell)
+		}
+		ss.started = true
+	}
+
+	if len(requests) > 0 {
+		s.drain.pending.Add(int64(len(requests)))
+		s.serve(c, requests)
+	}
+	return gnet.None
+}
 
-		// Process the message and generate a response
-		var res *BluebellResponse
-		switch bluebell.Command {
-		case huacache.SET_KEY:
-			res = HandleSetKey(bluebell)
-		case huacache.GET_KEY:
-			res = HandleGetKey(bluebell)
-		case huacache.DEL_KEY:
-			res = HandleDeleteKey(bluebell)
-		case huacache.NEW_GROUP:
-			res = HandleNewGroup(bluebell)
-		case huacache.DEL_GROUP:
-			res = HandleDeleteGroup(bluebell)
+// session 保存单个连接的状态
+type session struct {
+	tail    chan struct{}  // 最近一批异步处理的请求，关闭表示响应已全部写出
+	replica atomic.Bool    // 该连接是否为副本的同步连接
+	started bool           // 是否已收到过请求，HELLO 只能是第一个请求
+	mode    connMode       // HELLO 协商出的模式，握手后不再改变
+	user    *huacache.User // 已认证的用户，未开启认证时为 nil
+	proxied bool           // 开启 TLS 时，是否已收到前端发来的客户端身份
+}
+
+// pending reports whether an earlier batch is still being processed.
+func (ss *session) pending() bool {
+	if ss.tail == nil {
+		return false
+	}
+	select {
+	case <-ss.tail:
+		return false
+	default:
+		return true
+	}
+}
+
+// serve processes a batch of requests decoded from c. Batches that may
+// block, see blocks, run in their own goroutine so the event loop is never
+// blocked, later batches wait for them to keep responses in order. v2 requests carry an ID, those that block are processed on their
+// own and may be answered out of order.
+func (s *BluebellServer) serve(c gnet.Conn, requests []*BluebellRequest) {
+	ss := c.Context().(*session)
+	ordered := make([]*BluebellRequest, 0, len(requests))
+	for _, request := range requests {
+		if request.Version >= ProtocolV2 && s.blocks([]*BluebellRequest{request}) {
+			go s.process(c, request)
+			continue
 		}
+		ordered = append(ordered, request)
+	}
+	requests = ordered
+	if len(requests) == 0 {
+		return
+	}
+	if !ss.pending() && !s.blocks(requests) {
+		for _, request := range requests {
+			s.process(c, request)
+		}
+		return
+	}
 
-		// Serialize the response
-		resBytes, err := res.Encode()
-		if err != nil {
-			log.Println("Failed to serialize response:", err)
+	prev := ss.tail
+	done := make(chan struct{})
+	ss.tail = done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		for _, request := range requests {
+			s.process(c, request)
+		}
+	}()
+}
+
+// process handles a request of c and writes its response.
+func (s *BluebellServer) process(c gnet.Conn, request *BluebellRequest) {
+	defer s.drain.pending.Add(-1)
+	start := time.Now()
+	ss := c.Context().(*session)
+	var res *BluebellResponse
+	if err := ss.mode.allowed(request); err != nil {
+		res = errorResponse(StatusBadRequest, err.Error())
+	} else if request.Command == huacache.AUTH {
+		res, _ = s.auth(request, ss.user)
+	} else if res = s.authorize(ss.user, request); res == nil {
+		if request.Command == huacache.SYNC {
+			// 同步连接上不再有普通响应，快照和后续命令由 replicaFeed 写出
+			s.addReplica(c)
+			return
+		}
+		res = s.handle(request)
+	}
+	s.observe(request, res, start)
+	res.Version, res.ID = request.Version, request.ID
+	s.reply(c, res)
+}
+
+// reply writes the response asynchronously.
+func (s *BluebellServer) reply(c gnet.Conn, res *BluebellResponse) {
+	// Serialize the response
+	body, err := res.Serialize()
+	if err != nil {
+		log.Println("Failed to serialize response:", err)
+		return
+	}
+	if c.Context().(*session).mode.compress {
+		body = compressBody(body)
+	}
+	resBytes := frame(body)
+
+	// Write the response asynchronously
+	if err := s.drain.write(c, resBytes); err != nil {
+		log.Println("Async write error:", err)
+	}
+}
+
+// handle processes the message and generates a response.
+func (s *BluebellServer) handle(request *BluebellRequest) *BluebellResponse {
+	// 租约只能在主节点发放，之后的 lease_set 才会被接受
+	if s.primary != "" && (isWrite(request.Command) || request.Command == huacache.LEASE_GET) {
+		return s.redirect()
+	}
+	if s.cluster != nil && request.Flags&FlagForwarded == 0 {
+		switch request.Command {
+		case huacache.SET_KEY, huacache.GET_KEY, huacache.DEL_KEY, huacache.INCR, huacache.DECR,
+			huacache.ADD_KEY, huacache.REPLACE_KEY, huacache.CAS, huacache.LEASE_GET, huacache.LEASE_SET,
+			huacache.GET_ITEM, huacache.PUT_ITEM:
+			if peer, ok := s.cluster.Owner(request.Key); ok {
+				return s.forward(peer, request)
+			}
+		case huacache.MGET_KEYS, huacache.MSET_KEYS, huacache.MDEL_KEYS:
+			return s.scatter(request)
+		case huacache.NEW_GROUP, huacache.DEL_GROUP, huacache.FLUSH_GROUP:
+			defer s.broadcast(request)
+		}
+	}
+	return s.handleLocal(request)
+}
+
+// handleLocal executes the request on this node.
+func (s *BluebellServer) handleLocal(request *BluebellRequest) *BluebellResponse {
+	if isWrite(request.Command) {
+		return s.apply(request)
+	}
+	switch request.Command {
+	case huacache.GET_KEY:
+		return HandleGetKey(request)
+	case huacache.GET_ITEM:
+		return HandleGetItem(request)
+	case huacache.MGET_KEYS:
+		return HandleBatch(request)
+	case huacache.GET_KEYS:
+		return HandleScan(request)
+	case huacache.LEASE_GET:
+		return HandleLeaseGet(request)
+	case huacache.SAVE:
+		return s.handleSave()
+	case huacache.REWRITE_AOF:
+		return s.handleRewriteAOF()
+	case huacache.STATS:
+		return HandleStats(request)
+	default:
+		return errorResponse(StatusUnknownCommand, "unknown command: "+request.Command)
+	}
+}
+
+// apply 执行写命令，写入 AOF 并推送给所有副本
+func (s *BluebellServer) apply(request *BluebellRequest) *BluebellResponse {
+	s.writeMu.RLock()
+	if len(s.replication.replicas) == 0 && s.aof == nil {
+		defer s.writeMu.RUnlock()
+		return dispatchWrite(request)
+	}
+	s.writeMu.RUnlock()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	res := dispatchWrite(request)
+	if res.Status != StatusOK {
+		return res
+	}
+	switch request.Command {
+	case huacache.INCR, huacache.DECR:
+		request = counterSet(request, res)
+	case huacache.ADD_KEY, huacache.REPLACE_KEY, huacache.CAS, huacache.LEASE_SET:
+		// 版本号和租约只在本节点有意义，副本和重放时无条件写入结果
+		set := *request
+		set.Command, set.CAS = huacache.SET_KEY, 0
+		request = &set
+	case huacache.PUT_ITEM:
+		put := *request
+		put.CAS, put.Flags = 0, put.Flags|FlagExactTTL
+		request = &put
+	}
+	if s.aof != nil {
+		if err := s.aof.Append(request); err != nil {
+			log.Printf("failed to append %s to aof: %v", request.Command, err)
+			return errorResponse(StatusInternal, "failed to persist command")
+		}
+	}
+	s.replication.feed(request)
+	return res
+}
+
+// handleRewriteAOF 在后台压缩 AOF
+func (s *BluebellServer) handleRewriteAOF() *BluebellResponse {
+	if s.aof == nil {
+		return errorResponse(StatusBadRequest, "aof is not enabled")
+	}
+	if err := s.aof.StartRewrite(); err != nil {
+		return errorResponse(StatusInternal, err.Error())
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+	}
+}
+
+// handleSave 保存快照，在事件循环之外执行（见 blocks）。与定时保存一样
+// 不阻塞写命令，否则其他连接的写入仍会卡住事件循环，快照只在每个分片内一致
+func (s *BluebellServer) handleSave() *BluebellResponse {
+	if s.snapshotter == nil {
+		return errorResponse(StatusBadRequest, "snapshot is not enabled")
+	}
+	if err := s.snapshotter.Save(); err != nil {
+		return errorResponse(StatusInternal, err.Error())
+	}
+	return &BluebellResponse{
+		Code:   "200",
+		Result: []byte("OK"),
+	}
+}
+
+// blocks reports whether any of the requests may take long enough to block
+// the event loop: it has to be sent to a peer, saves a snapshot, or reads a
+// group whose misses call a loader.
+func (s *BluebellServer) blocks(requests []*BluebellRequest) bool {
+	for _, request := range requests {
+		switch request.Command {
+		case huacache.SAVE:
+			return true
+		case huacache.GET_KEY, huacache.MGET_KEYS:
+			if loads(request.Group) {
+				return true
+			}
+		}
+	}
+	return s.needsPeers(requests)
+}
+
+// loads 判断分组未命中时是否会调用回源函数
+func loads(group string) bool {
+	g, err := huacache.GetGroup(group)
+	return err == nil && g.HasGetter()
+}
+
+// needsPeers reports whether any of the requests has to be sent to a peer.
+func (s *BluebellServer) needsPeers(requests []*BluebellRequest) bool {
+	if s.cluster == nil {
+		return false
+	}
+	for _, request := range requests {
+		if request.Flags&FlagForwarded != 0 {
 			continue
 		}
+		switch request.Command {
+		case huacache.SET_KEY, huacache.GET_KEY, huacache.DEL_KEY, huacache.INCR, huacache.DECR,
+			huacache.ADD_KEY, huacache.REPLACE_KEY, huacache.CAS, huacache.LEASE_GET, huacache.LEASE_SET,
+			huacache.GET_ITEM, huacache.PUT_ITEM:
+			if _, ok := s.cluster.Owner(request.Key); ok {
+				return true
+			}
+		case huacache.MGET_KEYS, huacache.MSET_KEYS, huacache.MDEL_KEYS, huacache.NEW_GROUP, huacache.DEL_GROUP,
+			huacache.FLUSH_GROUP:
+			return true
+		}
+	}
+	return false
+}
+
+// forward 将请求转发给 key 所属的节点，并原样返回其响应
+func (s *BluebellServer) forward(peer *Client, request *BluebellRequest) *BluebellResponse {
+	forwarded := *request
+	forwarded.Flags |= FlagForwarded
+	res, err := peer.Do(&forwarded)
+	if err != nil {
+		log.Printf("failed to forward %s to %s: %v", request.Command, peer.Addr, err)
+		return errorResponse(StatusUnavailable, "peer unavailable")
+	}
+	return res
+}
 
-		// Write the response asynchronously
-		err = writer.AsyncWrite(resBytes, nil)
+// broadcast 将分组的创建、删除同步到集群中的其他节点
+func (s *BluebellServer) broadcast(request *BluebellRequest) {
+	forwarded := *request
+	forwarded.Flags |= FlagForwarded
+	for _, peer := range s.cluster.Peers() {
+		res, err := peer.Do(&forwarded)
 		if err != nil {
-			log.Println("Async write error:", err)
-			return gnet.None
+			log.Printf("failed to broadcast %s to %s: %v", request.Command, peer.Addr, err)
+			continue
+		}
+		if err := res.Err(); err != nil {
+			log.Printf("peer %s replied %v to %s", peer.Addr, err, request.Command)
 		}
 	}
-
 }
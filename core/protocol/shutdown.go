@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// drainer 跟踪正在处理的请求和尚未写出的响应，优雅关闭时等待它们完成
+type drainer struct {
+	draining atomic.Bool  // 开始关闭后拒绝新连接
+	pending  atomic.Int64 // 正在处理的请求数加上尚未写出的响应数
+}
+
+// write 异步写出 data，写出之前 wait 不会返回
+func (d *drainer) write(c gnet.Conn, data []byte) error {
+	d.pending.Add(1)
+	err := c.AsyncWrite(data, func(gnet.Conn, error) error {
+		d.pending.Add(-1)
+		return nil
+	})
+	if err != nil {
+		// 写入没有进入事件循环，回调不会被调用
+		d.pending.Add(-1)
+	}
+	return err
+}
+
+// wait 等待所有请求处理完、响应写出，ctx 结束时返回 ctx 的错误
+func (d *drainer) wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for d.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// stop 拒绝新连接，等待进行中的工作完成后停止引擎。ctx 结束时不再等待，
+// 引擎同样会停止，剩余的连接被直接关闭。
+func (d *drainer) stop(ctx context.Context, eng gnet.Engine) error {
+	d.draining.Store(true)
+	err := d.wait(ctx)
+	if e := eng.Stop(ctx); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Shutdown gracefully stops the server: it stops accepting connections,
+// waits for the requests being processed and for their responses to be
+// written, then stops the engine, which closes the remaining connections.
+// When ctx is done first the engine is stopped right away and ctx's error
+// is returned.
+func (s *BluebellServer) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.booted:
+	default:
+		return nil // 引擎没有启动
+	}
+	if s.tlsListener != nil {
+		s.tlsListener.Close()
+	}
+	return s.drain.stop(ctx, s.eng)
+}
+
+// Shutdown gracefully stops the server, see BluebellServer.Shutdown.
+func (m *MemcachedServer) Shutdown(ctx context.Context) error {
+	select {
+	case <-m.booted:
+	default:
+		return nil
+	}
+	return m.drain.stop(ctx, m.eng)
+}
+
+// Shutdown gracefully stops the server, see BluebellServer.Shutdown.
+func (s *RespServer) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.booted:
+	default:
+		return nil
+	}
+	return s.drain.stop(ctx, s.eng)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestBluebellShutdown(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run() }()
+	<-s.booted
+	huacache.DelGroup("shutdown")
+	if _, err := huacache.NewGroup("shutdown", huacache.MB); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	const n = 500
+	var batch bytes.Buffer
+	for i := 0; i < n; i++ {
+		data, _ := (&BluebellRequest{Command: huacache.SET_KEY, Group: "shutdown", Key: strconv.Itoa(i), Value: []byte("v")}).Encode()
+		batch.Write(data)
+	}
+	if _, err := conn.Write(batch.Bytes()); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	if res, err := ReadResponse(reader); err != nil || res.Status != StatusOK {
+		t.Fatalf("unexpected first response %v %v", res, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	// 关闭前已经收到的请求都要得到响应
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 1; i < n; i++ {
+		if res, err := ReadResponse(reader); err != nil || res.Status != StatusOK {
+			t.Fatalf("response %d lost on shutdown: %v %v", i, res, err)
+		}
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server still running after shutdown")
+	}
+	if c, err := net.Dial("tcp", s.Addr); err == nil {
+		c.Close()
+		t.Fatalf("expect the listener to be closed")
+	}
+}
@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestGetStale(t *testing.T) {
+	huacache.DelGroup("stale")
+	group, err := huacache.NewGroup("stale", 8*huacache.MB, huacache.WithSoftTTL(time.Minute, 2*time.Minute))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer huacache.DelGroup("stale")
+
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var cluster *Cluster
+	for i, addr := range addrs {
+		s := NewBluebellServer("tcp", addr, false)
+		c := NewCluster(addr, addrs...)
+		s.SetCluster(c)
+		if i == 0 {
+			cluster = c
+		}
+		startServer(t, s)
+	}
+	// 第一个节点上和转发给第二个节点的 key 各有一个 fresh、一个 stale
+	stale := make(map[string]bool)
+	for i, local, remote := 0, 0, 0; local+remote < 4; i++ {
+		key := "key" + strconv.Itoa(i)
+		_, forwarded := cluster.Owner(key)
+		if forwarded && remote < 2 {
+			remote++
+		} else if !forwarded && local < 2 {
+			local++
+		} else {
+			continue
+		}
+		ttl := time.Duration(0)
+		if (forwarded && remote == 2) || (!forwarded && local == 2) {
+			ttl = time.Millisecond
+		}
+		if _, err := group.Set(key, huacache.ByteView{B: []byte("v")}, ttl); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		stale[key] = ttl > 0
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewClient(addrs[0])
+	defer client.Close()
+	pool := huacache.NewHTTPPool(addrs[0])
+	pool.SetPeers(cluster)
+	for key, stale := range stale {
+		res, err := client.Do(&BluebellRequest{Command: huacache.GET_KEY, Key: key, Group: "stale"})
+		if err != nil || res.Status != StatusOK {
+			t.Fatalf("get %s failed: %v %v", key, err, res)
+		}
+		if got := res.Flags&FlagStale != 0; got != stale {
+			t.Fatalf("expect %s to be stale=%t, got %t", key, stale, got)
+		}
+
+		w := httpGet(pool, "stale", key)
+		if w.Code != http.StatusOK || w.Body.String() != "v" {
+			t.Fatalf("http get %s failed: %d %s", key, w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache-Stale") == "1"; got != stale {
+			t.Fatalf("expect X-Cache-Stale of %s to be %t, got %t", key, stale, got)
+		}
+	}
+}
+
+// httpGet 通过 pool 的 HTTP API 读取 key
+func httpGet(pool *huacache.HTTPPool, group, key string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("group", group)
+	mw.WriteField("key", key)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/huacache/"+huacache.GET_KEY, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPGetStatus(t *testing.T) {
+	huacache.DelGroup("http-missing")
+	huacache.NewGroup("http-missing", 8*huacache.MB)
+	defer huacache.DelGroup("http-missing")
+	huacache.DelGroup("http-failing")
+	huacache.NewGroup("http-failing", 8*huacache.MB, huacache.WithGetter(huacache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("backend down")
+	})))
+	defer huacache.DelGroup("http-failing")
+
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var cluster *Cluster
+	for i, addr := range addrs {
+		s := NewBluebellServer("tcp", addr, false)
+		c := NewCluster(addr, addrs...)
+		s.SetCluster(c)
+		if i == 0 {
+			cluster = c
+		}
+		startServer(t, s)
+	}
+	pool := huacache.NewHTTPPool(addrs[0])
+	pool.SetPeers(cluster)
+	// 本节点和对端节点上的 key 返回同样的状态码
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if _, forwarded := cluster.Owner(key); forwarded {
+			remote = key
+		} else {
+			local = key
+		}
+	}
+	for _, key := range []string{local, remote} {
+		if w := httpGet(pool, "http-missing", key); w.Code != http.StatusNotFound {
+			t.Fatalf("expect 404 for missing %s, got %d %s", key, w.Code, w.Body)
+		}
+		if w := httpGet(pool, "http-failing", key); w.Code != http.StatusBadGateway {
+			t.Fatalf("expect 502 for %s when the loader fails, got %d %s", key, w.Code, w.Body)
+		}
+		if w := httpGet(pool, "http-none", key); w.Code != http.StatusNotFound {
+			t.Fatalf("expect 404 for a missing group, got %d %s", w.Code, w.Body)
+		}
+	}
+}
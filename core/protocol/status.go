@@ -0,0 +1,213 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/lru"
+)
+
+// Status is the typed outcome of a request. It is sent after Result as a
+// uint16, the numbers are part of the protocol and must never change.
+// Code keeps carrying the HTTP style code for clients that predate Status.
+type Status uint16
+
+const (
+	StatusOK              Status = 0
+	StatusNotFound        Status = 1  // key 不存在
+	StatusGroupNotFound   Status = 2  // 分组不存在
+	StatusTooLarge        Status = 3  // 值超过分组容量或帧大小限制
+	StatusUnknownCommand  Status = 4  // 未知命令
+	StatusBadRequest      Status = 5  // 请求参数错误
+	StatusAuthRequired    Status = 6  // 未认证
+	StatusForbidden       Status = 7  // 无权限
+	StatusExists          Status = 8  // key 或分组已存在
+	StatusVersionMismatch Status = 9  // CAS 版本不匹配
+	StatusLoadFailed      Status = 10 // 回源失败
+	StatusUnavailable     Status = 11 // 对端节点不可用
+	StatusReadOnly        Status = 12 // 只读副本，Result 为主节点地址
+	StatusInternal        Status = 13 // 服务端内部错误
+	StatusLeaseWait       Status = 14 // 其他客户端持有 key 的租约，稍后重试
+	StatusLeaseInvalid    Status = 15 // 租约无效、已过期或 key 已被删除
+)
+
+var statusNames = map[Status]string{
+	StatusOK:              "OK",
+	StatusNotFound:        "NOT_FOUND",
+	StatusGroupNotFound:   "GROUP_NOT_FOUND",
+	StatusTooLarge:        "TOO_LARGE",
+	StatusUnknownCommand:  "UNKNOWN_COMMAND",
+	StatusBadRequest:      "BAD_REQUEST",
+	StatusAuthRequired:    "AUTH_REQUIRED",
+	StatusForbidden:       "FORBIDDEN",
+	StatusExists:          "EXISTS",
+	StatusVersionMismatch: "VERSION_MISMATCH",
+	StatusLoadFailed:      "LOAD_FAILED",
+	StatusUnavailable:     "UNAVAILABLE",
+	StatusReadOnly:        "READ_ONLY",
+	StatusInternal:        "INTERNAL",
+	StatusLeaseWait:       "LEASE_WAIT",
+	StatusLeaseInvalid:    "LEASE_INVALID",
+}
+
+// 每个 Status 对应的旧版 Code
+var statusCodes = map[Status]string{
+	StatusOK:              "200",
+	StatusNotFound:        "404",
+	StatusGroupNotFound:   "404",
+	StatusTooLarge:        "413",
+	StatusUnknownCommand:  "400",
+	StatusBadRequest:      "400",
+	StatusAuthRequired:    "401",
+	StatusForbidden:       "403",
+	StatusExists:          "409",
+	StatusVersionMismatch: "409",
+	StatusLoadFailed:      "502",
+	StatusUnavailable:     "503",
+	StatusReadOnly:        "307",
+	StatusInternal:        "500",
+	StatusLeaseWait:       "429",
+	StatusLeaseInvalid:    "409",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("STATUS_%d", s)
+}
+
+// Code returns the HTTP style code sent along with the status.
+func (s Status) Code() string {
+	if code, ok := statusCodes[s]; ok {
+		return code
+	}
+	return "500"
+}
+
+// statusFromCode 为不带 Status 的旧版响应推断状态
+func statusFromCode(code string) Status {
+	switch code {
+	case "200":
+		return StatusOK
+	case "307":
+		return StatusReadOnly
+	case "400":
+		return StatusBadRequest
+	case "401":
+		return StatusAuthRequired
+	case "403":
+		return StatusForbidden
+	case "404":
+		return StatusNotFound
+	case "409":
+		return StatusExists
+	case "413":
+		return StatusTooLarge
+	case "502":
+		return StatusLoadFailed
+	case "503":
+		return StatusUnavailable
+	}
+	return StatusInternal
+}
+
+// Error is a failed response seen by a client.
+type Error struct {
+	Status  Status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Status.String() + ": " + e.Message
+}
+
+// Is makes errors.Is match the huacache error the server replied with, e.g.
+// huacache.ErrKeyNotFound for StatusNotFound.
+func (e *Error) Is(target error) bool {
+	switch e.Status {
+	case StatusNotFound:
+		return target == huacache.ErrKeyNotFound
+	case StatusGroupNotFound:
+		return target == huacache.ErrGroupNotFound
+	case StatusExists:
+		return target == huacache.ErrKeyExists
+	case StatusVersionMismatch:
+		return target == huacache.ErrVersionMismatch
+	case StatusTooLarge:
+		return target == huacache.ErrValueTooLarge
+	}
+	return false
+}
+
+// HTTPStatus returns the HTTP status of the error for the HTTP API. A
+// read-only replica answers 421, the request has to go to the primary.
+func (e *Error) HTTPStatus() int {
+	if e.Status == StatusReadOnly {
+		return http.StatusMisdirectedRequest
+	}
+	code, _ := strconv.Atoi(e.Status.Code())
+	return code
+}
+
+// Err returns nil for a successful response, an *Error otherwise.
+func (b *BluebellResponse) Err() error {
+	if b.Status == StatusOK {
+		return nil
+	}
+	return &Error{Status: b.Status, Message: string(b.Result)}
+}
+
+// StatusOf returns the status of err: the one carried by an *Error, or the
+// one the server reports for the errors of huacache and lru.
+func StatusOf(err error) Status {
+	var e *Error
+	var loadErr *huacache.LoadError
+	switch {
+	case err == nil:
+		return StatusOK
+	case errors.As(err, &e):
+		return e.Status
+	case errors.As(err, &loadErr):
+		return StatusLoadFailed
+	case errors.Is(err, huacache.ErrKeyNotFound), errors.Is(err, lru.ErrNotFound):
+		return StatusNotFound
+	case errors.Is(err, huacache.ErrGroupNotFound):
+		return StatusGroupNotFound
+	case errors.Is(err, lru.ErrTooLarge):
+		return StatusTooLarge
+	case errors.Is(err, huacache.ErrKeyExists), errors.Is(err, huacache.ErrGroupExists):
+		return StatusExists
+	case errors.Is(err, huacache.ErrVersionMismatch):
+		return StatusVersionMismatch
+	case errors.Is(err, huacache.ErrKeyRequired), errors.Is(err, huacache.ErrInvalidCursor),
+		errors.Is(err, huacache.ErrNotInteger), errors.Is(err, huacache.ErrOverflow):
+		return StatusBadRequest
+	case errors.Is(err, huacache.ErrValueTooLarge):
+		return StatusTooLarge
+	case errors.Is(err, huacache.ErrGroupLocked):
+		return StatusForbidden
+	case errors.Is(err, huacache.ErrLeaseWait):
+		return StatusLeaseWait
+	case errors.Is(err, huacache.ErrLeaseInvalid):
+		return StatusLeaseInvalid
+	}
+	return StatusInternal
+}
+
+// errorResponse 构造一个失败的响应
+func errorResponse(status Status, message string) *BluebellResponse {
+	return &BluebellResponse{
+		Code:   status.Code(),
+		Status: status,
+		Result: []byte(message),
+	}
+}
+
+// errResponse 按 err 的类型构造失败的响应
+func errResponse(err error) *BluebellResponse {
+	return errorResponse(StatusOf(err), err.Error())
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+func TestStatusCodes(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	startServer(t, s)
+	client := NewClient(s.Addr)
+	defer client.Close()
+	if _, err := huacache.NewGroup("status", 1024); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		request *BluebellRequest
+		status  Status
+		code    string
+	}{
+		{&BluebellRequest{Command: huacache.GET_KEY, Key: "missing", Group: "status"}, StatusNotFound, "404"},
+		{&BluebellRequest{Command: huacache.GET_KEY, Key: "k", Group: "missing"}, StatusGroupNotFound, "404"},
+		{&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: make([]byte, 4096), Group: "status"}, StatusTooLarge, "413"},
+		{&BluebellRequest{Command: huacache.DEL_KEY, Key: "missing", Group: "status"}, StatusNotFound, "404"},
+		{&BluebellRequest{Command: huacache.NEW_GROUP, Key: "1024", Group: "status"}, StatusExists, "409"},
+		{&BluebellRequest{Command: "nope"}, StatusUnknownCommand, "400"},
+	} {
+		res, err := client.Do(tc.request)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tc.request.Command, err)
+		}
+		if res.Status != tc.status || res.Code != tc.code {
+			t.Errorf("%s: expect %v %s, got %v %s (%s)", tc.request.Command, tc.status, tc.code, res.Status, res.Code, res.Result)
+		}
+	}
+
+	// 客户端无需匹配错误信息即可区分未命中
+	_, err := client.Get("status", "missing")
+	var e *Error
+	if !errors.As(err, &e) || StatusOf(err) != StatusNotFound {
+		t.Fatalf("expect a NOT_FOUND error, got %v", err)
+	}
+}
+
+func TestLegacyResponseStatus(t *testing.T) {
+	// 旧版服务端的响应不带 Status 和 CAS，按 Code 推断
+	body, _ := (&BluebellResponse{Code: "502", Result: []byte("load failed")}).Serialize()
+	res, err := DeserializeResponse(body[:len(body)-2-8])
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if res.Status != StatusLoadFailed || StatusOf(res.Err()) != StatusLoadFailed {
+		t.Fatalf("expect LOAD_FAILED, got %v", res.Status)
+	}
+	if StatusNotFound.String() != "NOT_FOUND" || Status(999).String() != "STATUS_999" {
+		t.Fatalf("unexpected status names")
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.MaxFrameSize = 1024
+	startServer(t, s)
+	huacache.DelGroup("frame")
+	if _, err := huacache.NewGroup("frame", 1<<20); err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	client := NewClient(s.Addr)
+	defer client.Close()
+	if err := client.Set("frame", "small", make([]byte, 512), 0); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := client.Set("frame", "large", make([]byte, 2048), 0); err == nil {
+		t.Fatalf("expect the connection to be closed for a frame over the limit")
+	}
+
+	// 客户端同样拒绝超过限制的响应，而不是按帧头分配内存
+	limited := NewClient(s.Addr)
+	limited.SetMaxFrameSize(256)
+	defer limited.Close()
+	if _, err := limited.Get("frame", "small"); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+}
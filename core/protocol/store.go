@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"strconv"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// ItemStore applies the writes of the memcached and RESP listeners.
+type ItemStore interface {
+	// Update replaces the item of key with the one returned by fn, see
+	// huacache.Group.Update. fn may be called again with the latest item
+	// when key changed meanwhile, so it must not have side effects.
+	Update(group, key string, fn func(old huacache.Item, ok bool) (huacache.Item, error)) (huacache.Item, error)
+	Delete(group, key string) error
+	// Flush removes every key of group.
+	Flush(group string) error
+}
+
+// groupStore 直接写入本节点的分组，用于没有 Bluebell 服务的节点
+type groupStore struct{}
+
+func (groupStore) Update(group, key string, fn func(old huacache.Item, ok bool) (huacache.Item, error)) (huacache.Item, error) {
+	g, err := huacache.GetGroup(group)
+	if err != nil {
+		return huacache.Item{}, err
+	}
+	return g.Update(key, fn)
+}
+
+func (groupStore) Delete(group, key string) error {
+	g, err := huacache.GetGroup(group)
+	if err != nil {
+		return err
+	}
+	return g.Delete(key)
+}
+
+func (groupStore) Flush(group string) error {
+	g, err := huacache.GetGroup(group)
+	if err != nil {
+		return err
+	}
+	g.Flush()
+	return nil
+}
+
+// Store returns an ItemStore that applies the writes as Bluebell requests
+// of s: in a cluster they go to the owner of the key, a replica sends them
+// to its primary, and they are logged to the AOF and sent to the replicas.
+// Permissions are left to the caller.
+func (s *BluebellServer) Store() ItemStore {
+	return itemStore{s}
+}
+
+// itemStore 通过 get_item 和带 CAS 的 put_item 实现乐观的读-改-写
+type itemStore struct {
+	s *BluebellServer
+}
+
+func (st itemStore) do(request *BluebellRequest) (*BluebellResponse, error) {
+	if st.s.primary != "" {
+		// 副本的 handle 会拒绝写入，读也要从主节点读，否则 CAS 对不上。
+		// 不带 FlagForwarded，主节点在集群中时仍按 key 路由
+		res, err := st.s.upstream().Do(request)
+		if err != nil {
+			return nil, err
+		}
+		return res, res.Err()
+	}
+	return localClient{st.s}.do(request)
+}
+
+func (st itemStore) Update(group, key string, fn func(old huacache.Item, ok bool) (huacache.Item, error)) (huacache.Item, error) {
+	for {
+		old, ok, err := st.get(group, key)
+		if err != nil {
+			return huacache.Item{}, err
+		}
+		item, err := fn(old, ok)
+		if err != nil {
+			return huacache.Item{}, err
+		}
+		data, err := item.MarshalBinary()
+		if err != nil {
+			return huacache.Item{}, err
+		}
+		res, err := st.do(&BluebellRequest{Command: huacache.PUT_ITEM, Key: key, Group: group, Value: data, CAS: old.Version})
+		switch StatusOf(err) {
+		case StatusOK:
+			item.Version = res.CAS
+			return item, nil
+		case StatusExists, StatusNotFound, StatusVersionMismatch:
+			// 读取之后 key 被其他客户端修改了，重新读取
+			continue
+		}
+		return huacache.Item{}, err
+	}
+}
+
+// get 读取 key 当前的 item，不回源
+func (st itemStore) get(group, key string) (huacache.Item, bool, error) {
+	var item huacache.Item
+	res, err := st.do(&BluebellRequest{Command: huacache.GET_ITEM, Key: key, Group: group})
+	switch StatusOf(err) {
+	case StatusOK:
+	case StatusNotFound:
+		return item, false, nil
+	default:
+		return item, false, err
+	}
+	if err := item.UnmarshalBinary(res.Result); err != nil {
+		return item, false, err
+	}
+	item.Version = res.CAS
+	return item, true, nil
+}
+
+func (st itemStore) Delete(group, key string) error {
+	_, err := st.do(&BluebellRequest{Command: huacache.DEL_KEY, Key: key, Group: group})
+	return err
+}
+
+func (st itemStore) Flush(group string) error {
+	_, err := st.do(&BluebellRequest{Command: huacache.FLUSH_GROUP, Group: group})
+	return err
+}
+
+// remote 判断通过 store 的写入是否可能要等待其他节点
+func remote(store ItemStore) bool {
+	st, ok := store.(itemStore)
+	return ok && (st.s.primary != "" || st.s.cluster != nil)
+}
+
+// replicated 判断 store 是否属于一个副本，副本的写入发给主节点
+func replicated(store ItemStore) bool {
+	st, ok := store.(itemStore)
+	return ok && st.s.primary != ""
+}
+
+// upstream 返回副本连接主节点的客户端，凭据和 TLS 配置在 Run 之前已经设置好
+func (s *BluebellServer) upstream() *Client {
+	s.upstreamOnce.Do(func() {
+		s.upstreamClient = NewClient(s.primary)
+		s.upstreamClient.SetCredentials(s.credentials)
+		s.upstreamClient.SetTLS(s.peerTLS)
+		s.upstreamClient.SetMaxFrameSize(s.MaxFrameSize)
+	})
+	return s.upstreamClient
+}
+
+// Writer returns a huacache.Writer that applies the writes of the HTTP API
+// as if s had received them from a client: a replica refuses them, in a
+// cluster they go to the owner of the key, and they are logged to the AOF
+// and sent to the replicas. Permissions are left to the caller.
+func (s *BluebellServer) Writer() huacache.Writer {
+	return localClient{s}
+}
+
+// localClient 在本节点的 BluebellServer 上执行请求，不经过网络
+type localClient struct {
+	s *BluebellServer
+}
+
+func (c localClient) do(request *BluebellRequest) (*BluebellResponse, error) {
+	res := c.s.handle(request)
+	return res, res.Err()
+}
+
+func (c localClient) Set(group string, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(&BluebellRequest{Command: huacache.SET_KEY, Key: key, Value: value, Group: group, TTL: ttl.Milliseconds()})
+	return err
+}
+
+func (c localClient) Delete(group string, key string) error {
+	_, err := c.do(&BluebellRequest{Command: huacache.DEL_KEY, Key: key, Group: group})
+	return err
+}
+
+func (c localClient) Incr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return c.counter(huacache.INCR, group, key, delta, initial, ttl)
+}
+
+func (c localClient) Decr(group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	return c.counter(huacache.DECR, group, key, delta, initial, ttl)
+}
+
+func (c localClient) counter(command, group, key string, delta, initial int64, ttl time.Duration) (int64, error) {
+	res, err := c.do(NewCounterRequest(command, group, key, delta, initial, ttl))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(res.Result), 10, 64)
+}
+
+func (c localClient) NewGroup(name string, cacheBytes int64, policy string) error {
+	_, err := c.do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: strconv.FormatInt(cacheBytes, 10), Group: name, Value: []byte(policy)})
+	return err
+}
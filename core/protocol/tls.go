@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// gnet 不支持 TLS：开启 TLS 时由前端监听 Addr 并完成握手，再把明文转发给只监听在
+// 私有 unix socket 上的 gnet 引擎。前端在每个连接的第一个帧中告知引擎客户端证书的身份。
+
+// cmdTLSPeer 是前端发给引擎的第一个帧，Key 为客户端证书的 common name
+const cmdTLSPeer = "tls_peer"
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// SetTLS makes the server accept TLS connections only, it must be called
+// before Run. config usually comes from huacache.CertReloader.ServerConfig,
+// when it verifies client certificates their common name authenticates the
+// connection as the user added with huacache.ACL.AddCertUser.
+func (s *BluebellServer) SetTLS(config *tls.Config) {
+	s.tls = config
+}
+
+// SetPeerTLS makes the node dial its peers and its primary over TLS with
+// config, usually from huacache.CertReloader.ClientConfig.
+func (s *BluebellServer) SetPeerTLS(config *tls.Config) {
+	s.peerTLS = config
+}
+
+// Run serves on Addr until the engine stops.
+func (s *BluebellServer) Run(options ...gnet.Option) error {
+	if s.tls == nil {
+		return gnet.Run(s, s.Network+"://"+s.Addr, options...)
+	}
+	// 目录权限为 0700，只有本进程的用户能连接引擎
+	dir, err := os.MkdirTemp("", "huacache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	ln, err := tls.Listen(s.Network, s.Addr, s.tls)
+	if err != nil {
+		return err
+	}
+	s.tlsListener = ln
+	s.engineAddr = filepath.Join(dir, "bluebell.sock")
+	options = append(options, gnet.WithReusePort(false))
+	err = gnet.Run(s, "unix://"+s.engineAddr, options...)
+	ln.Close()
+	return err
+}
+
+// serveTLS 接受 TLS 连接并转发给引擎，在 OnBoot 中启动
+func (s *BluebellServer) serveTLS() {
+	for {
+		conn, err := s.tlsListener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("Accept tls connection error:", err)
+			}
+			return
+		}
+		go s.proxyTLS(conn.(*tls.Conn))
+	}
+}
+
+func (s *BluebellServer) proxyTLS(conn *tls.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		log.Printf("tls handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	state := conn.ConnectionState()
+	name, _ := huacache.PeerIdentity(&state)
+
+	backend, err := net.Dial("unix", s.engineAddr)
+	if err != nil {
+		log.Println("Failed to connect to engine:", err)
+		return
+	}
+	defer backend.Close()
+	data, err := (&BluebellRequest{Command: cmdTLSPeer, Key: name}).Encode()
+	if err != nil {
+		return
+	}
+	if _, err := backend.Write(data); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, backend)
+		// 引擎关闭连接后同时关闭客户端连接
+		conn.Close()
+		close(done)
+	}()
+	io.Copy(backend, conn)
+	backend.Close()
+	<-done
+}
+
+// peer 处理前端发来的第一个帧，返回 false 时关闭连接
+func (s *BluebellServer) peer(ss *session, request *BluebellRequest) bool {
+	if request.Command != cmdTLSPeer {
+		return false
+	}
+	ss.proxied = true
+	if s.acl != nil && request.Key != "" {
+		// 没有对应的证书用户时，连接仍需使用 AUTH 或 HELLO 认证
+		if user, err := s.acl.AuthenticateCert(request.Key); err == nil {
+			ss.user = user
+		}
+	}
+	return true
+}
+
+// dial 连接 Bluebell 节点，config 非 nil 时使用 TLS
+func dial(addr string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		return net.DialTimeout("tcp", addr, clientDialTimeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: clientDialTimeout}, "tcp", addr, config)
+}
@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	huacache "github.com/huahuoao/huacache/core"
+)
+
+// newTestCerts 生成自签名 CA 及其签发的证书，每个名称对应一个对 127.0.0.1 有效的证书
+func newTestCerts(t *testing.T, names ...string) map[string]*huacache.CertReloader {
+	dir := t.TempDir()
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+		return path
+	}
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "huacache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create ca failed: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	caFile := write("ca.pem", "CERTIFICATE", caDER)
+
+	certs := make(map[string]*huacache.CertReloader)
+	for i, name := range names {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("issue %s failed: %v", name, err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		reloader, err := huacache.NewCertReloader(write(name+".pem", "CERTIFICATE", der), write(name+"-key.pem", "EC PRIVATE KEY", keyDER), caFile)
+		if err != nil {
+			t.Fatalf("load %s failed: %v", name, err)
+		}
+		certs[name] = reloader
+	}
+	return certs
+}
+
+// startTLSServer 运行开启了 TLS 的服务，测试结束时关闭
+func startTLSServer(t *testing.T, s *BluebellServer) {
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run() }()
+	select {
+	case <-s.booted:
+	case err := <-errs:
+		t.Fatalf("server did not start: %v", err)
+	}
+	t.Cleanup(func() {
+		s.eng.Stop(context.Background())
+	})
+}
+
+func TestBluebellMutualTLS(t *testing.T) {
+	certs := newTestCerts(t, "server", "node-b", "stranger")
+	acl := huacache.NewACL()
+	admin, _ := huacache.ParseACLRule("rwa:*")
+	acl.AddCertUser("node-b", admin)
+	s := NewBluebellServer("tcp", freeAddr(t), false)
+	s.SetACL(acl)
+	s.SetTLS(certs["server"].ServerConfig(tls.VerifyClientCertIfGiven))
+	startTLSServer(t, s)
+	huacache.DelGroup("tls")
+
+	// 证书的 common name 即用户，无需 AUTH
+	m, err := DialMuxTLS(s.Addr, certs["node-b"].ClientConfig(), nil)
+	if err != nil {
+		t.Fatalf("dial over tls failed: %v", err)
+	}
+	defer m.Close()
+	if res, err := m.Do(&BluebellRequest{Command: huacache.NEW_GROUP, Key: "1048576", Group: "tls"}); err != nil || res.Status != StatusOK {
+		t.Fatalf("create group failed: %v %v", res, err)
+	}
+	if res, err := m.Do(&BluebellRequest{Command: huacache.SET_KEY, Key: "k", Value: []byte("v"), Group: "tls"}); err != nil || res.Status != StatusOK {
+		t.Fatalf("set failed: %v %v", res, err)
+	}
+
+	// 没有对应证书用户的客户端仍需认证
+	client := NewClient(s.Addr)
+	client.SetTLS(certs["stranger"].ClientConfig())
+	defer client.Close()
+	if _, err := client.Get("tls", "k"); err == nil {
+		t.Fatalf("expect an unknown certificate to need auth")
+	}
+
+	peer := NewClient(s.Addr)
+	peer.SetTLS(certs["node-b"].ClientConfig())
+	defer peer.Close()
+	if v, err := peer.Get("tls", "k"); err != nil || string(v) != "v" {
+		t.Fatalf("get over tls failed: %v", err)
+	}
+
+	// 明文连接无法通过握手
+	plain := NewClient(s.Addr)
+	defer plain.Close()
+	if _, err := plain.Get("tls", "k"); err == nil {
+		t.Fatalf("expect a plaintext client to fail")
+	}
+}
@@ -0,0 +1,105 @@
+package huacache
+
+import "strings"
+
+// DefaultScanCount is the page size of a scan that does not set one.
+const DefaultScanCount = 10
+
+// Scan examines about count keys of the group from cursor and returns the
+// ones matching pattern, along with the cursor of the next page. Start with
+// cursor 0 and stop when 0 is returned, a page may be short or empty before
+// that; a key present for the whole scan is returned exactly once, over all
+// the shards. pattern is a Redis style glob, empty matches every key. Only
+// the keys stored on this node are scanned.
+func (g *Group) Scan(cursor uint64, pattern string, count int) (keys []string, next uint64, err error) {
+	if cursor>>32 >= uint64(g.mainCache.lru.SliceNum) {
+		return nil, 0, ErrInvalidCursor
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	keys, next = g.mainCache.lru.Scan(cursor, count, matcher(pattern))
+	return keys, next, nil
+}
+
+// matcher 返回 pattern 对应的匹配函数，只有前缀的 pattern 不必逐字符匹配
+func matcher(pattern string) func(string) bool {
+	if pattern == "" || pattern == "*" {
+		return nil
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, `*?[\`) {
+		return func(key string) bool { return strings.HasPrefix(key, prefix) }
+	}
+	return func(key string) bool { return matchGlob(pattern, key) }
+}
+
+// matchGlob 实现 Redis 风格的通配符匹配：* ? [abc] [^a-z] 以及 \ 转义
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// 没有闭合的 [ 按普通字符处理
+				if s[0] != '[' {
+					return false
+				}
+				s, pattern = s[1:], pattern[1:]
+				continue
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			s, pattern = s[1:], pattern[end+2:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
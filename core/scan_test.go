@@ -0,0 +1,76 @@
+package huacache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGroupScan(t *testing.T) {
+	DelGroup("scan")
+	g, _ := NewGroup("scan", 8*MB)
+	defer DelGroup("scan")
+	for i := 0; i < 200; i++ {
+		g.AddOrUpdate(fmt.Sprintf("user:%d", i), ByteView{B: []byte("v")})
+		g.AddOrUpdate(fmt.Sprintf("item:%d", i), ByteView{B: []byte("v")})
+	}
+
+	for _, pattern := range []string{"user:*", "user:1?"} {
+		seen := make(map[string]bool)
+		cursor := uint64(0)
+		for {
+			keys, next, err := g.Scan(cursor, pattern, 25)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) > 25 {
+				t.Fatalf("expect at most 25 keys in a page, got %d", len(keys))
+			}
+			for _, key := range keys {
+				if seen[key] || !matchGlob(pattern, key) {
+					t.Fatalf("unexpected key %s for %s", key, pattern)
+				}
+				seen[key] = true
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+		if want := map[string]int{"user:*": 200, "user:1?": 10}[pattern]; len(seen) != want {
+			t.Fatalf("expect %d keys for %s, got %d", want, pattern, len(seen))
+		}
+	}
+
+	keys, _, _ := g.Scan(0, "", 0)
+	if len(keys) != DefaultScanCount {
+		t.Fatalf("expect a default page of %d keys, got %d", DefaultScanCount, len(keys))
+	}
+	if _, _, err := g.Scan(uint64(g.Shards())<<32, "", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expect ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "item:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"*:*:end", "a:b:end", true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.s); got != c.match {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.s, got, c.match)
+		}
+	}
+}
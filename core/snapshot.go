@@ -0,0 +1,334 @@
+package huacache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/huahuoao/huacache/core/lru"
+)
+
+// 快照格式：
+//
+//	magic "HUACACHE" | version uint16
+//	{ 1 | name | capacity int64 | policy | shards uint32 | { 1 | key | value | flags uint32 | expireAt int64 }... 0 }...
+//	0
+//
+// 字符串与字节数组均为 uint32 长度 + 内容，整数使用大端序。每个分片按从最久未使用到
+// 最近使用的顺序（即淘汰顺序）写入，恢复时依次插入即可还原 LRU 顺序。
+// 版本 1 的快照没有 flags，版本 3 之前没有 policy，版本 4 之前没有 shards。
+const (
+	snapshotMagic   = "HUACACHE"
+	snapshotVersion = uint16(4)
+)
+
+// SaveSnapshot writes every group to w.
+func SaveSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.BigEndian, snapshotVersion); err != nil {
+		return err
+	}
+
+	mu.RLock()
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	mu.RUnlock()
+
+	for _, g := range list {
+		if err := g.save(bw); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte(0); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (g *Group) save(w *bufio.Writer) error {
+	if err := w.WriteByte(1); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, []byte(g.name)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, g.mainCache.cacheBytes); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, []byte(g.policy)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(g.shards)); err != nil {
+		return err
+	}
+	sh := g.mainCache.lru
+	var entries []snapshotEntry
+	for i := 0; i < sh.SliceNum; i++ {
+		// 持有分片锁时只复制条目，写入可能很慢，在释放锁之后进行。值不会被原地修改，
+		// 复制 ByteView 即可
+		entries = entries[:0]
+		sh.ShardingMap[i].Range(func(key string, value lru.Value, expireAt int64) bool {
+			entries = append(entries, snapshotEntry{key, value.(ByteView), expireAt})
+			return true
+		})
+		for _, e := range entries {
+			if err := e.write(w); err != nil {
+				return err
+			}
+		}
+	}
+	return w.WriteByte(0)
+}
+
+// snapshotEntry 为快照中的一个 key
+type snapshotEntry struct {
+	key      string
+	value    ByteView
+	expireAt int64
+}
+
+func (e snapshotEntry) write(w *bufio.Writer) error {
+	if err := w.WriteByte(1); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, []byte(e.key)); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, e.value.B); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, e.value.Flags); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, e.expireAt)
+}
+
+// LoadSnapshot replaces all groups with the ones read from r,
+// entries that expired in the meantime are skipped.
+func LoadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, []byte(snapshotMagic)) {
+		return errors.New("not a huacache snapshot")
+	}
+	var version uint16
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version == 0 || version > snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	resetGroups()
+	defer createDeclared()
+	for {
+		more, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if more == 0 {
+			return nil
+		}
+		if err := loadGroup(br, version); err != nil {
+			return err
+		}
+	}
+}
+
+func loadGroup(r *bufio.Reader, version uint16) error {
+	name, err := readSnapshotBytes(r)
+	if err != nil {
+		return err
+	}
+	var capacity int64
+	if err := binary.Read(r, binary.BigEndian, &capacity); err != nil {
+		return err
+	}
+	var policy []byte
+	if version >= 3 {
+		if policy, err = readSnapshotBytes(r); err != nil {
+			return err
+		}
+	}
+	// 按保存时的分片数恢复，容量总是它的倍数；旧快照使用当前的默认分片数，
+	// 容量不是其倍数时取最接近的倍数
+	shards := uint32(defaultShards)
+	if version >= 4 {
+		if err := binary.Read(r, binary.BigEndian, &shards); err != nil {
+			return err
+		}
+	} else if n := int64(shards); capacity%n != 0 {
+		restored := max((capacity+n/2)/n, 1) * n
+		log.Printf("capacity %d of group %s is not a multiple of %d shards, restoring it with capacity %d", capacity, name, n, restored)
+		capacity = restored
+	}
+	g, err := NewGroup(string(name), capacity, WithPolicy(string(policy)), WithShards(int(shards)))
+	if err != nil {
+		return err
+	}
+	for {
+		more, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if more == 0 {
+			return nil
+		}
+		key, err := readSnapshotBytes(r)
+		if err != nil {
+			return err
+		}
+		value, err := readSnapshotBytes(r)
+		if err != nil {
+			return err
+		}
+		var flags uint32
+		if version >= 2 {
+			if err := binary.Read(r, binary.BigEndian, &flags); err != nil {
+				return err
+			}
+		}
+		var expireAt int64
+		if err := binary.Read(r, binary.BigEndian, &expireAt); err != nil {
+			return err
+		}
+		// 快照中是已经套用过分组 ttl 的过期时间，原样恢复
+		var expire time.Time
+		if expireAt != 0 {
+			if expire = time.Unix(0, expireAt); !expire.After(time.Now()) {
+				continue
+			}
+		}
+		if _, err := g.Restore(string(key), ByteView{B: value, Flags: flags}, expire); err != nil {
+			return err
+		}
+	}
+}
+
+func writeSnapshotBytes(w *bufio.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readSnapshotBytes(r *bufio.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SaveSnapshotFile atomically replaces path with a snapshot of all groups.
+func SaveSnapshotFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := SaveSnapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// 先落盘再重命名，避免宕机后留下不完整的快照
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshotFile replaces all groups with the snapshot stored at path.
+func LoadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return LoadSnapshot(f)
+}
+
+// Snapshotter saves all groups to a file on demand or periodically.
+type Snapshotter struct {
+	Path     string
+	Interval time.Duration // 定期保存的周期，0 表示只在手动调用 Save 时保存
+	mu       sync.Mutex    // 同一时间只允许一次保存
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSnapshotter creates a snapshotter writing to path.
+func NewSnapshotter(path string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{
+		Path:     path,
+		Interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Save writes a snapshot now.
+func (s *Snapshotter) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	if err := SaveSnapshotFile(s.Path); err != nil {
+		return err
+	}
+	log.Printf("snapshot saved to %s in %v", s.Path, time.Since(start))
+	return nil
+}
+
+// Start saves a snapshot every Interval until Stop is called.
+func (s *Snapshotter) Start() {
+	if s.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					log.Printf("failed to save snapshot: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic snapshots.
+func (s *Snapshotter) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
@@ -0,0 +1,209 @@
+package huacache
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	name := generateRandomString(5)
+	g, _ := NewGroup(name, MB*8)
+	for i := 0; i < 100; i++ {
+		key := generateRandomString(8)
+		g.AddOrUpdate(key, ByteView{B: []byte("value-" + key)})
+	}
+	g.AddOrUpdateWithTTL("ttl", ByteView{B: []byte("v")}, time.Hour)
+	g.AddOrUpdateWithTTL("expired", ByteView{B: []byte("v")}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	shardKeys := func(g *Group) [][]string {
+		var all [][]string
+		for i := 0; i < g.mainCache.lru.SliceNum; i++ {
+			keys, _ := g.mainCache.lru.ShardingMap[i].Keys()
+			all = append(all, keys)
+		}
+		return all
+	}
+	before := shardKeys(g)
+
+	var buf bytes.Buffer
+	if err := SaveSnapshot(&buf); err != nil {
+		t.Fatalf("save snapshot failed: %v", err)
+	}
+	if err := LoadSnapshot(&buf); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+
+	restored, err := GetGroup(name)
+	if err != nil || restored == g {
+		t.Fatalf("group not restored: %v", err)
+	}
+	if restored.mainCache.cacheBytes != MB*8 {
+		t.Fatalf("capacity not restored, got %d", restored.mainCache.cacheBytes)
+	}
+	after := shardKeys(restored)
+	for i := range before {
+		if len(before[i]) != len(after[i]) {
+			t.Fatalf("shard %d size mismatch: %d != %d", i, len(before[i]), len(after[i]))
+		}
+		for j := range before[i] {
+			if before[i][j] != after[i][j] {
+				t.Fatalf("shard %d lru order not preserved", i)
+			}
+		}
+	}
+	if _, err := restored.Get("expired"); err == nil {
+		t.Fatalf("expired key should not be restored")
+	}
+	if v, err := restored.Get("ttl"); err != nil || v.String() != "v" {
+		t.Fatalf("ttl key not restored: %v", err)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	name := generateRandomString(5)
+	g, _ := NewGroup(name, MB*8)
+	g.AddOrUpdate("key1", ByteView{B: []byte("value1")})
+
+	path := filepath.Join(t.TempDir(), "dump.hc")
+	snapshotter := NewSnapshotter(path, 10*time.Millisecond)
+	snapshotter.Start()
+	defer snapshotter.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	DelGroup(name)
+	if err := LoadSnapshotFile(path); err != nil {
+		t.Fatalf("load snapshot file failed: %v", err)
+	}
+	restored, err := GetGroup(name)
+	if err != nil {
+		t.Fatalf("group not restored: %v", err)
+	}
+	if v, err := restored.Get("key1"); err != nil || v.String() != "value1" {
+		t.Fatalf("key not restored: %v", err)
+	}
+}
+
+func TestSnapshotStaleExpiry(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(declared, "stale-snapshot")
+		delete(groups, "stale-snapshot")
+		mu.Unlock()
+	})
+	g, _ := DeclareGroup("stale-snapshot", MB*8, WithSoftTTL(time.Minute, time.Hour))
+	g.Set("soft", ByteView{B: []byte("v")}, 0)
+	g.Set("ttl", ByteView{B: []byte("v")}, time.Second)
+	g.Restore("forever", ByteView{B: []byte("v")}, time.Time{})
+	var before []Item
+	for _, key := range []string{"soft", "ttl", "forever"} {
+		it, _ := g.Peek(key)
+		before = append(before, it)
+	}
+
+	// 恢复时不能再加一次 stale 时长，也不能给永不过期的 key 套用默认 ttl
+	for round := 0; round < 2; round++ {
+		var buf bytes.Buffer
+		if err := SaveSnapshot(&buf); err != nil {
+			t.Fatalf("save snapshot failed: %v", err)
+		}
+		if err := LoadSnapshot(&buf); err != nil {
+			t.Fatalf("load snapshot failed: %v", err)
+		}
+	}
+	restored, err := GetGroup("stale-snapshot")
+	if err != nil || restored.StaleFor() != 59*time.Minute {
+		t.Fatalf("group not restored with its stale window: %v", err)
+	}
+	for i, key := range []string{"soft", "ttl", "forever"} {
+		it, ok := restored.Peek(key)
+		if !ok || !it.ExpireAt.Equal(before[i].ExpireAt) {
+			t.Fatalf("expect %s to expire at %v, got %v %v", key, before[i].ExpireAt, it.ExpireAt, ok)
+		}
+	}
+}
+
+// blockingWriter 的第一次写入阻塞到 unblock 被关闭
+type blockingWriter struct {
+	once    sync.Once
+	blocked chan struct{}
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.blocked)
+		<-w.unblock
+	})
+	return len(p), nil
+}
+
+func TestSnapshotWritesOutsideShardLocks(t *testing.T) {
+	DelGroup("snapshot-locks")
+	g, err := NewGroup("snapshot-locks", 8*MB)
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer DelGroup("snapshot-locks")
+	value := ByteView{B: make([]byte, 100)}
+	for i := 0; i < 1000; i++ {
+		g.Set("k"+strconv.Itoa(i), value, 0)
+	}
+
+	w := &blockingWriter{blocked: make(chan struct{}), unblock: make(chan struct{})}
+	saved := make(chan error, 1)
+	go func() { saved <- SaveSnapshot(w) }()
+	<-w.blocked
+	// 快照阻塞在写入时，所有分片都仍然可以写
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			g.Set("k"+strconv.Itoa(i), value, 0)
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Errorf("writes blocked by a slow snapshot")
+	}
+	close(w.unblock)
+	if err := <-saved; err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+}
+
+func TestSnapshotShards(t *testing.T) {
+	name := generateRandomString(5)
+	g, err := NewGroup(name, 3*MB, WithShards(3))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer DelGroup(name)
+	g.AddOrUpdate("key1", ByteView{B: []byte("value1")})
+
+	var buf bytes.Buffer
+	if err := SaveSnapshot(&buf); err != nil {
+		t.Fatalf("save snapshot failed: %v", err)
+	}
+	// 修改默认分片数后 3MB 不再是分片数的倍数，恢复时仍使用保存的分片数
+	defer SetDefaultShards(defaultShards)
+	SetDefaultShards(8)
+	if err := LoadSnapshot(&buf); err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	restored, err := GetGroup(name)
+	if err != nil {
+		t.Fatalf("group not restored: %v", err)
+	}
+	if restored.Shards() != 3 || restored.mainCache.cacheBytes != 3*MB {
+		t.Fatalf("expect 3 shards of 3MB, got %d shards of %d", restored.Shards(), restored.mainCache.cacheBytes)
+	}
+	if v, err := restored.Get("key1"); err != nil || v.String() != "value1" {
+		t.Fatalf("key not restored: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package huacache
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// 设置了 soft ttl 的分组中，值在 soft ttl 之后过期（stale）但仍然返回，并在后台
+// 回源刷新，直到 hard ttl 才被删除。缓存中只记录 hard ttl，值在被删除前的最后
+// staleFor 时间内是 stale 的。
+
+// WithSoftTTL makes values stale soft after they are written and removes
+// them at hard. A stale value is still returned, with ByteView.Stale set,
+// and reloaded once in the background when the group has a getter. soft is
+// the ttl of values stored without one; a value stored with its own ttl
+// becomes stale after it and is removed hard-soft later.
+func WithSoftTTL(soft, hard time.Duration) GroupOption {
+	return func(g *Group) {
+		g.defaultTTL = soft
+		g.staleFor = max(hard-soft, 0)
+	}
+}
+
+// StaleFor returns how long values are served stale before they are
+// removed, 0 unless the group was created WithSoftTTL.
+func (g *Group) StaleFor() time.Duration {
+	return g.staleFor
+}
+
+// markStale 标记超过 soft ttl 的值
+func (g *Group) markStale(item Item) Item {
+	if g.staleFor > 0 && !item.ExpireAt.IsZero() && time.Until(item.ExpireAt) <= g.staleFor {
+		item.Value.Stale = true
+	}
+	return item
+}
+
+// serve 标记 stale 的值，并在有 getter 时在后台刷新
+func (g *Group) serve(key string, item Item) Item {
+	item = g.markStale(item)
+	if item.Value.Stale && g.getter != nil {
+		g.refresh(key, item)
+	}
+	return item
+}
+
+// refresh 在后台重新加载 key，同一个 key 同时只有一次刷新。刷新的值沿用原来的
+// ttl 和 flags；刷新期间 key 被写入或删除时丢弃加载的结果，不覆盖更新的数据
+func (g *Group) refresh(key string, stale Item) {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	go func() {
+		defer g.refreshing.Delete(key)
+		b, err := g.getter.Get(key)
+		if err != nil {
+			log.Printf("failed to refresh stale key %s: %v", key, err)
+			return
+		}
+		_, err = g.Update(key, func(old Item, ok bool) (Item, error) {
+			if !ok {
+				return Item{}, ErrKeyNotFound
+			}
+			if old.Version != stale.Version {
+				return Item{}, ErrVersionMismatch
+			}
+			value := ByteView{B: cloneBytes(b), Flags: old.Value.Flags}
+			if old.ttl == 0 {
+				// 从快照恢复的值不知道原来的 ttl，使用分组的默认 ttl
+				return g.NewItem(value, 0), nil
+			}
+			return Item{Value: value, ExpireAt: expireAt(old.ttl), ttl: old.ttl}, nil
+		})
+		if err != nil && !errors.Is(err, ErrVersionMismatch) && !errors.Is(err, ErrKeyNotFound) {
+			log.Printf("failed to cache refreshed key %s: %v", key, err)
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package huacache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if loads.Add(1) > 1 {
+			<-release
+		}
+		return []byte("v" + string(rune('0'+loads.Load()))), nil
+	})
+	DelGroup("stale")
+	g, _ := NewGroup("stale", 8*MB, WithGetter(getter), WithSoftTTL(50*time.Millisecond, 200*time.Millisecond))
+	defer DelGroup("stale")
+
+	v, err := g.Get("k")
+	if err != nil || v.String() != "v1" || v.Stale {
+		t.Fatalf("expect a fresh v1, got %q %v %v", v.String(), v.Stale, err)
+	}
+
+	// soft ttl 之后并发读取都返回旧值，只触发一次后台刷新
+	time.Sleep(80 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Get("k")
+			if err != nil || v.String() != "v1" || !v.Stale {
+				t.Errorf("expect a stale v1, got %q %v %v", v.String(), v.Stale, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, _ := g.Get("k")
+		if v.String() == "v2" && !v.Stale {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the refreshed v2, got %q %v", v.String(), v.Stale)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("expect a single refresh, got %d loads", n)
+	}
+}
+
+func TestStaleHardTTL(t *testing.T) {
+	DelGroup("stale")
+	g, _ := NewGroup("stale", 8*MB, WithSoftTTL(30*time.Millisecond, 80*time.Millisecond))
+	defer DelGroup("stale")
+
+	g.Set("k", ByteView{B: []byte("v")}, 0)
+	time.Sleep(50 * time.Millisecond)
+	v, err := g.Get("k")
+	if err != nil || !v.Stale {
+		t.Fatalf("expect a stale value without a getter, got %v %v", v.Stale, err)
+	}
+	if it, ok := g.Peek("k"); !ok || !it.Value.Stale {
+		t.Fatalf("expect peek to flag the value stale")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := g.Get("k"); err == nil {
+		t.Fatalf("expect the value removed after the hard ttl")
+	}
+
+	// 写入时指定的 ttl 是 soft ttl
+	g.Set("k", ByteView{B: []byte("v")}, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if v, err := g.Get("k"); err != nil || !v.Stale {
+		t.Fatalf("expect a stale value before ttl+%s, got %v %v", g.StaleFor(), v.Stale, err)
+	}
+}
+
+func TestStaleRefreshKeepsTTL(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("fresh"), nil
+	})
+	DelGroup("stale")
+	g, _ := NewGroup("stale", 8*MB, WithGetter(getter), WithSoftTTL(time.Hour, time.Hour+100*time.Millisecond))
+	defer DelGroup("stale")
+
+	g.Set("k", ByteView{B: []byte("old"), Flags: 7}, 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if v, _ := g.Get("k"); !v.Stale {
+		t.Fatalf("expect a stale value")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		it, ok := g.Peek("k")
+		if ok && it.Value.String() == "fresh" {
+			// 刷新后沿用 key 自己的 ttl 和 flags，而不是分组的默认 ttl
+			if it.TTL() > time.Second || it.Value.Flags != 7 {
+				t.Fatalf("expect the refreshed value to keep its ttl and flags, got %v %d", it.TTL(), it.Value.Flags)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the value refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
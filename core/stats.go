@@ -0,0 +1,141 @@
+package huacache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// started 为进程启动时间，用于计算 uptime
+var started = time.Now()
+
+var (
+	statsMu     sync.RWMutex
+	statsConfig map[string]string
+	listeners   []namedListener
+)
+
+type namedListener struct {
+	name    string
+	counter ConnectionCounter
+}
+
+// ConnectionCounter is a listener whose connections are reported by the
+// stats command.
+type ConnectionCounter interface {
+	// Connections returns the open connections and all the connections
+	// accepted since the listener started.
+	Connections() (open, total int64)
+}
+
+// SetStatsConfig records the settings reported by the stats command,
+// secrets must be removed first.
+func SetStatsConfig(settings map[string]string) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statsConfig = settings
+}
+
+// RegisterListener adds the connections of a listener to the stats.
+func RegisterListener(name string, counter ConnectionCounter) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	listeners = append(listeners, namedListener{name, counter})
+}
+
+// NodeStats is what a node holds, as reported by the stats command of
+// Bluebell and the stats route of the HTTP API.
+type NodeStats struct {
+	Version       string            `json:"version"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Config        map[string]string `json:"config,omitempty"`
+	Connections   []ListenerStats   `json:"connections"`
+	Total         *GroupStatus      `json:"total"` // 所有分组的总和
+	Groups        []*GroupStatus    `json:"groups"`
+}
+
+// ListenerStats are the connections of a listener.
+type ListenerStats struct {
+	Listener string `json:"listener"`
+	Open     int64  `json:"open"`
+	Total    int64  `json:"total"`
+}
+
+// GetNodeStats returns the stats of the node, with only the named group
+// when group is not empty.
+func GetNodeStats(group string) (*NodeStats, error) {
+	stats := &NodeStats{
+		Version:       VERSION,
+		UptimeSeconds: int64(time.Since(started).Seconds()),
+		Total:         &GroupStatus{},
+	}
+	statsMu.RLock()
+	stats.Config = statsConfig
+	for _, l := range listeners {
+		open, total := l.counter.Connections()
+		stats.Connections = append(stats.Connections, ListenerStats{l.name, open, total})
+	}
+	statsMu.RUnlock()
+
+	if group != "" {
+		g, err := GetGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, group)
+		}
+		stats.Groups = []*GroupStatus{g.GetStatus()}
+	} else {
+		stats.Groups = GetStatuses()
+	}
+	for _, gs := range stats.Groups {
+		stats.Total.add(gs)
+	}
+	return stats, nil
+}
+
+// Text formats the stats in sections of "name:value" lines, like the INFO
+// command of Redis.
+func (s *NodeStats) Text() string {
+	var b strings.Builder
+	b.WriteString("# Server\n")
+	fmt.Fprintf(&b, "version:%s\nuptime_seconds:%d\n", s.Version, s.UptimeSeconds)
+
+	b.WriteString("\n# Connections\n")
+	for _, l := range s.Connections {
+		fmt.Fprintf(&b, "%s_open:%d\n%s_total:%d\n", l.Listener, l.Open, l.Listener, l.Total)
+	}
+
+	b.WriteString("\n# Memory\n")
+	writeGroupStatus(&b, s.Total)
+	fmt.Fprintf(&b, "groups:%d\n", len(s.Groups))
+
+	for _, gs := range s.Groups {
+		fmt.Fprintf(&b, "\n# Group %s\n", gs.Name)
+		fmt.Fprintf(&b, "policy:%s\n", gs.Policy)
+		writeGroupStatus(&b, gs)
+		for i, shard := range gs.Shards {
+			fmt.Fprintf(&b, "shard_%d:keys=%d,used_bytes=%d,evictions=%d\n", i, shard.Keys, shard.Used, shard.Evictions)
+		}
+		fmt.Fprintf(&b, "shard_skew:%.2f\n", gs.ShardSkew)
+	}
+
+	if len(s.Config) > 0 {
+		b.WriteString("\n# Config\n")
+		names := make([]string, 0, len(s.Config))
+		for name := range s.Config {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s:%s\n", name, s.Config[name])
+		}
+	}
+	return b.String()
+}
+
+func writeGroupStatus(b *strings.Builder, gs *GroupStatus) {
+	fmt.Fprintf(b, "capacity_bytes:%d\nused_bytes:%d\nkeys:%d\n", gs.Size, gs.Used, gs.KeyCount)
+	fmt.Fprintf(b, "hits:%d\nmisses:%d\nhit_ratio:%.4f\n", gs.Hits, gs.Misses, gs.HitRatio)
+	fmt.Fprintf(b, "sets:%d\ndeletes:%d\nevictions:%d\nexpired:%d\n", gs.Sets, gs.Deletes, gs.Evictions, gs.Expired)
+}
@@ -0,0 +1,66 @@
+package huacache
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeListener struct{}
+
+func (fakeListener) Connections() (open, total int64) { return 2, 5 }
+
+func TestNodeStats(t *testing.T) {
+	DelGroup("stats")
+	g, err := NewGroup("stats", MB*8, WithShards(4))
+	if err != nil {
+		t.Fatalf("create group failed: %v", err)
+	}
+	defer DelGroup("stats")
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		g.AddOrUpdate(key, ByteView{B: []byte("v")})
+	}
+	g.Get("a")
+	g.Get("missing")
+	SetStatsConfig(map[string]string{"shards": "4"})
+	RegisterListener("fake", fakeListener{})
+
+	stats, err := GetNodeStats("stats")
+	if err != nil {
+		t.Fatalf("get stats failed: %v", err)
+	}
+	gs := stats.Groups[0]
+	if len(stats.Groups) != 1 || gs.KeyCount != 8 || gs.Hits != 1 || gs.Misses != 1 || gs.HitRatio != 0.5 || gs.Size != MB*8 {
+		t.Fatalf("unexpected group status %+v", gs)
+	}
+	keys := 0
+	for _, shard := range gs.Shards {
+		keys += shard.Keys
+	}
+	if len(gs.Shards) != 4 || keys != 8 || gs.ShardSkew < 1 {
+		t.Fatalf("unexpected shards %+v, skew %v", gs.Shards, gs.ShardSkew)
+	}
+	if total := stats.Total; total.KeyCount != 8 || total.Hits != 1 || total.Size != gs.Size || total.HitRatio != 0.5 {
+		t.Fatalf("expect the total to match the only group: %+v", stats.Total)
+	}
+	text := stats.Text()
+	for _, want := range []string{"version:" + VERSION, "fake_open:2", "fake_total:5", "# Group stats", "hit_ratio:0.5000", "shard_3:keys=", "shards:4"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expect %q in:\n%s", want, text)
+		}
+	}
+	if _, err := GetNodeStats("no_such_group"); err == nil {
+		t.Fatalf("expect an error for a missing group")
+	}
+
+	rec := httptest.NewRecorder()
+	NewHTTPPool("127.0.0.1:4160").ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+STATS+"?group=stats&format=json", nil))
+	var decoded NodeStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode stats failed: %v\n%s", err, rec.Body.String())
+	}
+	if len(decoded.Groups) != 1 || decoded.Groups[0].KeyCount != 8 || decoded.Config["shards"] != "4" {
+		t.Fatalf("unexpected stats %+v", decoded)
+	}
+}
@@ -0,0 +1,154 @@
+package huacache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader holds the certificate of a node and the CA it trusts, both
+// read from PEM files. The files are checked on every handshake and read
+// again once they change, so certificates can be rotated without a restart.
+// It is safe for concurrent use.
+type CertReloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool // 为 nil 时使用系统的根证书
+	modTime time.Time      // 已加载文件中最新的修改时间，变化后重新加载
+}
+
+// NewCertReloader loads the certificate and key of the node, and the CA
+// that signs the certificates of its peers and clients. caFile is optional.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latest 返回证书文件中最新的修改时间
+func (r *CertReloader) latest() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload reads the files again.
+func (r *CertReloader) Reload() error {
+	modTime, err := r.latest()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	return nil
+}
+
+// current 在文件变化后重新加载，加载失败时继续使用旧的证书
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	cert, pool, modTime := r.cert, r.pool, r.modTime
+	r.mu.RUnlock()
+	if latest, err := r.latest(); err == nil && !latest.Equal(modTime) {
+		if err := r.Reload(); err != nil {
+			log.Printf("failed to reload certificate %s: %v", r.certFile, err)
+			return cert, pool
+		}
+		r.mu.RLock()
+		cert, pool = r.cert, r.pool
+		r.mu.RUnlock()
+	}
+	return cert, pool
+}
+
+// ServerConfig returns the config of a TLS listener. With clientAuth
+// tls.RequireAndVerifyClientCert the listener only accepts clients holding
+// a certificate signed by the CA, see PeerIdentity.
+func (r *CertReloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    pool,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the config used to dial peers. The node presents
+// its own certificate and verifies the peer against the CA.
+func (r *CertReloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		// RootCAs 不能在握手时替换，因此跳过默认校验，由 VerifyConnection 使用当前的 CA 校验
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer presented no certificate")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// PeerIdentity returns the common name of the verified client certificate
+// of a TLS connection, ok is false when the client presented none.
+func PeerIdentity(cs *tls.ConnectionState) (name string, ok bool) {
+	if cs == nil || len(cs.VerifiedChains) == 0 {
+		return "", false
+	}
+	return cs.VerifiedChains[0][0].Subject.CommonName, true
+}
@@ -0,0 +1,170 @@
+package huacache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 是测试用的自签名 CA
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "huacache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.write("ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) write(name, typ string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		ca.t.Fatalf("write %s failed: %v", name, err)
+	}
+	return path
+}
+
+// issue 签发对 127.0.0.1 有效的服务端、客户端证书，返回证书和私钥文件
+func (ca *testCA) issue(name string, serial int64) (certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatalf("issue %s failed: %v", name, err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return ca.write(name+".pem", "CERTIFICATE", der), ca.write(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue("node-a", 2)
+	certs, err := NewCertReloader(certFile, keyFile, filepath.Join(ca.dir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("load certificate failed: %v", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig(tls.RequireAndVerifyClientCert))
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	// 返回服务端证书的序列号
+	handshake := func(config *tls.Config) (int64, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), config)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		// TLS 1.3 中服务端在客户端握手完成后才校验客户端证书，失败时在读取时收到 alert
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			return 0, err
+		}
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+	if serial, err := handshake(certs.ClientConfig()); err != nil || serial != 2 {
+		t.Fatalf("handshake failed: %d %v", serial, err)
+	}
+	if _, err := handshake(&tls.Config{InsecureSkipVerify: true}); err == nil {
+		t.Fatalf("expect a client without certificate to be rejected")
+	}
+
+	// 替换证书后无需重启
+	ca.issue("node-a", 3)
+	if serial, err := handshake(certs.ClientConfig()); err != nil || serial != 3 {
+		t.Fatalf("expect the rotated certificate, got %d %v", serial, err)
+	}
+
+	// 其他 CA 签发的证书不被信任
+	other := newTestCA(t)
+	otherCert, otherKey := other.issue("node-b", 2)
+	untrusted, _ := NewCertReloader(otherCert, otherKey, filepath.Join(other.dir, "ca.pem"))
+	if _, err := handshake(untrusted.ClientConfig()); err == nil {
+		t.Fatalf("expect a certificate of another ca to be rejected")
+	}
+}
+
+func TestHTTPPoolMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue("server", 2)
+	clientCert, clientKey := ca.issue("dashboard", 3)
+	server, _ := NewCertReloader(serverCert, serverKey, filepath.Join(ca.dir, "ca.pem"))
+	client, _ := NewCertReloader(clientCert, clientKey, filepath.Join(ca.dir, "ca.pem"))
+
+	acl := NewACL()
+	acl.AddCertUser("dashboard", ACLRule{Pattern: "*", Perms: PermRead})
+	pool := NewHTTPPool("self")
+	pool.SetACL(acl)
+	ts := httptest.NewUnstartedServer(pool)
+	ts.TLS = server.ServerConfig(tls.VerifyClientCertIfGiven)
+	ts.StartTLS()
+	defer ts.Close()
+
+	do := func(config *tls.Config) int {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		res, err := c.Get(ts.URL + defaultBasePath + LIST_GROUP)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := do(client.ClientConfig()); code != http.StatusOK {
+		t.Fatalf("expect the client certificate to authenticate, got %d", code)
+	}
+	anonymous := client.ClientConfig()
+	anonymous.GetClientCertificate = nil
+	if code := do(anonymous); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without a client certificate, got %d", code)
+	}
+}
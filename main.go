@@ -1,42 +1,358 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
-	"time"
+	"sync/atomic"
+	"syscall"
 
 	huacache "github.com/huahuoao/huacache/core"
+	"github.com/huahuoao/huacache/core/config"
+	"github.com/huahuoao/huacache/core/metrics"
 	"github.com/huahuoao/huacache/core/protocol"
-	"github.com/panjf2000/gnet/pkg/logging"
 	"github.com/panjf2000/gnet/v2"
 )
 
-func NewHTTPPool(wg *sync.WaitGroup) {
-	defer wg.Done()
-	addr := "0.0.0.0:4160"
+// cfg 是启动时加载的配置，见 config.Load
+var cfg *config.Config
+
+// ensureGroup creates the group unless it was restored from disk.
+func ensureGroup(name string, cacheBytes int64, opts ...huacache.GroupOption) {
+	if _, err := huacache.GetGroup(name); err == nil {
+		return
+	}
+	if _, err := huacache.NewGroup(name, cacheBytes, opts...); err != nil {
+		log.Fatalf("failed to create group %s: %v", name, err)
+	}
+}
+
+// declareGroups creates the groups declared in the config. It runs before
+// the snapshot and the aof are loaded so that restored groups keep their
+// declared settings.
+func declareGroups() {
+	for _, g := range cfg.Groups {
+		if _, err := huacache.DeclareGroup(g.Name, int64(g.Capacity), g.Options()...); err != nil {
+			log.Fatalf("failed to create group %s: %v", g.Name, err)
+		}
+	}
+}
+
+// openAOF replays the append-only file, it returns nil when it is disabled.
+func openAOF() *protocol.AOF {
+	p := cfg.Persistence
+	if p.AOF == "" {
+		return nil
+	}
+	policy, err := protocol.ParseFsyncPolicy(p.AppendFsync)
+	if err != nil {
+		log.Fatal(err)
+	}
+	aof, err := protocol.OpenAOF(p.AOF, policy)
+	if err != nil {
+		log.Fatalf("failed to open aof %s: %v", p.AOF, err)
+	}
+	return aof
+}
+
+// newSnapshotter restores the last snapshot and starts periodic snapshots,
+// it returns nil when snapshots are disabled.
+func newSnapshotter() *huacache.Snapshotter {
+	p := cfg.Persistence
+	if p.Snapshot == "" {
+		return nil
+	}
+	err := huacache.LoadSnapshotFile(p.Snapshot)
+	switch {
+	case err == nil:
+		log.Println("restored snapshot from", p.Snapshot)
+	case errors.Is(err, os.ErrNotExist):
+		log.Println("no snapshot found at", p.Snapshot)
+	default:
+		log.Fatalf("failed to restore snapshot %s: %v", p.Snapshot, err)
+	}
+	snapshotter := huacache.NewSnapshotter(p.Snapshot, p.SnapshotInterval)
+	snapshotter.Start()
+	return snapshotter
+}
+
+// loadACL returns nil when authentication is disabled.
+func loadACL() *huacache.ACL {
+	if cfg.Security.ACL == "" {
+		return nil
+	}
+	acl, err := huacache.LoadACLFile(cfg.Security.ACL)
+	if err != nil {
+		log.Fatalf("failed to load acl %s: %v", cfg.Security.ACL, err)
+	}
+	return acl
+}
+
+// newCertReloader returns nil when TLS is disabled. The files are read again
+// whenever they change.
+func newCertReloader() (*huacache.CertReloader, tls.ClientAuthType) {
+	t := cfg.Security.TLS
+	if t.Cert == "" {
+		return nil, tls.NoClientCert
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	switch t.ClientAuth {
+	case "none":
+		clientAuth = tls.NoClientCert
+	case "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	certs, err := huacache.NewCertReloader(t.Cert, t.Key, t.CA)
+	if err != nil {
+		log.Fatalf("failed to load tls certificate: %v", err)
+	}
+	return certs, clientAuth
+}
+
+// newCluster returns nil when the node runs standalone.
+func newCluster() *protocol.Cluster {
+	c := cfg.Cluster
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	return protocol.NewClusterWithVirtualNodes(cfg.Limits.VirtualNodes, c.Self, c.Peers...)
+}
+
+// engineOptions 返回 gnet 引擎的选项
+func engineOptions() []gnet.Option {
+	e := cfg.Engine
+	return []gnet.Option{
+		gnet.WithMulticore(e.Multicore),
+		gnet.WithReusePort(true),
+		gnet.WithTCPKeepAlive(e.TCPKeepAlive),
+		gnet.WithReadBufferCap(int(e.ReadBuffer)),
+		gnet.WithWriteBufferCap(int(e.WriteBuffer)),
+	}
+}
+
+// NewHTTPPool serves the HTTP API, its writes go through the Bluebell server
+// ss when there is one.
+func NewHTTPPool(ss *protocol.BluebellServer, cluster *protocol.Cluster, acl *huacache.ACL, certs *huacache.CertReloader, clientAuth tls.ClientAuthType, errs chan<- error) *http.Server {
+	addr := cfg.HTTP.Addr
 	peers := huacache.NewHTTPPool(addr)
+	if cluster != nil {
+		peers.SetPeers(cluster)
+	}
+	if ss != nil {
+		peers.SetWriter(ss.Writer())
+	}
+	if acl != nil {
+		peers.SetACL(acl)
+	}
+	server := &http.Server{Addr: addr, Handler: peers}
 	log.Println("gcache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr, peers))
+	serve("http", func() error {
+		if certs != nil {
+			server.TLSConfig = certs.ServerConfig(clientAuth)
+			return server.ListenAndServeTLS("", "")
+		}
+		return server.ListenAndServe()
+	}, errs)
+	return server
+}
+
+func NewTCPPool(cluster *protocol.Cluster, snapshotter *huacache.Snapshotter, aof *protocol.AOF, acl *huacache.ACL, certs *huacache.CertReloader, clientAuth tls.ClientAuthType, errs chan<- error) *protocol.BluebellServer {
+	ss := protocol.NewBluebellServer("tcp", cfg.Bluebell.Addr, cfg.Engine.Multicore)
+	ss.MaxFrameSize = int(cfg.Limits.MaxFrame)
+	if aof != nil {
+		ss.SetAOF(aof)
+	}
+	if snapshotter != nil {
+		ss.SetSnapshotter(snapshotter)
+	}
+	if cluster != nil {
+		ss.SetCluster(cluster)
+	}
+	if cfg.Cluster.ReplicaOf != "" {
+		ss.ReplicaOf(cfg.Cluster.ReplicaOf)
+	}
+	if acl != nil {
+		ss.SetACL(acl)
+	}
+	if s := cfg.Security; s.AuthUser != "" || s.AuthPassword != "" {
+		ss.SetCredentials(s.AuthUser, s.AuthPassword)
+	}
+	if certs != nil {
+		ss.SetTLS(certs.ServerConfig(clientAuth))
+		ss.SetPeerTLS(certs.ClientConfig())
+	}
+	huacache.RegisterListener("bluebell", ss)
+	serve("bluebell", func() error { return ss.Run(engineOptions()...) }, errs)
+	return ss
 }
 
-func NewTCPPool(wg *sync.WaitGroup) {
-	defer wg.Done()
-	ss := protocol.NewBluebellServer("tcp", "0.0.0.0:9000", true)
-	options := []gnet.Option{
-		gnet.WithMulticore(true),               // 启用多核模式
-		gnet.WithReusePort(true),               // 启用端口重用
-		gnet.WithTCPKeepAlive(time.Minute * 5), // 启用 TCP keep-alive
-		gnet.WithReadBufferCap(2048 * 1024),
-		gnet.WithWriteBufferCap(2048 * 1024),
+// NewMemcachedPool serves the memcached protocol, its writes go through the
+// Bluebell server ss when there is one.
+func NewMemcachedPool(ss *protocol.BluebellServer, errs chan<- error) *protocol.MemcachedServer {
+	mc := cfg.Memcached
+	ensureGroup(mc.Group, int64(mc.Capacity))
+	ms := protocol.NewMemcachedServer("tcp", mc.Addr, cfg.Engine.Multicore, mc.Group)
+	ms.MaxValueSize = int(cfg.Limits.MaxFrame)
+	if ss != nil {
+		ms.SetStore(ss.Store())
 	}
-	err := gnet.Run(ss, ss.Network+"://"+ss.Addr, options...)
-	logging.Infof("server exits with error: %v", err)
+	huacache.RegisterListener("memcached", ms)
+	serve("memcached", func() error { return gnet.Run(ms, ms.Network+"://"+ms.Addr, engineOptions()...) }, errs)
+	return ms
 }
 
-func main() {
+// NewRespPool serves the RESP protocol, its writes go through the Bluebell
+// server ss when there is one.
+func NewRespPool(ss *protocol.BluebellServer, acl *huacache.ACL, errs chan<- error) *protocol.RespServer {
+	r := cfg.Resp
+	ensureGroup(r.Group, int64(r.Capacity))
+	rs := protocol.NewRespServer("tcp", r.Addr, cfg.Engine.Multicore, r.Group)
+	rs.MaxValueSize = int(cfg.Limits.MaxFrame)
+	if acl != nil {
+		rs.SetACL(acl)
+	}
+	if ss != nil {
+		rs.SetStore(ss.Store())
+	}
+	huacache.RegisterListener("resp", rs)
+	serve("resp", func() error { return gnet.Run(rs, rs.Network+"://"+rs.Addr, engineOptions()...) }, errs)
+	return rs
+}
+
+// NewMetricsPool serves the Prometheus metrics of the groups and of the
+// Bluebell server, if any, at /metrics.
+func NewMetricsPool(ss *protocol.BluebellServer, errs chan<- error) *http.Server {
+	collectors := []metrics.Collector{huacache.CollectGroups}
+	if ss != nil {
+		collectors = append(collectors, ss.CollectMetrics)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(collectors...))
+	server := &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+	log.Println("metrics are served at", cfg.Metrics.Addr+"/metrics")
+	serve("metrics", server.ListenAndServe, errs)
+	return server
+}
+
+// server 是可以优雅关闭的监听，Shutdown 在 ctx 结束前排空连接
+type server interface {
+	Shutdown(ctx context.Context) error
+}
+
+// serve 在后台运行 run，监听意外退出时把错误发送到 errs
+func serve(name string, run func() error, errs chan<- error) {
+	go func() {
+		err := run()
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		errs <- fmt.Errorf("%s: %w", name, err)
+	}()
+}
+
+// shutdown stops every listener within the configured timeout, then saves
+// the last snapshot and closes the aof. It reports whether everything was
+// drained and saved in time.
+func shutdown(servers []server, snapshotter *huacache.Snapshotter, aof *protocol.AOF) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	var ok atomic.Bool
+	ok.Store(true)
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go NewTCPPool(&wg)
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("failed to drain %T: %v", s, err)
+				ok.Store(false)
+			}
+		}(s)
+	}
 	wg.Wait()
+
+	if snapshotter != nil {
+		snapshotter.Stop()
+		saved := make(chan error, 1)
+		go func() { saved <- snapshotter.Save() }()
+		select {
+		case err := <-saved:
+			if err != nil {
+				log.Printf("failed to save the last snapshot: %v", err)
+				ok.Store(false)
+			}
+		case <-ctx.Done():
+			log.Printf("gave up on the last snapshot: %v", ctx.Err())
+			ok.Store(false)
+		}
+	}
+	if aof != nil {
+		if err := aof.Close(); err != nil {
+			log.Printf("failed to close aof: %v", err)
+			ok.Store(false)
+		}
+	}
+	return ok.Load()
+}
+
+func main() {
+	var err error
+	cfg, err = config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	huacache.SetDefaultShards(cfg.Limits.Shards)
+	huacache.SetStatsConfig(cfg.Settings())
+	cluster := newCluster()
+	declareGroups()
+	snapshotter := newSnapshotter()
+	aof := openAOF()
+	acl := loadACL()
+	certs, clientAuth := newCertReloader()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	errs := make(chan error, 5)
+	var servers []server
+	var ss *protocol.BluebellServer
+	if cfg.Bluebell.Addr != "" {
+		ss = NewTCPPool(cluster, snapshotter, aof, acl, certs, clientAuth, errs)
+		servers = append(servers, ss)
+	}
+	if cfg.HTTP.Addr != "" {
+		servers = append(servers, NewHTTPPool(ss, cluster, acl, certs, clientAuth, errs))
+	}
+	if cfg.Memcached.Addr != "" {
+		servers = append(servers, NewMemcachedPool(ss, errs))
+	}
+	if cfg.Resp.Addr != "" {
+		servers = append(servers, NewRespPool(ss, acl, errs))
+	}
+	if cfg.Metrics.Addr != "" {
+		servers = append(servers, NewMetricsPool(ss, errs))
+	}
+
+	code := 0
+	select {
+	case sig := <-signals:
+		log.Printf("received %v, shutting down within %v", sig, cfg.Shutdown.Timeout)
+	case err := <-errs:
+		log.Printf("server exits with error: %v, shutting down", err)
+		code = 1
+	}
+	if !shutdown(servers, snapshotter, aof) {
+		code = 1
+	}
+	log.Println("bye")
+	os.Exit(code)
 }